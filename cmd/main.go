@@ -2,16 +2,21 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mserebryaakov/aggregator-order-service/config"
+	"github.com/mserebryaakov/aggregator-order-service/internal/health"
 	"github.com/mserebryaakov/aggregator-order-service/internal/order"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/httpclient"
 	"github.com/mserebryaakov/aggregator-order-service/pkg/httpserver"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/lifecycle"
 	"github.com/mserebryaakov/aggregator-order-service/pkg/logger"
 	"github.com/mserebryaakov/aggregator-order-service/pkg/postgres"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/tenant"
 )
 
 func main() {
@@ -36,6 +41,11 @@ func main() {
 		DBName:   env.PgDbName,
 		SSLMode:  env.SSLMode,
 		TimeZone: env.TimeZone,
+
+		MaxOpenConns:    cfg.Postgres.MaxOpenConns,
+		MaxIdleConns:    cfg.Postgres.MaxIdleConns,
+		ConnMaxLifetime: cfg.Postgres.ConnMaxLifetime,
+		TenantIdleTTL:   cfg.Postgres.TenantIdleTTL,
 	}
 
 	scp := postgres.NewSchemaConnectionPool(postgresConfig, log)
@@ -44,40 +54,148 @@ func main() {
 		log.Fatalf("failed connection to db: %v", err)
 	}
 
-	orderRepository := order.NewStorage(scp)
+	orderRepository := order.NewStorage(scp, cfg.Timeouts.Database)
 	orderService := order.NewService(orderRepository, orderLog)
 
 	authAdapterLog := logger.NewLogger("debug", &order.AuthAdapterLogHook{})
-	authAdapter := order.NewAuthAdapter(authAdapterLog, env.AuthHost, env.AuthPort)
+	authClient := httpclient.New(httpClientConfig(cfg.HTTPClient, "auth"), authAdapterLog)
+	authAdapter := order.NewAuthAdapter(authAdapterLog, env.AuthHost, env.AuthPort, authClient, cfg.Timeouts.Auth,
+		order.NewAuthCache(cfg.AuthCache.TTL, cfg.AuthCache.NegativeTTL, cfg.AuthCache.MaxEntries, cfg.AuthCache.MaxNegativeEntries))
 
-	err = authAdapter.Login(env.SupervisorEmail, env.SupervisorHashPassword, "public")
+	err = authAdapter.Login(context.Background(), env.SupervisorEmail, env.SupervisorHashPassword, "public")
 	if err != nil {
 		log.Fatalf("failed login in auth service: %v", err)
 	}
 
+	var authenticator order.Authenticator
+	switch cfg.Auth.Mode {
+	case order.RemoteAuthMode, "":
+		authenticator = authAdapter
+	case order.LocalAuthMode:
+		jwksURL := cfg.Auth.JWKSURL
+		if jwksURL == "" {
+			jwksURL = fmt.Sprintf("http://%s%s%s", env.AuthHost, env.AuthPort, "/system/auth/jwks")
+		}
+		jwtVerifierLog := logger.NewLogger("debug", &order.JWTVerifierLogHook{})
+		authenticator = order.NewJWTVerifier(jwtVerifierLog, jwksURL, authClient, cfg.Auth.JWKSRefreshInterval, cfg.Timeouts.Auth)
+	default:
+		log.Fatalf("unknown auth mode - %s", cfg.Auth.Mode)
+	}
+
 	router := gin.New()
 
 	paymentAdapterLog := logger.NewLogger("debug", &order.PaymentAdapterLogHook{})
-	paymentAdapter := order.NewPaymentAdapter(paymentAdapterLog, env.PaymentHost, env.PaymentPort)
+	paymentClient := httpclient.New(httpClientConfig(cfg.HTTPClient, "payment"), paymentAdapterLog)
+	paymentAdapter := order.NewPaymentAdapter(paymentAdapterLog, env.PaymentHost, env.PaymentPort, paymentClient, cfg.Timeouts.Payment)
+
+	providerNames := cfg.Payment.Providers
+	if len(providerNames) == 0 {
+		providerNames = []string{order.PaymentServiceProvider}
+	}
+	providers := make([]order.PaymentProvider, 0, len(providerNames))
+	for _, name := range providerNames {
+		switch name {
+		case order.PaymentServiceProvider:
+			providers = append(providers, paymentAdapter)
+		case order.FakeProvider:
+			providers = append(providers, order.NewFakeProvider())
+		default:
+			log.Fatalf("unknown payment provider - %s", name)
+		}
+	}
+
+	defaultProvider := cfg.Payment.DefaultProvider
+	if defaultProvider == "" {
+		defaultProvider = order.PaymentServiceProvider
+	}
+	payments, err := order.NewPaymentProviders(defaultProvider, providers...)
+	if err != nil {
+		log.Fatalf("failed to create payment providers: %v", err)
+	}
+
+	migrator := postgres.NewMigrator(scp, order.Migrations, log)
+	tenants := tenant.NewRegistry(migrator.MigratedSchemas, cfg.Tenant.RefreshInterval, log)
+	if err := tenants.Refresh(); err != nil {
+		log.Fatalf("failed to load tenants: %v", err)
+	}
+
+	resolver, err := tenant.NewResolver(tenant.ResolverConfig{
+		Strategy:       cfg.Tenant.Resolver,
+		BaseDomain:     cfg.Tenant.BaseDomain,
+		Header:         cfg.Tenant.Header,
+		TrustedProxies: cfg.Tenant.TrustedProxies,
+		PathPrefix:     cfg.Tenant.PathPrefix,
+		QueryParam:     cfg.Tenant.QueryParam,
+	})
+	if err != nil {
+		log.Fatalf("failed to create tenant resolver: %v", err)
+	}
+
+	tenantService := order.NewTenantService(migrator, tenants, orderRepository, authAdapter, orderLog)
+
+	for _, id := range tenants.List() {
+		if err := orderRepository.EnsureStatuses(context.Background(), id.String()); err != nil {
+			log.Errorf("failed to ensure statuses in schema %s: %v", id, err)
+		}
+	}
 
-	orderHandler := order.NewHandler(orderService, orderLog, authAdapter, paymentAdapter, env.PaymentRedirectURL)
+	orderHandler := order.NewHandler(orderService, tenantService, tenants, resolver, orderLog, authenticator, payments, env.PaymentRedirectURL)
 	orderHandler.Register(router)
 
-	server := new(httpserver.Server)
+	healthLog := logger.NewLogger(env.LogLvl, &health.HealthLogHook{})
+	healthHandler := health.NewHandler(5*time.Second, healthLog)
+	healthHandler.AddCheck("postgres", scp.Ping)
+	healthHandler.AddCheck("auth", authAdapter.CheckSystemToken)
+	if payments.Has(order.PaymentServiceProvider) {
+		healthHandler.AddCheck("payment", paymentAdapter.Ping)
+	}
+	healthHandler.Register(router)
 
-	go func() {
-		if err := server.Run(cfg.Server.Port, router); err != nil {
-			log.Fatalf("Failed running server %v", err)
+	tenantSchemas := func() []string {
+		ids := tenants.List()
+		schemas := make([]string, 0, len(ids))
+		for _, id := range ids {
+			schemas = append(schemas, id.String())
 		}
-	}()
+		return schemas
+	}
 
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	reservationWorker := order.NewReservationWorker(orderService, payments, tenantSchemas,
+		cfg.Reservation.TTL, cfg.Reservation.CheckInterval, cfg.Reservation.AuthorizationTTL, cfg.Reservation.AuthorizationCancel, orderLog)
 
-	oscall := <-interrupt
-	log.Infof("Shutdown server, %s", oscall)
+	lm := lifecycle.New(cfg.Server.ShutdownTimeout, log)
+
+	lm.OnStop("postgres", func(ctx context.Context) error {
+		return scp.Close()
+	})
+
+	lm.Go("postgres health check", func(ctx context.Context) {
+		scp.RunHealthCheck(ctx, cfg.Postgres.HealthCheckInterval, cfg.Postgres.HealthMaxBackoff)
+	})
+	lm.Go("postgres eviction", scp.RunEviction)
+	lm.Go("tenant registry", tenants.Run)
+	lm.Go("reservation worker", reservationWorker.Run)
+
+	var handler http.Handler = router
+	if pathResolver, ok := resolver.(*tenant.PathResolver); ok {
+		handler = pathResolver.StripPrefix(router)
+	}
+
+	server := new(httpserver.Server)
+	lm.Serve("http server", func() error {
+		return server.Run(cfg.Server.Port, handler)
+	}, server.Shutdown)
+
+	os.Exit(lm.Wait())
+}
 
-	if err := server.Shutdown(context.Background()); err != nil {
-		log.Errorf("Error occured on server shutting down: %v", err)
+func httpClientConfig(cfg config.HTTPClientConfig, name string) httpclient.Config {
+	return httpclient.Config{
+		Name:             name,
+		MaxRetries:       cfg.MaxRetries,
+		BaseBackoff:      cfg.BaseBackoff,
+		MaxBackoff:       cfg.MaxBackoff,
+		FailureThreshold: cfg.FailureThreshold,
+		OpenTimeout:      cfg.OpenTimeout,
 	}
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/mserebryaakov/aggregator-order-service/config"
+	"github.com/mserebryaakov/aggregator-order-service/internal/order"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/logger"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/postgres"
+)
+
+// Применение миграций к схемам тенантов:
+//
+//	migrate                     - все схемы
+//	migrate -schemas shop1,shop2 - выбранные схемы
+//	migrate -schemas shop3 -create - создать схему, если её нет
+func main() {
+	schemasFlag := flag.String("schemas", "", "comma-separated tenant schemas (all if empty)")
+	create := flag.Bool("create", false, "create missing schemas")
+	flag.Parse()
+
+	log := logger.NewLogger("debug", &logger.MainLogHook{})
+
+	env, err := config.GetPostgresEnvironment()
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+
+	postgresConfig := postgres.Config{
+		Host:     env.PgHost,
+		Port:     env.PgPort,
+		Username: env.PgUser,
+		Password: env.PgPassword,
+		DBName:   env.PgDbName,
+		SSLMode:  env.SSLMode,
+		TimeZone: env.TimeZone,
+	}
+
+	scp := postgres.NewSchemaConnectionPool(postgresConfig, log)
+	migrator := postgres.NewMigrator(scp, order.Migrations, log)
+
+	var schemas []string
+	if *schemasFlag != "" {
+		for _, schema := range strings.Split(*schemasFlag, ",") {
+			if schema = strings.TrimSpace(schema); schema != "" {
+				schemas = append(schemas, schema)
+			}
+		}
+	} else {
+		schemas, err = migrator.TenantSchemas()
+		if err != nil {
+			log.Fatalf("failed to list tenant schemas: %v", err)
+		}
+	}
+
+	failed := false
+	for _, schema := range schemas {
+		if *create {
+			err = migrator.Provision(schema)
+		} else {
+			_, err = migrator.Migrate(schema)
+		}
+
+		if err != nil {
+			log.Errorf("failed migrate schema %s: %v", schema, err)
+			failed = true
+			continue
+		}
+
+		log.Infof("schema %s is up to date", schema)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -1,15 +1,96 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 type ServerConfig struct {
-	Port string `mapstructure:"port"`
+	Port            string        `mapstructure:"port"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+}
+
+type ReservationConfig struct {
+	TTL           time.Duration `mapstructure:"ttl"`
+	CheckInterval time.Duration `mapstructure:"check_interval"`
+
+	// Удержанные платежи: срок удержания (если шлюз его не сообщил)
+	// и запас до истечения, когда удержание отменяется
+	AuthorizationTTL    time.Duration `mapstructure:"authorization_ttl"`
+	AuthorizationCancel time.Duration `mapstructure:"authorization_cancel_before"`
+}
+
+type PostgresConfig struct {
+	MaxOpenConns    int           `mapstructure:"max_open_conns"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+	TenantIdleTTL   time.Duration `mapstructure:"tenant_idle_ttl"`
+
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
+	HealthMaxBackoff    time.Duration `mapstructure:"health_max_backoff"`
+}
+
+type TenantConfig struct {
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+
+	// Способ определения тенанта: subdomain, header, path, query
+	Resolver       string   `mapstructure:"resolver"`
+	BaseDomain     string   `mapstructure:"base_domain"`
+	Header         string   `mapstructure:"header"`
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	PathPrefix     string   `mapstructure:"path_prefix"`
+	QueryParam     string   `mapstructure:"query_param"`
+}
+
+// Проверка токенов клиентов: remote - запрос в сервис авторизации,
+// local - проверка подписи JWT по ключам JWKS
+type AuthConfig struct {
+	Mode                string        `mapstructure:"mode"`
+	JWKSURL             string        `mapstructure:"jwks_url"`
+	JWKSRefreshInterval time.Duration `mapstructure:"jwks_refresh_interval"`
+}
+
+// Кэш проверок токенов в сервисе авторизации
+type AuthCacheConfig struct {
+	TTL                time.Duration `mapstructure:"ttl"`
+	NegativeTTL        time.Duration `mapstructure:"negative_ttl"`
+	MaxEntries         int           `mapstructure:"max_entries"`
+	MaxNegativeEntries int           `mapstructure:"max_negative_entries"` // Лимит отказов (401/403); 0 - десятая часть max_entries
+}
+
+// Повторы и автомат отключения при обращении к внешним сервисам
+type HTTPClientConfig struct {
+	MaxRetries       int           `mapstructure:"max_retries"`
+	BaseBackoff      time.Duration `mapstructure:"base_backoff"`
+	MaxBackoff       time.Duration `mapstructure:"max_backoff"`
+	FailureThreshold int           `mapstructure:"failure_threshold"`
+	OpenTimeout      time.Duration `mapstructure:"open_timeout"`
+}
+
+// Платёжные шлюзы: включённые шлюзы и шлюз по умолчанию для магазинов без настроек
+type PaymentConfig struct {
+	DefaultProvider string   `mapstructure:"default_provider"`
+	Providers       []string `mapstructure:"providers"`
+}
+
+// Ограничения времени операций
+type TimeoutConfig struct {
+	Database time.Duration `mapstructure:"database"`
+	Auth     time.Duration `mapstructure:"auth"`
+	Payment  time.Duration `mapstructure:"payment"`
 }
 
 type Config struct {
-	Server ServerConfig `mapstructure:"server"`
+	Server      ServerConfig      `mapstructure:"server"`
+	Postgres    PostgresConfig    `mapstructure:"postgres"`
+	Tenant      TenantConfig      `mapstructure:"tenant"`
+	Reservation ReservationConfig `mapstructure:"reservation"`
+	Timeouts    TimeoutConfig     `mapstructure:"timeouts"`
+	Auth        AuthConfig        `mapstructure:"auth"`
+	AuthCache   AuthCacheConfig   `mapstructure:"auth_cache"`
+	HTTPClient  HTTPClientConfig  `mapstructure:"http_client"`
+	Payment     PaymentConfig     `mapstructure:"payment"`
 }
 
 var vp *viper.Viper
@@ -28,6 +28,24 @@ type AppEnv struct {
 }
 
 func GetEnvironment() (env AppEnv, err error) {
+	env, err = GetPostgresEnvironment()
+	if err != nil {
+		return env, err
+	}
+
+	if env.SupervisorEmail == "" || env.SupervisorHashPassword == "" {
+		return env, fmt.Errorf("incorrect environment params")
+	}
+
+	if env.PaymentRedirectURL == "" {
+		return env, fmt.Errorf("incorrect environment params")
+	}
+
+	return env, nil
+}
+
+// Окружение с проверкой только параметров подключения к postgres (для утилит)
+func GetPostgresEnvironment() (env AppEnv, err error) {
 	env = AppEnv{
 		LogLvl:                 getEnv("LOG_LEVEL", "debug"),
 		PgHost:                 getEnv("POSTGRES_HOST", ""),
@@ -51,14 +69,6 @@ func GetEnvironment() (env AppEnv, err error) {
 		return env, fmt.Errorf("incorrect environment params")
 	}
 
-	if env.SupervisorEmail == "" || env.SupervisorHashPassword == "" {
-		return env, fmt.Errorf("incorrect environment params")
-	}
-
-	if env.PaymentRedirectURL == "" {
-		return env, fmt.Errorf("incorrect environment params")
-	}
-
 	return env, nil
 }
 
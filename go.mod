@@ -3,6 +3,7 @@ module github.com/mserebryaakov/aggregator-order-service
 go 1.20
 
 require (
+	github.com/jackc/pgx/v5 v5.3.0
 	github.com/spf13/viper v1.15.0
 	gorm.io/gorm v1.25.1
 )
@@ -17,7 +18,6 @@ require (
 	github.com/goccy/go-json v0.10.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
-	github.com/jackc/pgx/v5 v5.3.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
@@ -0,0 +1,130 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type HealthLogHook struct{}
+
+func (h *HealthLogHook) Fire(entry *logrus.Entry) error {
+	entry.Message = "Health: " + entry.Message
+	return nil
+}
+
+func (h *HealthLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Проверка зависимости сервиса (nil - зависимость доступна)
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Результат проверки одной зависимости. Текст ошибки только логируется: ответ /readyz
+// доступен без авторизации и не должен раскрывать адреса и детали инфраструктуры.
+type CheckResult struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type healthHandler struct {
+	checks  []namedCheck
+	timeout time.Duration
+	log     *logrus.Entry
+}
+
+func NewHandler(timeout time.Duration, log *logrus.Entry) *healthHandler {
+	return &healthHandler{
+		timeout: timeout,
+		log:     log,
+	}
+}
+
+func (h *healthHandler) AddCheck(name string, check Check) {
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// Маршруты регистрируются вне групп с авторизацией
+func (h *healthHandler) Register(router *gin.Engine) {
+	router.GET("/healthz", h.Live)
+	router.GET("/readyz", h.Ready)
+}
+
+// Процесс запущен и обрабатывает запросы
+func (h *healthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": StatusOK,
+	})
+}
+
+// Готовность к приёму запросов: все зависимости доступны
+func (h *healthHandler) Ready(c *gin.Context) {
+	report := h.Check(c.Request.Context())
+
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, report)
+}
+
+// Параллельная проверка всех зависимостей
+func (h *healthHandler) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	report := Report{
+		Status: StatusOK,
+		Checks: make(map[string]CheckResult, len(h.checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range h.checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := nc.check(ctx)
+			result := CheckResult{
+				Status:    StatusOK,
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				h.log.Errorf("Check: %s err - %v", nc.name, err)
+				result.Status = StatusUnavailable
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[nc.name] = result
+			if err != nil {
+				report.Status = StatusUnavailable
+			}
+		}(nc)
+	}
+	wg.Wait()
+
+	return report
+}
@@ -0,0 +1,111 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func testHandler(timeout time.Duration, checks map[string]Check) *healthHandler {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	h := NewHandler(timeout, logrus.NewEntry(logger))
+	for name, check := range checks {
+		h.AddCheck(name, check)
+	}
+	return h
+}
+
+func ok(context.Context) error { return nil }
+
+func failing(context.Context) error { return errors.New("dial tcp 10.0.0.5:5432: connection refused") }
+
+// Проверка, не укладывающаяся в общий срок
+func hanging(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestReady(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		checks map[string]Check
+		code   int
+		status string
+		failed []string
+	}{
+		{name: "no checks", code: http.StatusOK, status: StatusOK},
+		{name: "all available", checks: map[string]Check{"postgres": ok, "auth": ok}, code: http.StatusOK, status: StatusOK},
+		{name: "one unavailable", checks: map[string]Check{"postgres": failing, "auth": ok}, code: http.StatusServiceUnavailable, status: StatusUnavailable, failed: []string{"postgres"}},
+		{name: "timeout", checks: map[string]Check{"postgres": ok, "payment": hanging}, code: http.StatusServiceUnavailable, status: StatusUnavailable, failed: []string{"payment"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			testHandler(50*time.Millisecond, tt.checks).Register(router)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if w.Code != tt.code {
+				t.Errorf("code = %d, want %d", w.Code, tt.code)
+			}
+			// Детали ошибок не попадают в ответ
+			if strings.Contains(w.Body.String(), "10.0.0.5") {
+				t.Errorf("response exposes check error - %s", w.Body.String())
+			}
+
+			var report Report
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.status {
+				t.Errorf("status = %s, want %s", report.Status, tt.status)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Errorf("checks = %v, want %d", report.Checks, len(tt.checks))
+			}
+
+			failed := make(map[string]bool, len(tt.failed))
+			for _, name := range tt.failed {
+				failed[name] = true
+			}
+			for name, result := range report.Checks {
+				want := StatusOK
+				if failed[name] {
+					want = StatusUnavailable
+				}
+				if result.Status != want {
+					t.Errorf("%s: status = %s, want %s", name, result.Status, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	testHandler(time.Second, map[string]Check{"postgres": failing}).Register(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("code = %d, want %d", w.Code, http.StatusOK)
+	}
+}
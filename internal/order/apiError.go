@@ -0,0 +1,198 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/httpclient"
+)
+
+// Машиночитаемый код ошибки API. Значения стабильны: клиенты опираются на код, а не на текст сообщения.
+type ErrorCode string
+
+const (
+	ErrCodeInvalidRequest         ErrorCode = "INVALID_REQUEST"
+	ErrCodeInvalidBody            ErrorCode = "INVALID_BODY"
+	ErrCodeUnauthorized           ErrorCode = "UNAUTHORIZED"
+	ErrCodeForbidden              ErrorCode = "FORBIDDEN"
+	ErrCodeNotFound               ErrorCode = "NOT_FOUND"
+	ErrCodeConflict               ErrorCode = "CONFLICT"
+	ErrCodeInternal               ErrorCode = "INTERNAL_ERROR"
+	ErrCodeTimeout                ErrorCode = "TIMEOUT"
+	ErrCodeUpstreamUnavailable    ErrorCode = "UPSTREAM_UNAVAILABLE"
+	ErrCodeTenantNotDefined       ErrorCode = "TENANT_NOT_DEFINED"
+	ErrCodeTenantNotFound         ErrorCode = "TENANT_NOT_FOUND"
+	ErrCodeTenantInvalidDomain    ErrorCode = "TENANT_INVALID_DOMAIN"
+	ErrCodeTenantAlreadyExists    ErrorCode = "TENANT_ALREADY_EXISTS"
+	ErrCodeUserNotDefined         ErrorCode = "USER_NOT_DEFINED"
+	ErrCodeOrderNotFound          ErrorCode = "ORDER_NOT_FOUND"
+	ErrCodeOrderNotAvailable      ErrorCode = "ORDER_NOT_AVAILABLE"
+	ErrCodeCheckoutNotFound       ErrorCode = "CHECKOUT_NOT_FOUND"
+	ErrCodeReorderModeUnknown     ErrorCode = "REORDER_MODE_UNKNOWN"
+	ErrCodeCartEmpty              ErrorCode = "CART_EMPTY"
+	ErrCodeOutOfStock             ErrorCode = "OUT_OF_STOCK"
+	ErrCodePromoCodeNotFound      ErrorCode = "PROMO_CODE_NOT_FOUND"
+	ErrCodePromoCodeInactive      ErrorCode = "PROMO_CODE_INACTIVE"
+	ErrCodePromoCodeMinOrder      ErrorCode = "PROMO_CODE_MIN_ORDER_VALUE"
+	ErrCodePromoCodeUsageLimit    ErrorCode = "PROMO_CODE_USAGE_LIMIT"
+	ErrCodePromoCodeNotApplied    ErrorCode = "PROMO_CODE_NOT_APPLICABLE"
+	ErrCodePaymentInvalid         ErrorCode = "PAYMENT_REQUEST_INVALID"
+	ErrCodePaymentNotFound        ErrorCode = "PAYMENT_NOT_FOUND"
+	ErrCodePaymentConflict        ErrorCode = "PAYMENT_CONFLICT"
+	ErrCodePaymentUpstream        ErrorCode = "PAYMENT_UPSTREAM_FAILED"
+	ErrCodePaymentUnavailable     ErrorCode = "PAYMENT_UPSTREAM_UNAVAILABLE"
+	ErrCodePaymentProviderUnknown ErrorCode = "PAYMENT_PROVIDER_UNKNOWN"
+	ErrCodeCaptureModeUnknown     ErrorCode = "CAPTURE_MODE_UNKNOWN"
+	ErrCodePaymentMethodUnknown   ErrorCode = "PAYMENT_METHOD_UNKNOWN"
+	ErrCodeCollectedAmount        ErrorCode = "COLLECTED_AMOUNT_REQUIRED"
+	ErrCodeOrderNotPayable        ErrorCode = "ORDER_NOT_PAYABLE"
+	ErrCodeOrderAlreadyPaid       ErrorCode = "ORDER_ALREADY_PAID"
+	ErrCodeOrderNotCancelable     ErrorCode = "ORDER_NOT_CANCELABLE"
+)
+
+// Ошибка API: код ответа, код из каталога, сообщение для клиента и дополнительные данные
+type APIError struct {
+	HTTPCode int
+	Code     ErrorCode
+	Message  string
+	Details  interface{}
+}
+
+func newAPIError(httpCode int, code ErrorCode, message string) *APIError {
+	return &APIError{
+		HTTPCode: httpCode,
+		Code:     code,
+		Message:  message,
+	}
+}
+
+func (e *APIError) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// Копия ошибки с дополнительными данными
+func (e *APIError) WithDetails(details interface{}) *APIError {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+var (
+	apiErrInvalidBody      = newAPIError(http.StatusBadRequest, ErrCodeInvalidBody, "failed to read body")
+	apiErrTenantNotDefined = newAPIError(http.StatusBadRequest, ErrCodeTenantNotDefined, "domain is not defined")
+	apiErrTenantNotFound   = newAPIError(http.StatusNotFound, ErrCodeTenantNotFound, "tenant not found")
+	apiErrUserNotDefined   = newAPIError(http.StatusBadRequest, ErrCodeUserNotDefined, "user is not defined")
+	apiErrUnauthorized     = newAPIError(http.StatusUnauthorized, ErrCodeUnauthorized, "unauthorized")
+	apiErrForbidden        = newAPIError(http.StatusForbidden, ErrCodeForbidden, "forbidden")
+	apiErrInternal         = newAPIError(http.StatusInternalServerError, ErrCodeInternal, "internal server error")
+	apiErrTimeout          = newAPIError(http.StatusGatewayTimeout, ErrCodeTimeout, "request timed out")
+	apiErrUnavailable      = newAPIError(http.StatusServiceUnavailable, ErrCodeUpstreamUnavailable, "dependent service is unavailable")
+)
+
+// Ошибки из error.go, сообщения которых можно показывать клиенту.
+// Ошибки конфигурации магазина отображаются в INTERNAL_ERROR явно и пишутся в лог там, где возникли.
+var sentinelAPIErrors = map[error]*APIError{
+	errOrderWithCourierNotFound:          newAPIError(http.StatusNotFound, ErrCodeOrderNotFound, "order not found"),
+	errOrderWithUserIdAndOrderIdNotFound: newAPIError(http.StatusNotFound, ErrCodeOrderNotFound, "order not found"),
+	errTakeOrderNotFound:                 newAPIError(http.StatusNotFound, ErrCodeOrderNotAvailable, errTakeOrderNotFound.Error()),
+	errChangePaymentIdNotFound:           newAPIError(http.StatusNotFound, ErrCodeCheckoutNotFound, "checkout not found"),
+	errCheckoutWithPaymentKeyNotfound:    newAPIError(http.StatusNotFound, ErrCodeCheckoutNotFound, "checkout not found"),
+	errCheckoutWithPaymentIdNotFound:     newAPIError(http.StatusNotFound, ErrCodeCheckoutNotFound, "checkout not found"),
+	errAcceptOrderNotFound:               newAPIError(http.StatusNotFound, ErrCodeOrderNotAvailable, errAcceptOrderNotFound.Error()),
+	errPaymentProviderUnknown:            newAPIError(http.StatusBadRequest, ErrCodePaymentProviderUnknown, errPaymentProviderUnknown.Error()),
+	errCaptureModeUnknown:                newAPIError(http.StatusBadRequest, ErrCodeCaptureModeUnknown, errCaptureModeUnknown.Error()),
+	errPaymentMethodUnknown:              newAPIError(http.StatusBadRequest, ErrCodePaymentMethodUnknown, errPaymentMethodUnknown.Error()),
+	errCollectedAmountRequired:           newAPIError(http.StatusBadRequest, ErrCodeCollectedAmount, errCollectedAmountRequired.Error()),
+	errOrderNotPayable:                   newAPIError(http.StatusConflict, ErrCodeOrderNotPayable, errOrderNotPayable.Error()),
+	errOrderAlreadyPaid:                  newAPIError(http.StatusConflict, ErrCodeOrderAlreadyPaid, errOrderAlreadyPaid.Error()),
+	errPaymentRenewConflict:              newAPIError(http.StatusConflict, ErrCodePaymentConflict, errPaymentRenewConflict.Error()),
+	errOrderNotCancelable:                newAPIError(http.StatusConflict, ErrCodeOrderNotCancelable, errOrderNotCancelable.Error()),
+	errPaymentStatusConflict:             newAPIError(http.StatusConflict, ErrCodePaymentConflict, errPaymentStatusConflict.Error()),
+	errUnknownReorderMode:                newAPIError(http.StatusBadRequest, ErrCodeReorderModeUnknown, errUnknownReorderMode.Error()),
+	errTenantInvalidRequest:              newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, errTenantInvalidRequest.Error()),
+	errTenantInvalidDomain:               newAPIError(http.StatusBadRequest, ErrCodeTenantInvalidDomain, errTenantInvalidDomain.Error()),
+	errTenantAlreadyExists:               newAPIError(http.StatusConflict, ErrCodeTenantAlreadyExists, errTenantAlreadyExists.Error()),
+	errCartEmpty:                         newAPIError(http.StatusBadRequest, ErrCodeCartEmpty, errCartEmpty.Error()),
+	errPromoCodeNotFound:                 newAPIError(http.StatusNotFound, ErrCodePromoCodeNotFound, errPromoCodeNotFound.Error()),
+	errPromoCodeInactive:                 newAPIError(http.StatusBadRequest, ErrCodePromoCodeInactive, errPromoCodeInactive.Error()),
+	errPromoCodeMinOrderValue:            newAPIError(http.StatusBadRequest, ErrCodePromoCodeMinOrder, errPromoCodeMinOrderValue.Error()),
+	errPromoCodeUsageLimit:               newAPIError(http.StatusConflict, ErrCodePromoCodeUsageLimit, errPromoCodeUsageLimit.Error()),
+	errPromoCodeNotApplicable:            newAPIError(http.StatusBadRequest, ErrCodePromoCodeNotApplied, errPromoCodeNotApplicable.Error()),
+	errDeliveryDistanceInvalid:           newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, errDeliveryDistanceInvalid.Error()),
+	errDeliveryTariffUnknown:             apiErrInternal,
+	errStatusNotFound:                    apiErrInternal,
+}
+
+// Преобразование ошибки в ошибку API. Неизвестные ошибки становятся INTERNAL_ERROR
+// без текста исходной ошибки.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	for sentinel, apiErr := range sentinelAPIErrors {
+		if errors.Is(err, sentinel) {
+			return apiErr
+		}
+	}
+
+	var outOfStock *OutOfStockError
+	if errors.As(err, &outOfStock) {
+		return newAPIError(http.StatusConflict, ErrCodeOutOfStock, "out of stock").WithDetails(gin.H{"products": outOfStock.Products})
+	}
+
+	var gwErr *GatewayError
+	if errors.As(err, &gwErr) {
+		return gatewayAPIError(gwErr)
+	}
+
+	switch {
+	case errors.Is(err, httpclient.ErrCircuitOpen):
+		return apiErrUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return apiErrTimeout
+	}
+
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		switch appErr.HTTPCode {
+		case http.StatusBadRequest:
+			return newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "invalid request")
+		case http.StatusNotFound:
+			return newAPIError(http.StatusNotFound, ErrCodeNotFound, "not found")
+		case http.StatusConflict:
+			return newAPIError(http.StatusConflict, ErrCodeConflict, "conflict")
+		case http.StatusServiceUnavailable:
+			return apiErrUnavailable
+		}
+	}
+
+	return apiErrInternal
+}
+
+// Ошибки платёжного сервиса: описание передаётся клиенту только для ошибок запроса
+func gatewayAPIError(err *GatewayError) *APIError {
+	var apiErr *APIError
+	switch err.HTTPCode {
+	case http.StatusBadRequest:
+		apiErr = newAPIError(http.StatusBadRequest, ErrCodePaymentInvalid, "payment request rejected")
+	case http.StatusNotFound:
+		apiErr = newAPIError(http.StatusNotFound, ErrCodePaymentNotFound, "payment not found")
+	case http.StatusConflict:
+		apiErr = newAPIError(http.StatusConflict, ErrCodePaymentConflict, "payment conflict")
+	case http.StatusServiceUnavailable:
+		return newAPIError(http.StatusServiceUnavailable, ErrCodePaymentUnavailable, "payment service is unavailable").
+			WithDetails(gin.H{"retryable": err.Retryable})
+	default:
+		return newAPIError(http.StatusBadGateway, ErrCodePaymentUpstream, "payment service request failed").
+			WithDetails(gin.H{"retryable": err.Retryable})
+	}
+
+	if err.Description != "" {
+		apiErr.Details = gin.H{"description": err.Description}
+	}
+	return apiErr
+}
@@ -0,0 +1,77 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/httpclient"
+)
+
+func TestToAPIError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		httpCode int
+		code     ErrorCode
+	}{
+		{name: "api error", err: apiErrForbidden, httpCode: http.StatusForbidden, code: ErrCodeForbidden},
+		{name: "wrapped api error", err: fmt.Errorf("Auth: %w", apiErrUnauthorized), httpCode: http.StatusUnauthorized, code: ErrCodeUnauthorized},
+		{name: "order not found", err: fmt.Errorf("GetOrder: %w", errOrderWithUserIdAndOrderIdNotFound), httpCode: http.StatusNotFound, code: ErrCodeOrderNotFound},
+		{name: "checkout not found", err: errCheckoutWithPaymentIdNotFound, httpCode: http.StatusNotFound, code: ErrCodeCheckoutNotFound},
+		{name: "already paid", err: fmt.Errorf("PayOrder: %w", errOrderAlreadyPaid), httpCode: http.StatusConflict, code: ErrCodeOrderAlreadyPaid},
+		{name: "promo usage limit", err: errPromoCodeUsageLimit, httpCode: http.StatusConflict, code: ErrCodePromoCodeUsageLimit},
+		{name: "invalid distance", err: fmt.Errorf("%w: -1", errDeliveryDistanceInvalid), httpCode: http.StatusBadRequest, code: ErrCodeInvalidRequest},
+		{name: "missing status seed", err: fmt.Errorf("%w: waiting", errStatusNotFound), httpCode: http.StatusInternalServerError, code: ErrCodeInternal},
+		{name: "circuit open", err: fmt.Errorf("auth: %w", httpclient.ErrCircuitOpen), httpCode: http.StatusServiceUnavailable, code: ErrCodeUpstreamUnavailable},
+		{name: "deadline", err: fmt.Errorf("GetOrders: %w", context.DeadlineExceeded), httpCode: http.StatusGatewayTimeout, code: ErrCodeTimeout},
+		{name: "app error bad request", err: NewError(HttpError, "bad id", http.StatusBadRequest, nil), httpCode: http.StatusBadRequest, code: ErrCodeInvalidRequest},
+		{name: "app error not found", err: NewError(HttpError, "no user", http.StatusNotFound, nil), httpCode: http.StatusNotFound, code: ErrCodeNotFound},
+		{name: "app error conflict", err: NewError(HttpError, "exists", http.StatusConflict, nil), httpCode: http.StatusConflict, code: ErrCodeConflict},
+		{name: "app error unavailable", err: NewError(ServerAppError, "auth down", http.StatusServiceUnavailable, nil), httpCode: http.StatusServiceUnavailable, code: ErrCodeUpstreamUnavailable},
+		{name: "app error internal", err: NewError(ServerAppError, "auth failed", http.StatusInternalServerError, nil), httpCode: http.StatusInternalServerError, code: ErrCodeInternal},
+		{name: "unknown", err: errors.New(`pq: relation "shop1.orders" does not exist`), httpCode: http.StatusInternalServerError, code: ErrCodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := toAPIError(tt.err)
+			if apiErr.HTTPCode != tt.httpCode || apiErr.Code != tt.code {
+				t.Errorf("api error = %d %s, want %d %s", apiErr.HTTPCode, apiErr.Code, tt.httpCode, tt.code)
+			}
+		})
+	}
+}
+
+// Каждая ошибка каталога распознаётся и после оборачивания
+func TestToAPIErrorSentinels(t *testing.T) {
+	for sentinel, want := range sentinelAPIErrors {
+		got := toAPIError(fmt.Errorf("handler: %w", sentinel))
+		if got != want {
+			t.Errorf("%v: api error = %v, want %v", sentinel, got, want)
+		}
+		if want.HTTPCode == http.StatusInternalServerError && want != apiErrInternal {
+			t.Errorf("%v: internal error has custom message %q", sentinel, want.Message)
+		}
+	}
+}
+
+func TestToAPIErrorOutOfStock(t *testing.T) {
+	products := []OutOfStockProduct{{ProductID: 7, Name: "Milk", Requested: 2, Available: 1}}
+
+	apiErr := toAPIError(fmt.Errorf("CreateCheckout: %w", &OutOfStockError{Products: products}))
+	if apiErr.HTTPCode != http.StatusConflict || apiErr.Code != ErrCodeOutOfStock {
+		t.Fatalf("api error = %d %s, want %d %s", apiErr.HTTPCode, apiErr.Code, http.StatusConflict, ErrCodeOutOfStock)
+	}
+
+	details, ok := apiErr.Details.(gin.H)
+	if !ok {
+		t.Fatalf("details = %#v", apiErr.Details)
+	}
+	if got, _ := details["products"].([]OutOfStockProduct); len(got) != 1 || got[0] != products[0] {
+		t.Errorf("products = %v, want %v", details["products"], products)
+	}
+}
@@ -2,12 +2,14 @@ package order
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
 
+	"github.com/mserebryaakov/aggregator-order-service/pkg/httpclient"
 	"github.com/sirupsen/logrus"
 )
 
@@ -23,27 +25,46 @@ func (h *AuthAdapterLogHook) Levels() []logrus.Level {
 }
 
 type authAdapter struct {
-	SystemToken string
-	client      http.Client
-	log         *logrus.Entry
-	authHost    string
-	authPort    string
+	token    systemToken
+	cache    *authCache
+	client   *httpclient.Client
+	timeout  time.Duration
+	log      *logrus.Entry
+	authHost string
+	authPort string
 }
 
-func NewAuthAdapter(log *logrus.Entry, authHost, authPort string) *authAdapter {
-	c := http.Client{
-		Timeout: time.Second * 10,
+const defaultAdapterTimeout = time.Second * 10
+
+// client - клиент с повторами и автоматом отключения сервиса авторизации;
+// timeout - ограничение времени одного запроса к сервису авторизации (вместе с повторами);
+// cache - кэш результатов проверки токенов (nil - без кэша)
+func NewAuthAdapter(log *logrus.Entry, authHost, authPort string, client *httpclient.Client, timeout time.Duration, cache *authCache) *authAdapter {
+	if timeout <= 0 {
+		timeout = defaultAdapterTimeout
 	}
 
 	return &authAdapter{
-		client:   c,
+		client:   client,
+		timeout:  timeout,
+		cache:    cache,
 		log:      log,
 		authHost: authHost,
 		authPort: authPort,
 	}
 }
 
-func (a *authAdapter) Login(email, password, domain string) error {
+// Вход под учётной записью супервизора; учётные данные сохраняются для обновления токена
+func (a *authAdapter) Login(ctx context.Context, email, password, domain string) error {
+	a.token.setCredentials(email, password, domain)
+	return a.login(ctx, email, password, domain)
+}
+
+func (a *authAdapter) login(ctx context.Context, email, password, domain string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	ctx = httpclient.Idempotent(ctx)
+
 	requestBody := struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -62,7 +83,7 @@ func (a *authAdapter) Login(email, password, domain string) error {
 
 	//url := fmt.Sprintf("http://%s.%s%s%s", domain, a.authHost, a.authPort, "/auth/login")
 	url := fmt.Sprintf("http://%s%s%s", a.authHost, a.authPort, "/system/auth/login")
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		a.log.Debugf("login: failed to create login request with err - %v", err)
 		return NewError(ServerAppError, "failed to create login request", 500, err)
@@ -77,7 +98,7 @@ func (a *authAdapter) Login(email, password, domain string) error {
 	resp, err := a.client.Do(req)
 	if err != nil {
 		a.log.Debugf("login: failed login request with err - %v", err)
-		return NewError(ServerAppError, "failed login request", 500, err)
+		return NewError(ServerAppError, "failed login request", upstreamStatus(err), err)
 	}
 	defer resp.Body.Close()
 
@@ -104,9 +125,9 @@ func (a *authAdapter) Login(email, password, domain string) error {
 			return NewError(ServerAppError, "token not found (cookies from authservice)", 500, nil)
 		}
 
-		a.SystemToken = token
+		a.token.set(token)
 
-		a.log.Debugf("login: success login - %s", token)
+		a.log.Debug("login: success login")
 
 		return nil
 	case http.StatusBadRequest:
@@ -120,7 +141,55 @@ func (a *authAdapter) Login(email, password, domain string) error {
 	}
 }
 
-func (a *authAdapter) Auth(role []string, clientToken, domain string) (int, uint, error) {
+// Проверка токена клиента. 401 возвращается и для недействительного токена клиента, поэтому
+// системный токен обновляется (с однократным повтором запроса), только если отклонён он сам.
+// Окончательные результаты проверки кэшируются, кроме отказов из-за системного токена.
+func (a *authAdapter) Auth(ctx context.Context, role []string, clientToken, domain string) (int, uint, error) {
+	var key authCacheKey
+	if a.cache != nil {
+		key = newAuthCacheKey(clientToken, domain, role)
+		if entry, ok := a.cache.get(key); ok {
+			return entry.code, entry.userID, nil
+		}
+	}
+
+	token := a.systemToken(ctx)
+
+	code, userID, err := a.auth(ctx, role, clientToken, domain, token)
+	cacheable := err == nil
+	if err == nil && code == http.StatusUnauthorized {
+		rejected, checkErr := a.systemTokenRejected(ctx, token)
+		if checkErr != nil {
+			a.log.Errorf("Auth: check system token err - %v", checkErr)
+		}
+
+		// Отказ кэшируется, только если он относится к токену клиента
+		cacheable = checkErr == nil && !rejected
+		if rejected && a.refresh(ctx, token) == nil {
+			code, userID, err = a.auth(ctx, role, clientToken, domain, a.token.get())
+			cacheable = err == nil
+		}
+	}
+
+	if cacheable && a.cache != nil {
+		a.cache.put(key, code, userID)
+	}
+
+	return code, userID, err
+}
+
+// Сброс кэшированных проверок домена
+func (a *authAdapter) InvalidateDomain(domain string) {
+	if a.cache != nil {
+		a.cache.InvalidateDomain(domain)
+	}
+}
+
+func (a *authAdapter) auth(ctx context.Context, role []string, clientToken, domain, token string) (int, uint, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	ctx = httpclient.Idempotent(ctx)
+
 	url := fmt.Sprintf("http://%s%s%s", a.authHost, a.authPort, "/system/auth/validate")
 
 	requestBody := struct {
@@ -137,7 +206,7 @@ func (a *authAdapter) Auth(role []string, clientToken, domain string) (int, uint
 
 	a.log.Debugf("auth: body - %s", jsonBody)
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		a.log.Errorf("auth: failed create authservice request /auth/validate - %v", err)
 		return 0, 0, NewError(ServerAppError, "failed create auth request /auth/validate", 500, err)
@@ -147,13 +216,13 @@ func (a *authAdapter) Auth(role []string, clientToken, domain string) (int, uint
 	q.Add("domain", domain)
 	req.URL.RawQuery = q.Encode()
 
-	req.Header.Set("Authorization", a.SystemToken)
+	req.Header.Set("Authorization", token)
 	req.Header.Set("X-System-Token", clientToken)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := a.client.Do(req)
 	if err != nil {
-		return 0, 0, NewError(ServerAppError, "failed authservice request", 500, err)
+		return 0, 0, NewError(ServerAppError, "failed authservice request", upstreamStatus(err), err)
 	}
 	defer resp.Body.Close()
 
@@ -174,7 +243,21 @@ func (a *authAdapter) Auth(role []string, clientToken, domain string) (int, uint
 	return resp.StatusCode, responseBody.UserID, nil
 }
 
-func (a *authAdapter) Init(domain, password, email string) error {
+func (a *authAdapter) Init(ctx context.Context, domain, password, email string) error {
+	token := a.systemToken(ctx)
+
+	err := a.init(ctx, domain, password, email, token)
+	if isUnauthorized(err) && a.refresh(ctx, token) == nil {
+		return a.init(ctx, domain, password, email, a.token.get())
+	}
+
+	return err
+}
+
+func (a *authAdapter) init(ctx context.Context, domain, password, email, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
 	url := fmt.Sprintf("http://%s%s%s", a.authHost, a.authPort, "/init/start")
 
 	requestBody := struct {
@@ -193,13 +276,13 @@ func (a *authAdapter) Init(domain, password, email string) error {
 
 	a.log.Debugf("init: body - %s", jsonBody)
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		a.log.Errorf("init: failed create authservice init request init/start - %v", err)
 		return NewError(ServerAppError, "failed create authservice init request init/start", 500, err)
 	}
 
-	req.Header.Set("Authorization", a.SystemToken)
+	req.Header.Set("Authorization", token)
 	req.Header.Set("Content-Type", "application/json")
 
 	q := req.URL.Query()
@@ -209,7 +292,7 @@ func (a *authAdapter) Init(domain, password, email string) error {
 	resp, err := a.client.Do(req)
 	if err != nil {
 		a.log.Errorf("init: failed request - %v", err)
-		return NewError(ServerAppError, "failed authservice init/start request", 500, err)
+		return NewError(ServerAppError, "failed authservice init/start request", upstreamStatus(err), err)
 	}
 	defer resp.Body.Close()
 
@@ -233,16 +316,31 @@ func (a *authAdapter) Init(domain, password, email string) error {
 	}
 }
 
-func (a *authAdapter) Rollback(domain string) error {
+func (a *authAdapter) Rollback(ctx context.Context, domain string) error {
+	token := a.systemToken(ctx)
+
+	err := a.rollback(ctx, domain, token)
+	if isUnauthorized(err) && a.refresh(ctx, token) == nil {
+		return a.rollback(ctx, domain, a.token.get())
+	}
+
+	return err
+}
+
+func (a *authAdapter) rollback(ctx context.Context, domain, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	ctx = httpclient.Idempotent(ctx)
+
 	url := fmt.Sprintf("http://%s%s%s", a.authHost, a.authPort, "/init/rollback")
 
-	req, err := http.NewRequest(http.MethodPost, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
 	if err != nil {
 		a.log.Errorf("rollback: failed create authservice rollback request init/rollback - %v", err)
 		return NewError(ServerAppError, "failed create authservice rollback request init/rollback", 500, err)
 	}
 
-	req.Header.Set("Authorization", a.SystemToken)
+	req.Header.Set("Authorization", token)
 
 	q := req.URL.Query()
 	q.Add("domain", domain)
@@ -251,7 +349,7 @@ func (a *authAdapter) Rollback(domain string) error {
 	resp, err := a.client.Do(req)
 	if err != nil {
 		a.log.Errorf("rollback: failed send authservice rollback request init/rollback - %v", err)
-		return NewError(ServerAppError, "failed send authservice rollback request init/rollback", 500, err)
+		return NewError(ServerAppError, "failed send authservice rollback request init/rollback", upstreamStatus(err), err)
 	}
 	defer resp.Body.Close()
 
@@ -276,3 +374,41 @@ func (a *authAdapter) Rollback(domain string) error {
 		return NewError(HttpError, "authservice /init/rollback unexpected", 500, fmt.Errorf("statuscode - %d, body - %s", resp.StatusCode, string(bts)))
 	}
 }
+
+// Проверка действительности системного токена (валидация токена в домене public)
+func (a *authAdapter) CheckSystemToken(ctx context.Context) error {
+	token := a.systemToken(ctx)
+	if token == "" {
+		return fmt.Errorf("system token is empty")
+	}
+
+	code, err := a.validateSystemToken(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	if code != http.StatusOK {
+		return fmt.Errorf("system token validation failed with code - %d", code)
+	}
+
+	return nil
+}
+
+func (a *authAdapter) validateSystemToken(ctx context.Context, token string) (int, error) {
+	code, _, err := a.auth(ctx, []string{systemRole}, token, "public", token)
+	return code, err
+}
+
+// Системный токен отклонён сервисом авторизации (а не токен клиента)
+func (a *authAdapter) systemTokenRejected(ctx context.Context, token string) (bool, error) {
+	if token == "" {
+		return true, nil
+	}
+
+	code, err := a.validateSystemToken(ctx, token)
+	if err != nil {
+		return false, err
+	}
+
+	return code == http.StatusUnauthorized, nil
+}
@@ -0,0 +1,200 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mserebryaakov/aggregator-order-service/pkg/httpclient"
+	"github.com/sirupsen/logrus"
+)
+
+// Сервис авторизации: принимает только текущий системный токен и токен клиента client-ok
+type fakeAuthService struct {
+	sync.Mutex
+	systemToken string
+	rejectLogin bool
+	logins      int
+	validations int
+	initStatus  int // код ответа /init/start (0 - 200)
+	inits       int
+	rollbacks   int
+}
+
+func (f *fakeAuthService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	switch r.URL.Path {
+	case "/system/auth/login":
+		f.logins++
+		if f.rejectLogin {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "Authorization", Value: f.systemToken})
+	case "/system/auth/validate":
+		f.validations++
+		clientToken := r.Header.Get("X-System-Token")
+		valid := clientToken == "client-ok"
+		if r.URL.Query().Get("domain") == "public" {
+			valid = clientToken == f.systemToken
+		}
+
+		if r.Header.Get("Authorization") != f.systemToken || !valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]uint{"userId": 7})
+	case "/init/start":
+		f.inits++
+		if f.initStatus != 0 {
+			w.WriteHeader(f.initStatus)
+		}
+	case "/init/rollback":
+		f.rollbacks++
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeAuthService) rotate(token string) {
+	f.Lock()
+	defer f.Unlock()
+	f.systemToken = token
+}
+
+func (f *fakeAuthService) setRejectLogin(reject bool) {
+	f.Lock()
+	defer f.Unlock()
+	f.rejectLogin = reject
+}
+
+func (f *fakeAuthService) loginCount() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.logins
+}
+
+func (f *fakeAuthService) initCounts() (int, int) {
+	f.Lock()
+	defer f.Unlock()
+	return f.inits, f.rollbacks
+}
+
+func newTestAuthAdapter(t *testing.T, service *fakeAuthService, cache *authCache) *authAdapter {
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	log := logrus.NewEntry(logger)
+
+	client := httpclient.New(httpclient.Config{Name: "auth-test"}, log)
+	adapter := NewAuthAdapter(log, u.Hostname(), ":"+u.Port(), client, time.Second, cache)
+
+	if err := adapter.Login(context.Background(), "supervisor@example.com", "secret", "public"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ограничение частоты перевхода не должно влиять на проверки
+	adapter.token.refreshedAt = time.Now().Add(-time.Hour)
+
+	return adapter
+}
+
+func TestAuthInvalidClientTokenKeepsSystemToken(t *testing.T) {
+	service := &fakeAuthService{systemToken: "system-1"}
+	adapter := newTestAuthAdapter(t, service, nil)
+
+	code, _, err := adapter.Auth(context.Background(), []string{clientRole}, "client-bad", "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusUnauthorized {
+		t.Errorf("code = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if logins := service.loginCount(); logins != 1 {
+		t.Errorf("logins = %d, want 1", logins)
+	}
+}
+
+func TestAuthRejectedSystemTokenIsRefreshed(t *testing.T) {
+	service := &fakeAuthService{systemToken: "system-1"}
+	adapter := newTestAuthAdapter(t, service, nil)
+
+	service.rotate("system-2")
+
+	code, userID, err := adapter.Auth(context.Background(), []string{clientRole}, "client-ok", "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusOK || userID != 7 {
+		t.Errorf("code, userID = %d, %d, want %d, 7", code, userID, http.StatusOK)
+	}
+	if logins := service.loginCount(); logins != 2 {
+		t.Errorf("logins = %d, want 2", logins)
+	}
+}
+
+func TestAuthDoesNotCacheSystemTokenRejection(t *testing.T) {
+	service := &fakeAuthService{systemToken: "system-1"}
+	adapter := newTestAuthAdapter(t, service, NewAuthCache(time.Minute, time.Minute, 10, 10))
+
+	service.rotate("system-2")
+	service.setRejectLogin(true)
+
+	code, _, err := adapter.Auth(context.Background(), []string{clientRole}, "client-ok", "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusUnauthorized {
+		t.Fatalf("code = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	service.setRejectLogin(false)
+	adapter.token.refreshedAt = time.Now().Add(-time.Hour)
+
+	code, userID, err := adapter.Auth(context.Background(), []string{clientRole}, "client-ok", "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusOK || userID != 7 {
+		t.Errorf("code, userID = %d, %d, want %d, 7", code, userID, http.StatusOK)
+	}
+}
+
+func TestAuthCachesClientTokenRejection(t *testing.T) {
+	service := &fakeAuthService{systemToken: "system-1"}
+	adapter := newTestAuthAdapter(t, service, NewAuthCache(time.Minute, time.Minute, 10, 10))
+
+	for i := 0; i < 2; i++ {
+		code, _, err := adapter.Auth(context.Background(), []string{clientRole}, "client-bad", "shop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != http.StatusUnauthorized {
+			t.Fatalf("code = %d, want %d", code, http.StatusUnauthorized)
+		}
+	}
+
+	service.Lock()
+	validations := service.validations
+	service.Unlock()
+
+	// Проверка токена клиента и системного токена, второй запрос - из кэша
+	if validations != 2 {
+		t.Errorf("validations = %d, want 2", validations)
+	}
+}
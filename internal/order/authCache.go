@@ -0,0 +1,175 @@
+package order
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Метрики кэша проверок авторизации (доступны системному пользователю в /debug/vars)
+var authCacheMetrics = expvar.NewMap("auth_cache")
+
+type authCacheKey struct {
+	tokenHash string
+	domain    string
+	roles     string
+}
+
+type authCacheEntry struct {
+	code      int
+	userID    uint
+	expiresAt time.Time
+}
+
+// Ограниченный по размеру кэш результатов /system/auth/validate.
+// Отказы (401/403) хранятся меньше, чем успешные проверки, и в отдельном списке
+// меньшего размера: поток запросов с неизвестными токенами вытесняет только отказы.
+// При переполнении списка вытесняется давно не использовавшаяся запись.
+type authCache struct {
+	sync.Mutex
+	entries     map[authCacheKey]*list.Element
+	allowed     *authCacheList
+	denied      *authCacheList
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+// Записи в порядке использования (в начале - последние)
+type authCacheList struct {
+	items      *list.List
+	maxEntries int
+}
+
+type authCacheItem struct {
+	key   authCacheKey
+	entry authCacheEntry
+	list  *authCacheList
+}
+
+const defaultAuthCacheEntries = 10000
+
+// maxNegativeEntries <= 0 - десятая часть maxEntries
+func NewAuthCache(ttl, negativeTTL time.Duration, maxEntries, maxNegativeEntries int) *authCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultAuthCacheEntries
+	}
+	if maxNegativeEntries <= 0 {
+		maxNegativeEntries = maxEntries / 10
+	}
+	if maxNegativeEntries <= 0 {
+		maxNegativeEntries = 1
+	}
+
+	return &authCache{
+		entries:     make(map[authCacheKey]*list.Element),
+		allowed:     &authCacheList{items: list.New(), maxEntries: maxEntries},
+		denied:      &authCacheList{items: list.New(), maxEntries: maxNegativeEntries},
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+}
+
+func newAuthCacheKey(token, domain string, roles []string) authCacheKey {
+	hash := sha256.Sum256([]byte(token))
+
+	sorted := make([]string, len(roles))
+	copy(sorted, roles)
+	sort.Strings(sorted)
+
+	return authCacheKey{
+		tokenHash: hex.EncodeToString(hash[:]),
+		domain:    domain,
+		roles:     strings.Join(sorted, ","),
+	}
+}
+
+func (c *authCache) get(key authCacheKey) (authCacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	var entry authCacheEntry
+	element, ok := c.entries[key]
+	if ok {
+		item := element.Value.(*authCacheItem)
+		entry = item.entry
+		if time.Now().After(entry.expiresAt) {
+			c.remove(element)
+			ok = false
+		} else {
+			item.list.items.MoveToFront(element)
+		}
+	}
+
+	switch {
+	case !ok:
+		authCacheMetrics.Add("misses", 1)
+	case entry.code == http.StatusOK:
+		authCacheMetrics.Add("hits", 1)
+	default:
+		authCacheMetrics.Add("negative_hits", 1)
+	}
+
+	return entry, ok
+}
+
+// Сохраняются только окончательные ответы: 200, 401 и 403
+func (c *authCache) put(key authCacheKey, code int, userID uint) {
+	var ttl time.Duration
+	target := c.allowed
+	switch code {
+	case http.StatusOK:
+		ttl = c.ttl
+	case http.StatusUnauthorized, http.StatusForbidden:
+		ttl = c.negativeTTL
+		target = c.denied
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	if target.items.Len() >= target.maxEntries {
+		c.remove(target.items.Back())
+		authCacheMetrics.Add("evictions", 1)
+	}
+
+	c.entries[key] = target.items.PushFront(&authCacheItem{
+		key: key,
+		entry: authCacheEntry{
+			code:      code,
+			userID:    userID,
+			expiresAt: time.Now().Add(ttl),
+		},
+		list: target,
+	})
+}
+
+func (c *authCache) remove(element *list.Element) {
+	item := element.Value.(*authCacheItem)
+	item.list.items.Remove(element)
+	delete(c.entries, item.key)
+}
+
+// Сброс всех проверок домена (например, при откате подключения магазина)
+func (c *authCache) InvalidateDomain(domain string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, element := range c.entries {
+		if key.domain == domain {
+			c.remove(element)
+		}
+	}
+}
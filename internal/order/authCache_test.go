@@ -0,0 +1,77 @@
+package order
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAuthCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewAuthCache(time.Minute, time.Minute, 2, 1)
+
+	first := newAuthCacheKey("first", "shop", nil)
+	second := newAuthCacheKey("second", "shop", nil)
+	third := newAuthCacheKey("third", "shop", nil)
+
+	cache.put(first, http.StatusOK, 1)
+	cache.put(second, http.StatusOK, 2)
+
+	// Обращение делает first последним использованным, вытесняется second
+	if _, ok := cache.get(first); !ok {
+		t.Fatal("first not cached")
+	}
+	cache.put(third, http.StatusOK, 3)
+
+	if _, ok := cache.get(second); ok {
+		t.Error("second was not evicted")
+	}
+	for _, key := range []authCacheKey{first, third} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("%v was evicted", key)
+		}
+	}
+}
+
+func TestAuthCacheBoundsNegativeEntries(t *testing.T) {
+	cache := NewAuthCache(time.Minute, time.Minute, 2, 1)
+
+	allowed := newAuthCacheKey("allowed", "shop", nil)
+	cache.put(allowed, http.StatusOK, 1)
+
+	// Отказы по неизвестным токенам вытесняют только друг друга
+	unknown := []authCacheKey{
+		newAuthCacheKey("unknown-1", "shop", nil),
+		newAuthCacheKey("unknown-2", "shop", nil),
+		newAuthCacheKey("unknown-3", "shop", nil),
+	}
+	for _, key := range unknown {
+		cache.put(key, http.StatusUnauthorized, 0)
+	}
+
+	if entry, ok := cache.get(allowed); !ok || entry.userID != 1 {
+		t.Error("allowed entry was evicted by rejections")
+	}
+	for _, key := range unknown[:2] {
+		if _, ok := cache.get(key); ok {
+			t.Errorf("%v was not evicted", key)
+		}
+	}
+	if entry, ok := cache.get(unknown[2]); !ok || entry.code != http.StatusUnauthorized {
+		t.Error("latest rejection is not cached")
+	}
+}
+
+func TestAuthCacheExpires(t *testing.T) {
+	cache := NewAuthCache(time.Minute, time.Nanosecond, 2, 1)
+
+	key := newAuthCacheKey("token", "shop", nil)
+	cache.put(key, http.StatusForbidden, 0)
+	time.Sleep(time.Millisecond)
+
+	if _, ok := cache.get(key); ok {
+		t.Error("expired entry returned")
+	}
+	if len(cache.entries) != 0 || cache.denied.items.Len() != 0 {
+		t.Error("expired entry was not removed")
+	}
+}
@@ -0,0 +1,135 @@
+package order
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Токен обновляется заранее, за tokenRefreshSkew до истечения
+	tokenRefreshSkew = time.Minute
+	// Повторный вход по 401 не чаще одного раза за tokenRefreshInterval,
+	// чтобы недействительные токены клиентов не вызывали постоянный перевход
+	tokenRefreshInterval = 30 * time.Second
+)
+
+var errTokenRecentlyRefreshed = errors.New("system token was refreshed recently")
+
+// Системный токен сервиса авторизации и учётные данные для его обновления
+type systemToken struct {
+	sync.RWMutex
+	value       string
+	expiresAt   time.Time
+	refreshedAt time.Time
+
+	email    string
+	password string
+	domain   string
+
+	// Обновление выполняется одним запросом, остальные вызовы ждут его результата
+	refreshMu sync.Mutex
+}
+
+func (t *systemToken) setCredentials(email, password, domain string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.email = email
+	t.password = password
+	t.domain = domain
+}
+
+func (t *systemToken) set(value string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.value = value
+	t.expiresAt = tokenExpiry(value)
+	t.refreshedAt = time.Now()
+}
+
+func (t *systemToken) get() string {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.value
+}
+
+// Текущий системный токен; истекающий токен обновляется заранее
+func (a *authAdapter) systemToken(ctx context.Context) string {
+	a.token.RLock()
+	value, expiresAt := a.token.value, a.token.expiresAt
+	a.token.RUnlock()
+
+	if !expiresAt.IsZero() && time.Until(expiresAt) < tokenRefreshSkew {
+		if err := a.refresh(ctx, value); err != nil && err != errTokenRecentlyRefreshed {
+			a.log.Errorf("systemToken: refresh err - %v", err)
+		}
+		return a.token.get()
+	}
+
+	return value
+}
+
+// Повторный вход супервизора. stale - токен, который был отклонён или истекает;
+// если его уже заменили параллельно, повторный вход не выполняется.
+func (a *authAdapter) refresh(ctx context.Context, stale string) error {
+	a.token.refreshMu.Lock()
+	defer a.token.refreshMu.Unlock()
+
+	a.token.RLock()
+	current, expiresAt, refreshedAt := a.token.value, a.token.expiresAt, a.token.refreshedAt
+	email, password, domain := a.token.email, a.token.password, a.token.domain
+	a.token.RUnlock()
+
+	if current != stale {
+		return nil
+	}
+
+	expiring := !expiresAt.IsZero() && time.Until(expiresAt) < tokenRefreshSkew
+	if !expiring && time.Since(refreshedAt) < tokenRefreshInterval {
+		return errTokenRecentlyRefreshed
+	}
+
+	if email == "" {
+		return errors.New("supervisor credentials are not set")
+	}
+
+	a.log.Info("refresh: system token refresh")
+
+	return a.login(ctx, email, password, domain)
+}
+
+func isUnauthorized(err error) bool {
+	var appErr *AppError
+	return errors.As(err, &appErr) && appErr.HTTPCode == 401
+}
+
+// Срок действия из claim exp JWT (без проверки подписи); нулевое время, если claim нет
+func tokenExpiry(token string) time.Time {
+	token = strings.TrimPrefix(token, "Bearer ")
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.Exp, 0)
+}
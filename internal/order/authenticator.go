@@ -0,0 +1,14 @@
+package order
+
+import "context"
+
+// Способ проверки токенов клиентов
+const (
+	RemoteAuthMode = "remote" // запрос в сервис авторизации
+	LocalAuthMode  = "local"  // проверка подписи JWT по ключам JWKS
+)
+
+// Проверка токена клиента: HTTP-код результата (200, 401, 403, 404) и id пользователя
+type Authenticator interface {
+	Auth(ctx context.Context, role []string, clientToken, domain string) (int, uint, error)
+}
@@ -0,0 +1,193 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Режим списания по настройке магазина; пусто - auto
+func captureMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return AutoCaptureMode, nil
+	case AutoCaptureMode, OnDeliveryCaptureMode, OnAcceptCaptureMode:
+		return mode, nil
+	default:
+		return "", errCaptureModeUnknown
+	}
+}
+
+// Все заказы оформления доставлены (on_delivery) или приняты магазином (on_accept)
+func readyForCapture(checkout *Checkout) bool {
+	for _, order := range checkout.Orders {
+		switch checkout.CaptureMode {
+		case OnDeliveryCaptureMode:
+			if order.DeliveryStatus.Code != DeliveredDelivery {
+				return false
+			}
+		case OnAcceptCaptureMode:
+			if order.AcceptedAt == nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Удержание истекает раньше, чем через before. Без срока от шлюза он отсчитывается от момента удержания.
+func authorizationExpiring(checkout *Checkout, ttl, before time.Duration, now time.Time) bool {
+	until := checkout.AuthorizedUntil
+	if until == nil {
+		if checkout.AuthorizedAt == nil {
+			return false
+		}
+		expires := checkout.AuthorizedAt.Add(ttl)
+		until = &expires
+	}
+	return until.Before(now.Add(before))
+}
+
+// Двухстадийная оплата: списание и отмена удержанных средств, перевод оформления
+// в статус по состоянию платежа в шлюзе
+type paymentCapture struct {
+	orderService OrderService
+	payments     *PaymentProviders
+	log          *logrus.Entry
+}
+
+func newPaymentCapture(orderService OrderService, payments *PaymentProviders, log *logrus.Entry) *paymentCapture {
+	return &paymentCapture{
+		orderService: orderService,
+		payments:     payments,
+		log:          log,
+	}
+}
+
+// Списание по оформлению заказа, если все его заказы готовы
+func (pc *paymentCapture) captureOrder(ctx context.Context, orderID uint, schema string) error {
+	checkout, err := pc.orderService.GetCheckoutByOrderID(ctx, orderID, schema)
+	if err != nil {
+		return err
+	}
+
+	if checkout == nil || checkout.PaymentStatus.Code != AuthorizedPayment || !readyForCapture(checkout) {
+		return nil
+	}
+
+	return pc.capture(ctx, checkout, schema)
+}
+
+// Ключ идемпотентности привязан к платежу, поэтому повторное списание не создаёт новую операцию
+func (pc *paymentCapture) capture(ctx context.Context, checkout *Checkout, schema string) error {
+	provider, err := pc.payments.Get(checkout.PaymentProvider)
+	if err != nil {
+		return err
+	}
+
+	payment, err := provider.CapturePayment(ctx, "capture-"+checkout.PaymentID, checkout.PaymentID)
+	if err != nil {
+		return err
+	}
+
+	pc.log.Infof("capture: payment %s captured (checkoutId - %d, status - %s)", payment.ID, checkout.ID, payment.Status)
+
+	return pc.apply(ctx, checkout.ID, payment, schema)
+}
+
+func (pc *paymentCapture) cancel(ctx context.Context, checkout *Checkout, schema string) error {
+	provider, err := pc.payments.Get(checkout.PaymentProvider)
+	if err != nil {
+		return err
+	}
+
+	payment, err := provider.CancelPayment(ctx, "cancel-"+checkout.PaymentID, checkout.PaymentID)
+	if err != nil {
+		return err
+	}
+
+	pc.log.Infof("cancel: payment %s canceled (checkoutId - %d, status - %s)", payment.ID, checkout.ID, payment.Status)
+
+	return pc.apply(ctx, checkout.ID, payment, schema)
+}
+
+// Отмена заказа покупателем: отменяется всё оформление, удержанные средства освобождаются
+// в шлюзе, резервы товаров снимаются. Списанные платежи, оплата при получении и заказы,
+// уже взятые курьером, не отменяются.
+func (pc *paymentCapture) cancelOrder(ctx context.Context, orderID uint, schema string) error {
+	checkout, err := pc.orderService.GetCheckoutByOrderID(ctx, orderID, schema)
+	if err != nil {
+		return err
+	}
+	if checkout == nil {
+		return errChangePaymentIdNotFound
+	}
+
+	for _, order := range checkout.Orders {
+		if order.CourierID != nil {
+			return errOrderNotCancelable
+		}
+	}
+
+	switch checkout.PaymentStatus.Code {
+	case AuthorizedPayment:
+		return pc.cancel(ctx, checkout, schema)
+	case WaitingProcessingPayment:
+	default:
+		return errOrderNotCancelable
+	}
+
+	if checkout.PaymentID == "" {
+		return pc.orderService.PaymentCanceled(ctx, checkout.ID, schema)
+	}
+
+	provider, err := pc.payments.Get(checkout.PaymentProvider)
+	if err != nil {
+		return err
+	}
+
+	// Уведомление шлюза могло ещё не прийти: статус платежа проверяется перед отменой
+	payment, err := provider.GetPayment(ctx, checkout.PaymentID)
+	var gwErr *GatewayError
+	switch {
+	case errors.As(err, &gwErr) && gwErr.HTTPCode == http.StatusNotFound:
+		return pc.orderService.PaymentCanceled(ctx, checkout.ID, schema)
+	case err != nil:
+		return err
+	}
+
+	switch payment.Status {
+	case "succeeded":
+		if err := pc.apply(ctx, checkout.ID, payment, schema); err != nil {
+			return err
+		}
+		return errOrderAlreadyPaid
+	case "waiting_for_capture":
+		return pc.cancel(ctx, checkout, schema)
+	case "pending":
+		// Платёж отменяется, чтобы покупатель не оплатил отменённый заказ;
+		// без подтверждения шлюза оформление не отменяется
+		if _, err := provider.CancelPayment(ctx, "cancel-"+payment.ID, payment.ID); err != nil {
+			pc.log.Errorf("cancelOrder: CancelPayment (paymentId - %s) err - %v", payment.ID, err)
+			return err
+		}
+	}
+
+	return pc.orderService.PaymentCanceled(ctx, checkout.ID, schema)
+}
+
+// Перевод оформления в статус по статусу платежа; pending не меняет статус
+func (pc *paymentCapture) apply(ctx context.Context, checkoutID uint, payment *Payment, schema string) error {
+	switch payment.Status {
+	case "succeeded":
+		return pc.orderService.PaymentSuccess(ctx, checkoutID, schema)
+	case "waiting_for_capture":
+		return pc.orderService.PaymentAuthorized(ctx, checkoutID, payment.ExpiresAt, schema)
+	case "canceled":
+		return pc.orderService.PaymentCanceled(ctx, checkoutID, schema)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Сервис заказов с одним оформлением; фиксирует переводы оформления в статусы оплаты
+type fakeCheckoutService struct {
+	OrderService
+	checkout *Checkout
+	canceled []uint
+	paid     []uint
+}
+
+func (f *fakeCheckoutService) GetCheckoutByOrderID(ctx context.Context, orderID uint, schema string) (*Checkout, error) {
+	return f.checkout, nil
+}
+
+func (f *fakeCheckoutService) PaymentCanceled(ctx context.Context, checkoutID uint, schema string) error {
+	f.canceled = append(f.canceled, checkoutID)
+	return nil
+}
+
+func (f *fakeCheckoutService) PaymentSuccess(ctx context.Context, checkoutID uint, schema string) error {
+	f.paid = append(f.paid, checkoutID)
+	return nil
+}
+
+func newTestPaymentCapture(t *testing.T, checkout *Checkout) (*paymentCapture, *fakeCheckoutService, *fakeProvider) {
+	provider := NewFakeProvider()
+	payments, err := NewPaymentProviders(FakeProvider, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	service := &fakeCheckoutService{checkout: checkout}
+	return newPaymentCapture(service, payments, logrus.NewEntry(logger)), service, provider
+}
+
+// Оформление с удержанными в шлюзе средствами
+func authorizedCheckout(t *testing.T, provider *fakeProvider, checkout *Checkout) {
+	payment, err := provider.CreatePayment(context.Background(), CreatePayment{
+		Amount: Amount{Value: "100.00", Currency: "RUB"},
+	}, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkout.PaymentID = payment.ID
+	checkout.PaymentProvider = FakeProvider
+	checkout.PaymentStatus = PaymentStatus{Code: AuthorizedPayment}
+}
+
+func TestCancelOrderReleasesAuthorization(t *testing.T) {
+	checkout := &Checkout{Model: gorm.Model{ID: 5}, Orders: []Order{{Model: gorm.Model{ID: 1}}}}
+	pc, service, provider := newTestPaymentCapture(t, checkout)
+	authorizedCheckout(t, provider, checkout)
+
+	if err := pc.cancelOrder(context.Background(), 1, "shop"); err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := provider.GetPayment(context.Background(), checkout.PaymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != "canceled" {
+		t.Errorf("payment status = %s, want canceled", payment.Status)
+	}
+	if len(service.canceled) != 1 || service.canceled[0] != checkout.ID {
+		t.Errorf("canceled checkouts = %v, want [%d]", service.canceled, checkout.ID)
+	}
+}
+
+func TestCancelOrderRejected(t *testing.T) {
+	courierID := uint(3)
+
+	tests := []struct {
+		name     string
+		checkout *Checkout
+		status   string
+	}{
+		{
+			name:     "taken by courier",
+			checkout: &Checkout{Orders: []Order{{CourierID: &courierID}}},
+			status:   AuthorizedPayment,
+		},
+		{
+			name:     "captured",
+			checkout: &Checkout{Orders: []Order{{}}},
+			status:   PaidPayment,
+		},
+		{
+			name:     "pay on delivery",
+			checkout: &Checkout{Orders: []Order{{}}},
+			status:   PayOnDeliveryPayment,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc, service, provider := newTestPaymentCapture(t, tt.checkout)
+			authorizedCheckout(t, provider, tt.checkout)
+			tt.checkout.PaymentStatus.Code = tt.status
+
+			err := pc.cancelOrder(context.Background(), 1, "shop")
+			if !errors.Is(err, errOrderNotCancelable) {
+				t.Errorf("err = %v, want %v", err, errOrderNotCancelable)
+			}
+
+			payment, err := provider.GetPayment(context.Background(), tt.checkout.PaymentID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payment.Status != "waiting_for_capture" {
+				t.Errorf("payment status = %s, want waiting_for_capture", payment.Status)
+			}
+			if len(service.canceled) != 0 {
+				t.Errorf("canceled checkouts = %v, want none", service.canceled)
+			}
+		})
+	}
+}
+
+func TestCancelOrderAlreadyPaidInGateway(t *testing.T) {
+	checkout := &Checkout{Model: gorm.Model{ID: 5}, Orders: []Order{{}}}
+	pc, service, provider := newTestPaymentCapture(t, checkout)
+	authorizedCheckout(t, provider, checkout)
+
+	// Списание прошло в шлюзе, уведомление ещё не обработано
+	if _, err := provider.CapturePayment(context.Background(), "capture", checkout.PaymentID); err != nil {
+		t.Fatal(err)
+	}
+	checkout.PaymentStatus.Code = WaitingProcessingPayment
+
+	err := pc.cancelOrder(context.Background(), 1, "shop")
+	if !errors.Is(err, errOrderAlreadyPaid) {
+		t.Errorf("err = %v, want %v", err, errOrderAlreadyPaid)
+	}
+	if len(service.paid) != 1 || len(service.canceled) != 0 {
+		t.Errorf("paid, canceled = %v, %v, want [%d], []", service.paid, service.canceled, checkout.ID)
+	}
+}
+
+// Шлюз, не отвечающий на отмену платежа
+type cancelFailingProvider struct {
+	*fakeProvider
+}
+
+func (p *cancelFailingProvider) CancelPayment(ctx context.Context, idempotenceKey, paymentID string) (*Payment, error) {
+	return nil, p.error("CancelPayment", http.StatusServiceUnavailable, "service unavailable")
+}
+
+func TestCancelOrderPendingPayment(t *testing.T) {
+	tests := []struct {
+		name       string
+		failCancel bool
+		status     string
+	}{
+		{name: "canceled in gateway", status: "canceled"},
+		{name: "gateway unavailable", failCancel: true, status: "pending"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkout := &Checkout{Model: gorm.Model{ID: 5}, Orders: []Order{{}}}
+			pc, service, provider := newTestPaymentCapture(t, checkout)
+			authorizedCheckout(t, provider, checkout)
+			checkout.PaymentStatus.Code = WaitingProcessingPayment
+			provider.payments[checkout.PaymentID].Status = "pending"
+
+			if tt.failCancel {
+				payments, err := NewPaymentProviders(FakeProvider, &cancelFailingProvider{fakeProvider: provider})
+				if err != nil {
+					t.Fatal(err)
+				}
+				pc.payments = payments
+			}
+
+			err := pc.cancelOrder(context.Background(), 1, "shop")
+
+			var gwErr *GatewayError
+			if tt.failCancel != errors.As(err, &gwErr) {
+				t.Errorf("err = %v, want gateway error - %v", err, tt.failCancel)
+			}
+
+			payment, err := provider.GetPayment(context.Background(), checkout.PaymentID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payment.Status != tt.status {
+				t.Errorf("payment status = %s, want %s", payment.Status, tt.status)
+			}
+
+			// Оформление отменяется только после отмены платежа в шлюзе
+			if canceled := len(service.canceled) == 1; canceled == tt.failCancel {
+				t.Errorf("canceled checkouts = %v, gateway cancel failed - %v", service.canceled, tt.failCancel)
+			}
+		})
+	}
+}
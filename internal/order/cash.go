@@ -0,0 +1,54 @@
+package order
+
+import "time"
+
+// Способ оплаты заказа; пусто - online
+func paymentMethod(method string) (string, error) {
+	switch method {
+	case "":
+		return OnlinePaymentMethod, nil
+	case OnlinePaymentMethod, CashOnDeliveryPaymentMethod, CardOnDeliveryPaymentMethod:
+		return method, nil
+	default:
+		return "", errPaymentMethodUnknown
+	}
+}
+
+// Оплата при получении (наличными или картой курьеру)
+func payOnDelivery(method string) bool {
+	return method == CashOnDeliveryPaymentMethod || method == CardOnDeliveryPaymentMethod
+}
+
+// Сверка курьера по заказам с оплатой при получении
+type CourierCashReport struct {
+	CourierID  uint    `json:"courier_id"`
+	Orders     int64   `json:"orders"`
+	Expected   float64 `json:"expected"`   // Сумма заказов
+	Collected  float64 `json:"collected"`  // Получено курьером
+	Cash       float64 `json:"cash"`       // Из них наличными
+	Card       float64 `json:"card"`       // Из них картой
+	Difference float64 `json:"difference"` // Collected - Expected
+}
+
+const cashReportDateLayout = "2006-01-02"
+
+// Период отчёта по датам from и to включительно; без дат - текущий день
+func cashReportPeriod(fromStr, toStr string, now time.Time) (time.Time, time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	from, to := today, today
+	var err error
+	if fromStr != "" {
+		if from, err = time.ParseInLocation(cashReportDateLayout, fromStr, now.Location()); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		to = from
+	}
+	if toStr != "" {
+		if to, err = time.ParseInLocation(cashReportDateLayout, toStr, now.Location()); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	return from, to.AddDate(0, 0, 1), nil
+}
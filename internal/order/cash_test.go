@@ -0,0 +1,300 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mserebryaakov/aggregator-order-service/pkg/postgres"
+	"github.com/sirupsen/logrus"
+)
+
+func TestPaymentMethod(t *testing.T) {
+	tests := []struct {
+		method     string
+		want       string
+		onDelivery bool
+		err        error
+	}{
+		{method: "", want: OnlinePaymentMethod},
+		{method: OnlinePaymentMethod, want: OnlinePaymentMethod},
+		{method: CashOnDeliveryPaymentMethod, want: CashOnDeliveryPaymentMethod, onDelivery: true},
+		{method: CardOnDeliveryPaymentMethod, want: CardOnDeliveryPaymentMethod, onDelivery: true},
+		{method: "crypto", err: errPaymentMethodUnknown},
+	}
+
+	for _, tt := range tests {
+		got, err := paymentMethod(tt.method)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("paymentMethod(%q) = %q, %v, want %q, %v", tt.method, got, err, tt.want, tt.err)
+		}
+		if err == nil && payOnDelivery(got) != tt.onDelivery {
+			t.Errorf("payOnDelivery(%q) = %v, want %v", got, !tt.onDelivery, tt.onDelivery)
+		}
+	}
+}
+
+func TestCashReportPeriod(t *testing.T) {
+	now := time.Date(2024, 3, 15, 18, 30, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name     string
+		from, to string
+		wantFrom time.Time
+		wantTo   time.Time
+		wantErr  bool
+	}{
+		{name: "today", wantFrom: day(15), wantTo: day(16)},
+		{name: "single day", from: "2024-03-10", wantFrom: day(10), wantTo: day(11)},
+		{name: "period", from: "2024-03-01", to: "2024-03-10", wantFrom: day(1), wantTo: day(11)},
+		{name: "until date", to: "2024-03-20", wantFrom: day(15), wantTo: day(21)},
+		{name: "invalid from", from: "15.03.2024", wantErr: true},
+		{name: "invalid to", from: "2024-03-01", to: "tomorrow", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		from, to, err := cashReportPeriod(tt.from, tt.to, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error - %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (!from.Equal(tt.wantFrom) || !to.Equal(tt.wantTo)) {
+			t.Errorf("%s: period = [%s, %s), want [%s, %s)", tt.name, from, to, tt.wantFrom, tt.wantTo)
+		}
+	}
+}
+
+// Хранилище с оплатой при получении: фиксирует полученную сумму, возвращает заданную сверку
+type fakeCashStorage struct {
+	Storage
+	collected []*float64
+	reports   []CourierCashReport
+}
+
+func (s *fakeCashStorage) DeliveredOrderСourier(ctx context.Context, courierID uint, orderID uint, collectedAmount *float64, schema string) error {
+	s.collected = append(s.collected, collectedAmount)
+	if collectedAmount == nil {
+		return errCollectedAmountRequired
+	}
+	return nil
+}
+
+func (s *fakeCashStorage) GetCheckoutByOrderID(ctx context.Context, orderID uint, schema string) (*Checkout, error) {
+	return nil, nil
+}
+
+func (s *fakeCashStorage) GetCourierCashReport(ctx context.Context, courierID *uint, from, to time.Time, schema string) ([]CourierCashReport, error) {
+	return s.reports, nil
+}
+
+func TestGetCourierCashReportRoundsAmounts(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	storage := &fakeCashStorage{reports: []CourierCashReport{{
+		CourierID: 7,
+		Orders:    3,
+		Expected:  300.1 + 200.2,
+		Collected: 300.1 + 150.004,
+		Cash:      300.1,
+		Card:      150.004,
+	}}}
+	service := NewService(storage, logrus.NewEntry(logger))
+
+	reports, err := service.GetCourierCashReport(context.Background(), nil, time.Time{}, time.Time{}, "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := CourierCashReport{CourierID: 7, Orders: 3, Expected: 500.3, Collected: 450.1, Cash: 300.1, Card: 150, Difference: -50.2}
+	if len(reports) != 1 || reports[0] != want {
+		t.Errorf("reports = %+v, want [%+v]", reports, want)
+	}
+}
+
+func TestDeliveredOrderCollectedAmount(t *testing.T) {
+	amount := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name    string
+		query   string
+		code    int
+		errCode ErrorCode
+		stored  bool     // хранилище вызвано
+		amount  *float64 // переданная в хранилище сумма
+	}{
+		{name: "collected", query: "orderId=1&collectedAmount=99.999", code: http.StatusOK, stored: true, amount: amount(100)},
+		{name: "without amount", query: "orderId=1", code: http.StatusBadRequest, errCode: ErrCodeCollectedAmount, stored: true},
+		{name: "negative", query: "orderId=1&collectedAmount=-5", code: http.StatusBadRequest, errCode: ErrCodeInvalidRequest},
+		{name: "not a number", query: "orderId=1&collectedAmount=abc", code: http.StatusBadRequest, errCode: ErrCodeInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			storage := &fakeCashStorage{}
+			router := newTestRouter(t, NewService(storage, logrus.NewEntry(logger)), NewFakeProvider())
+
+			r := httptest.NewRequest(http.MethodPost, "/order/delivered?"+tt.query, nil)
+			r.Host = "shop1.localhost"
+			r.Header.Set("Authorization", deliveryRole)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("code = %d, want %d (body - %s)", w.Code, tt.code, w.Body.String())
+			}
+			if tt.errCode != "" {
+				var resp errorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Code != tt.errCode {
+					t.Errorf("error code = %s, want %s", resp.Code, tt.errCode)
+				}
+			}
+
+			if !tt.stored {
+				if len(storage.collected) != 0 {
+					t.Errorf("storage called with invalid amount")
+				}
+				return
+			}
+			if len(storage.collected) != 1 {
+				t.Fatalf("storage calls = %d, want 1", len(storage.collected))
+			}
+			got := storage.collected[0]
+			if (got == nil) != (tt.amount == nil) || got != nil && *got != *tt.amount {
+				t.Errorf("collected = %v, want %v", got, tt.amount)
+			}
+		})
+	}
+}
+
+// Интеграционная проверка оплаты при получении: заказ сразу в обработке, оплачен после доставки
+// с полученной суммой, резерв списан, сумма попадает в сверку курьера
+func TestCashOnDeliveryFlow(t *testing.T) {
+	scp, log := integrationConnectionPool(t)
+
+	migrator := postgres.NewMigrator(scp, Migrations, log)
+	storage := NewStorage(scp, 0)
+	service := NewService(storage, log)
+	ctx := context.Background()
+
+	schemaName := fmt.Sprintf("cash_%d", time.Now().UnixNano())
+	if err := migrator.CreateSchema(schemaName); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := migrator.DropSchema(schemaName); err != nil {
+			t.Errorf("DropSchema %s: %v", schemaName, err)
+		}
+	})
+	if _, err := migrator.Migrate(schemaName); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.EnsureStatuses(ctx, schemaName); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := scp.GetConnectionPool(schemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := Addresses{City: "Москва"}
+	if err := db.Create(&address).Error; err != nil {
+		t.Fatal(err)
+	}
+	product := Products{Name: "Товар", Price: 100, AddressesID: &address.ID}
+	if err := db.Create(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&Stock{ProductsID: product.ID, Quantity: 2}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	checkout := &Checkout{
+		UserID:        1,
+		TotalPrice:    100,
+		PaymentMethod: CashOnDeliveryPaymentMethod,
+		Orders: []Order{{
+			UserID:        1,
+			AddressesID:   int(address.ID),
+			Products:      []Products{product},
+			TotalPrice:    100,
+			PaymentMethod: CashOnDeliveryPaymentMethod,
+		}},
+	}
+	if _, err := storage.CreateCheckout(ctx, checkout, nil, schemaName); err != nil {
+		t.Fatal(err)
+	}
+	orderID := checkout.Orders[0].ID
+
+	status := func() (string, string) {
+		order, err := storage.GetOrderByID(ctx, 1, orderID, schemaName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return order.PaymentStatus.Code, order.DeliveryStatus.Code
+	}
+
+	if payment, delivery := status(); payment != PayOnDeliveryPayment || delivery != WaitingProcessing {
+		t.Errorf("created order status = %s/%s, want %s/%s", payment, delivery, PayOnDeliveryPayment, WaitingProcessing)
+	}
+
+	const courierID = 7
+	if err := storage.TakeOrderСourier(ctx, courierID, orderID, schemaName); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.DeliveredOrderСourier(ctx, courierID, orderID, nil, schemaName); !errors.Is(err, errCollectedAmountRequired) {
+		t.Errorf("delivered without amount err = %v, want %v", err, errCollectedAmountRequired)
+	}
+
+	collected := 95.004
+	if err := service.DeliveredOrderСourier(ctx, courierID, orderID, &collected, schemaName); err != nil {
+		t.Fatal(err)
+	}
+
+	if payment, delivery := status(); payment != PaidPayment || delivery != DeliveredDelivery {
+		t.Errorf("delivered order status = %s/%s, want %s/%s", payment, delivery, PaidPayment, DeliveredDelivery)
+	}
+
+	paid, err := storage.GetCheckoutByOrderID(ctx, orderID, schemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paid.PaymentStatus.Code != PaidPayment {
+		t.Errorf("checkout status = %s, want %s", paid.PaymentStatus.Code, PaidPayment)
+	}
+
+	stocks, err := storage.GetStocksByProductIDs(ctx, []uint{product.ID}, schemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stocks) != 1 || stocks[0].Quantity != 1 || stocks[0].Reserved != 0 {
+		t.Errorf("stocks = %+v, want quantity 1, reserved 0", stocks)
+	}
+
+	now := time.Now()
+	courier := uint(courierID)
+	reports, err := service.GetCourierCashReport(ctx, &courier, now.Add(-time.Hour), now.Add(time.Hour), schemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CourierCashReport{CourierID: courierID, Orders: 1, Expected: 100, Collected: 95, Cash: 95, Difference: -5}
+	if len(reports) != 1 || reports[0] != want {
+		t.Errorf("reports = %+v, want [%+v]", reports, want)
+	}
+}
@@ -0,0 +1,66 @@
+package order
+
+import "sort"
+
+// Товары одного адреса выдачи
+type productGroup struct {
+	AddressesID int
+	Products    []Products
+}
+
+// Расчёт стоимости заказа одного адреса выдачи
+type OrderQuote struct {
+	AddressesID int        `json:"addresses_id"`
+	Products    []Products `json:"products"`
+	Quote
+}
+
+// Расчёт стоимости корзины с разбиением на заказы по адресам выдачи
+type CheckoutQuote struct {
+	Orders      []OrderQuote `json:"orders"`
+	Subtotal    float64      `json:"subtotal"`
+	DeliveryFee float64      `json:"delivery_fee"`
+	Discount    float64      `json:"discount"`
+	Tax         float64      `json:"tax"`
+	Total       float64      `json:"total"`
+}
+
+// Разбиение товаров по адресам выдачи; товары без адреса относятся к defaultAddressesID
+func splitByAddress(products []Products, defaultAddressesID int) []productGroup {
+	index := make(map[int]int)
+	var groups []productGroup
+
+	for _, p := range products {
+		addressesID := defaultAddressesID
+		if p.AddressesID != nil {
+			addressesID = int(*p.AddressesID)
+		}
+
+		i, ok := index[addressesID]
+		if !ok {
+			i = len(groups)
+			index[addressesID] = i
+			groups = append(groups, productGroup{AddressesID: addressesID})
+		}
+		groups[i].Products = append(groups[i].Products, p)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].AddressesID < groups[j].AddressesID
+	})
+
+	return groups
+}
+
+func (cq *CheckoutQuote) add(group productGroup, q *Quote) {
+	cq.Orders = append(cq.Orders, OrderQuote{
+		AddressesID: group.AddressesID,
+		Products:    group.Products,
+		Quote:       *q,
+	})
+	cq.Subtotal = roundMoney(cq.Subtotal + q.Subtotal)
+	cq.DeliveryFee = roundMoney(cq.DeliveryFee + q.DeliveryFee)
+	cq.Discount = roundMoney(cq.Discount + q.Discount)
+	cq.Tax = roundMoney(cq.Tax + q.Tax)
+	cq.Total = roundMoney(cq.Total + q.Total)
+}
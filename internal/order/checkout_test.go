@@ -0,0 +1,149 @@
+package order
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func testProduct(id uint, addressesID *uint, price uint) Products {
+	return Products{Model: gorm.Model{ID: id}, AddressesID: addressesID, Price: price}
+}
+
+func groupProductIDs(groups []productGroup) map[int][]uint {
+	ids := make(map[int][]uint, len(groups))
+	for _, g := range groups {
+		for _, p := range g.Products {
+			ids[g.AddressesID] = append(ids[g.AddressesID], p.ID)
+		}
+	}
+	return ids
+}
+
+func TestSplitByAddress(t *testing.T) {
+	first := uint(3)
+	second := uint(9)
+
+	tests := []struct {
+		name               string
+		products           []Products
+		defaultAddressesID int
+		addresses          []int
+		ids                map[int][]uint
+	}{
+		{
+			name:               "no pickup points",
+			products:           []Products{testProduct(1, nil, 10), testProduct(2, nil, 20)},
+			defaultAddressesID: 5,
+			addresses:          []int{5},
+			ids:                map[int][]uint{5: {1, 2}},
+		},
+		{
+			name:               "groups are ordered by address",
+			products:           []Products{testProduct(1, &second, 10), testProduct(2, nil, 20), testProduct(3, &first, 30), testProduct(4, &second, 40)},
+			defaultAddressesID: 5,
+			addresses:          []int{3, 5, 9},
+			ids:                map[int][]uint{3: {3}, 5: {2}, 9: {1, 4}},
+		},
+		{
+			name:               "pickup point equal to default address",
+			products:           []Products{testProduct(1, &first, 10), testProduct(2, nil, 20)},
+			defaultAddressesID: 3,
+			addresses:          []int{3},
+			ids:                map[int][]uint{3: {1, 2}},
+		},
+		{
+			name: "empty cart",
+			ids:  map[int][]uint{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := splitByAddress(tt.products, tt.defaultAddressesID)
+
+			var addresses []int
+			for _, g := range groups {
+				addresses = append(addresses, g.AddressesID)
+			}
+			if !reflect.DeepEqual(addresses, tt.addresses) {
+				t.Errorf("addresses = %v, want %v", addresses, tt.addresses)
+			}
+			if ids := groupProductIDs(groups); !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("products = %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+}
+
+func TestAllocatePromoCodeDiscount(t *testing.T) {
+	food := uint(1)
+	groups := []productGroup{
+		{AddressesID: 1, Products: []Products{{Price: 100, CategoryID: &food}}},
+		{AddressesID: 2, Products: []Products{{Price: 500}}},
+		{AddressesID: 3, Products: []Products{{Price: 200, CategoryID: &food}}},
+	}
+
+	tests := []struct {
+		name     string
+		promo    PromoCode
+		discount float64
+		amounts  []float64
+	}{
+		{
+			name:     "proportional to eligible subtotal",
+			promo:    PromoCode{},
+			discount: 80,
+			amounts:  []float64{10, 50, 20},
+		},
+		{
+			name:     "rounding remainder goes to the last group",
+			promo:    PromoCode{},
+			discount: 10,
+			amounts:  []float64{1.25, 6.25, 2.5},
+		},
+		{
+			name:     "remainder with uneven shares",
+			promo:    PromoCode{Categories: []Category{{Model: gorm.Model{ID: food}}}},
+			discount: 10,
+			amounts:  []float64{3.33, 0, 6.67},
+		},
+		{
+			name:     "no eligible groups",
+			promo:    PromoCode{Categories: []Category{{Model: gorm.Model{ID: 7}}}},
+			discount: 10,
+			amounts:  []float64{0, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares := allocatePromoCodeDiscount(&tt.promo, Discount{Name: "promo", Amount: tt.discount}, groups)
+			if len(shares) != len(groups) {
+				t.Fatalf("shares = %d, want %d", len(shares), len(groups))
+			}
+
+			amounts := make([]float64, len(shares))
+			for i, share := range shares {
+				amounts[i] = share.Amount
+			}
+			if !reflect.DeepEqual(amounts, tt.amounts) {
+				t.Errorf("amounts = %v, want %v", amounts, tt.amounts)
+			}
+		})
+	}
+}
+
+func TestCheckoutQuoteAdd(t *testing.T) {
+	cq := &CheckoutQuote{}
+	cq.add(productGroup{AddressesID: 1}, &Quote{Subtotal: 100.1, DeliveryFee: 50, Discount: 10, Tax: 5, Total: 140.1})
+	cq.add(productGroup{AddressesID: 2}, &Quote{Subtotal: 200.2, DeliveryFee: 0, Discount: 0, Tax: 0, Total: 200.2})
+
+	if len(cq.Orders) != 2 || cq.Orders[1].AddressesID != 2 {
+		t.Fatalf("orders = %+v", cq.Orders)
+	}
+	if cq.Subtotal != 300.3 || cq.DeliveryFee != 50 || cq.Discount != 10 || cq.Tax != 5 || cq.Total != 340.3 {
+		t.Errorf("checkout quote = %+v", *cq)
+	}
+}
@@ -1,16 +1,45 @@
 package order
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
 	"strings"
+
+	"github.com/mserebryaakov/aggregator-order-service/pkg/httpclient"
 )
 
 var (
 	errOrderWithCourierNotFound          = errors.New("order with courierId not found")
 	errOrderWithUserIdAndOrderIdNotFound = errors.New("order with userId and orderId not found")
 	errTakeOrderNotFound                 = errors.New("not found order for take")
-	errChangePaymentIdNotFound           = errors.New("order not found")
-	errOrderWithPaymentKeyNotfound       = errors.New("order with paymentkey not found")
+	errChangePaymentIdNotFound           = errors.New("checkout not found")
+	errCheckoutWithPaymentKeyNotfound    = errors.New("checkout with paymentkey not found")
+	errCheckoutWithPaymentIdNotFound     = errors.New("checkout with paymentId not found")
+	errUnknownReorderMode                = errors.New("unknown reorder mode")
+	errTenantInvalidRequest              = errors.New("domain, email and password are required")
+	errTenantInvalidDomain               = errors.New("invalid tenant domain")
+	errTenantAlreadyExists               = errors.New("tenant already exists")
+	errCartEmpty                         = errors.New("cart is empty")
+	errPromoCodeNotFound                 = errors.New("promo code not found")
+	errPromoCodeInactive                 = errors.New("promo code is not active")
+	errPromoCodeMinOrderValue            = errors.New("order value is less than promo code minimum")
+	errPromoCodeUsageLimit               = errors.New("promo code usage limit reached")
+	errPromoCodeNotApplicable            = errors.New("promo code is not applicable to cart products")
+	errStatusNotFound                    = errors.New("status not found")
+	errPaymentProviderUnknown            = errors.New("unknown payment provider")
+	errCaptureModeUnknown                = errors.New("unknown capture mode")
+	errAcceptOrderNotFound               = errors.New("not found order for accept")
+	errPaymentMethodUnknown              = errors.New("unknown payment method")
+	errCollectedAmountRequired           = errors.New("collected amount is required for payment on delivery")
+	errOrderNotPayable                   = errors.New("order is paid on delivery")
+	errOrderAlreadyPaid                  = errors.New("order is already paid")
+	errPaymentRenewConflict              = errors.New("order payment is already being renewed")
+	errOrderNotCancelable                = errors.New("order can't be canceled")
+	errPaymentStatusConflict             = errors.New("checkout payment status doesn't allow payment")
+	errDeliveryDistanceInvalid           = errors.New("incorrect delivery distance")
+	errDeliveryTariffUnknown             = errors.New("unknown delivery tariff type")
 )
 
 const (
@@ -51,3 +80,102 @@ func (ae *AppError) Error() string {
 func (ae *AppError) Unwrap() error {
 	return ae.Err
 }
+
+// HTTP-код ошибки обращения к внешнему сервису: отключённая зависимость - 503
+func upstreamStatus(err error) int {
+	if errors.Is(err, httpclient.ErrCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+type OutOfStockProduct struct {
+	ProductID uint   `json:"product_id"`
+	Name      string `json:"name"`
+	Requested uint   `json:"requested"`
+	Available uint   `json:"available"`
+}
+
+// Ошибка недостаточного остатка со списком товаров
+type OutOfStockError struct {
+	Products []OutOfStockProduct
+}
+
+func (e *OutOfStockError) Error() string {
+	names := make([]string, 0, len(e.Products))
+	for _, p := range e.Products {
+		names = append(names, fmt.Sprintf("%s (id - %d, requested - %d, available - %d)", p.Name, p.ProductID, p.Requested, p.Available))
+	}
+	return "out of stock: " + strings.Join(names, ", ")
+}
+
+// Ошибка платёжного сервиса
+type GatewayError struct {
+	Operation   string // метод адаптера
+	HTTPCode    int    // код ответа клиенту
+	StatusCode  int    // код ответа платёжного сервиса (0 - ответ не получен)
+	Description string // описание ошибки от платёжного сервиса
+	Retryable   bool   // повтор запроса может быть успешным
+	Err         error
+}
+
+func newGatewayError(operation string, statusCode int, body []byte) *GatewayError {
+	e := &GatewayError{
+		Operation:   operation,
+		StatusCode:  statusCode,
+		Description: gatewayDescription(body),
+	}
+
+	switch {
+	case statusCode == http.StatusBadRequest || statusCode == http.StatusUnprocessableEntity:
+		e.HTTPCode = http.StatusBadRequest
+	case statusCode == http.StatusNotFound:
+		e.HTTPCode = http.StatusNotFound
+	case statusCode == http.StatusConflict:
+		e.HTTPCode = http.StatusConflict
+	case statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable || statusCode == http.StatusGatewayTimeout:
+		e.HTTPCode = http.StatusServiceUnavailable
+		e.Retryable = true
+	case statusCode >= http.StatusInternalServerError:
+		e.HTTPCode = http.StatusBadGateway
+		e.Retryable = true
+	default:
+		e.HTTPCode = http.StatusBadGateway
+	}
+
+	return e
+}
+
+// Описание ошибки из ответа платёжного сервиса ({"description": ...} или {"message": ...})
+func gatewayDescription(body []byte) string {
+	var parsed struct {
+		Description string `json:"description"`
+		Message     string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		if parsed.Description != "" {
+			return parsed.Description
+		}
+		return parsed.Message
+	}
+	return ""
+}
+
+func (e *GatewayError) Error() string {
+	b := new(strings.Builder)
+	b.WriteString("payment gateway " + e.Operation)
+	if e.StatusCode != 0 {
+		fmt.Fprintf(b, " responded with code %d", e.StatusCode)
+	}
+	if e.Description != "" {
+		b.WriteString(" - " + e.Description)
+	}
+	if e.Err != nil {
+		b.WriteString(" (" + e.Err.Error() + ")")
+	}
+	return b.String()
+}
+
+func (e *GatewayError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,116 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNewGatewayError(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		httpCode   int
+		retryable  bool
+	}{
+		{statusCode: http.StatusBadRequest, httpCode: http.StatusBadRequest},
+		{statusCode: http.StatusUnprocessableEntity, httpCode: http.StatusBadRequest},
+		{statusCode: http.StatusNotFound, httpCode: http.StatusNotFound},
+		{statusCode: http.StatusConflict, httpCode: http.StatusConflict},
+		{statusCode: http.StatusTooManyRequests, httpCode: http.StatusServiceUnavailable, retryable: true},
+		{statusCode: http.StatusServiceUnavailable, httpCode: http.StatusServiceUnavailable, retryable: true},
+		{statusCode: http.StatusGatewayTimeout, httpCode: http.StatusServiceUnavailable, retryable: true},
+		{statusCode: http.StatusInternalServerError, httpCode: http.StatusBadGateway, retryable: true},
+		{statusCode: http.StatusBadGateway, httpCode: http.StatusBadGateway, retryable: true},
+		{statusCode: http.StatusUnauthorized, httpCode: http.StatusBadGateway},
+		{statusCode: http.StatusForbidden, httpCode: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		err := newGatewayError("CreatePayment", tt.statusCode, nil)
+		if err.HTTPCode != tt.httpCode || err.Retryable != tt.retryable {
+			t.Errorf("code %d: http code = %d, retryable = %v, want %d, %v",
+				tt.statusCode, err.HTTPCode, err.Retryable, tt.httpCode, tt.retryable)
+		}
+	}
+}
+
+func TestGatewayDescription(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{body: `{"description": "amount is too small"}`, want: "amount is too small"},
+		{body: `{"message": "payment not found"}`, want: "payment not found"},
+		{body: `{"description": "invalid card", "message": "bad request"}`, want: "invalid card"},
+		{body: `{"code": "invalid_request"}`, want: ""},
+		{body: `<html>Bad Gateway</html>`, want: ""},
+		{body: ``, want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := gatewayDescription([]byte(tt.body)); got != tt.want {
+			t.Errorf("gatewayDescription(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestGatewayErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *GatewayError
+		want string
+	}{
+		{
+			name: "with response",
+			err:  newGatewayError("CreatePayment", http.StatusBadRequest, []byte(`{"description": "amount is too small"}`)),
+			want: "payment gateway CreatePayment responded with code 400 - amount is too small",
+		},
+		{
+			name: "no response",
+			err:  &GatewayError{Operation: "GetPayment", Err: errors.New("connection refused")},
+			want: "payment gateway GetPayment (connection refused)",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("%s: Error() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGatewayAPIError(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCode  int
+		httpCode    int
+		code        ErrorCode
+		description bool
+	}{
+		{name: "rejected", statusCode: http.StatusBadRequest, httpCode: http.StatusBadRequest, code: ErrCodePaymentInvalid, description: true},
+		{name: "not found", statusCode: http.StatusNotFound, httpCode: http.StatusNotFound, code: ErrCodePaymentNotFound, description: true},
+		{name: "conflict", statusCode: http.StatusConflict, httpCode: http.StatusConflict, code: ErrCodePaymentConflict, description: true},
+		{name: "unavailable", statusCode: http.StatusServiceUnavailable, httpCode: http.StatusServiceUnavailable, code: ErrCodePaymentUnavailable},
+		{name: "upstream failure", statusCode: http.StatusInternalServerError, httpCode: http.StatusBadGateway, code: ErrCodePaymentUpstream},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gwErr := newGatewayError("CreatePayment", tt.statusCode, []byte(`{"description": "shop secret is invalid"}`))
+			apiErr := toAPIError(fmt.Errorf("PayOrder: %w", gwErr))
+
+			if apiErr.HTTPCode != tt.httpCode || apiErr.Code != tt.code {
+				t.Errorf("api error = %d %s, want %d %s", apiErr.HTTPCode, apiErr.Code, tt.httpCode, tt.code)
+			}
+
+			// Описание шлюза передаётся клиенту только для ошибок запроса
+			_, hasDescription := apiErr.Details.(gin.H)["description"]
+			if hasDescription != tt.description {
+				t.Errorf("details = %v, description shown - %v, want %v", apiErr.Details, hasDescription, tt.description)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package order
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Имитация платёжного шлюза в памяти процесса для тестов и локальной разработки.
+// Платёж подтверждается сразу: при Capture - списывается (succeeded),
+// иначе ожидает списания (waiting_for_capture). Ссылка подтверждения ведёт на ReturnUrl.
+type fakeProvider struct {
+	sync.Mutex
+	payments    map[string]*Payment
+	refunds     map[string]*Refund
+	idempotence map[string]string
+}
+
+// Срок удержания средств до списания
+const fakeAuthorizationTTL = 7 * 24 * time.Hour
+
+func NewFakeProvider() *fakeProvider {
+	return &fakeProvider{
+		payments:    make(map[string]*Payment),
+		refunds:     make(map[string]*Refund),
+		idempotence: make(map[string]string),
+	}
+}
+
+func (f *fakeProvider) Name() string {
+	return FakeProvider
+}
+
+func (f *fakeProvider) CreatePayment(ctx context.Context, createPayment CreatePayment, idempotenceKey string) (*Payment, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if id, ok := f.idempotence["payment:"+idempotenceKey]; ok {
+		return f.copyPayment(id), nil
+	}
+
+	if _, err := strconv.ParseFloat(createPayment.Amount.Value, 64); err != nil {
+		return nil, f.error("CreatePayment", http.StatusBadRequest, "invalid amount")
+	}
+
+	metadata := createPayment.Metadata
+	payment := &Payment{
+		ID:                 uuid.New().String(),
+		Status:             "waiting_for_capture",
+		Amount:             createPayment.Amount,
+		Description:        createPayment.Description,
+		CreatedAt:          time.Now(),
+		Test:               true,
+		Paid:               true,
+		Metadata:           &metadata,
+		MerchantCustomerID: createPayment.MerchantCustomerID,
+	}
+
+	if createPayment.Capture {
+		f.capture(payment)
+	} else {
+		expiresAt := payment.CreatedAt.Add(fakeAuthorizationTTL)
+		payment.ExpiresAt = &expiresAt
+	}
+
+	if createPayment.Confirmation != nil {
+		payment.Confirmation = &Confirmation{
+			Type:            createPayment.Confirmation.Type,
+			ConfirmationURL: createPayment.Confirmation.ReturnUrl,
+			ReturnUrl:       createPayment.Confirmation.ReturnUrl,
+		}
+	}
+
+	f.payments[payment.ID] = payment
+	f.idempotence["payment:"+idempotenceKey] = payment.ID
+
+	return f.copyPayment(payment.ID), nil
+}
+
+func (f *fakeProvider) GetPayment(ctx context.Context, paymentID string) (*Payment, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, ok := f.payments[paymentID]; !ok {
+		return nil, f.error("GetPayment", http.StatusNotFound, "payment not found")
+	}
+	return f.copyPayment(paymentID), nil
+}
+
+func (f *fakeProvider) CapturePayment(ctx context.Context, idempotenceKey, paymentID string) (*Payment, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	payment, ok := f.payments[paymentID]
+	if !ok {
+		return nil, f.error("CapturePayment", http.StatusNotFound, "payment not found")
+	}
+
+	switch payment.Status {
+	case "succeeded":
+	case "waiting_for_capture":
+		f.capture(payment)
+	default:
+		return nil, f.error("CapturePayment", http.StatusBadRequest, "payment is "+payment.Status)
+	}
+
+	return f.copyPayment(paymentID), nil
+}
+
+func (f *fakeProvider) CancelPayment(ctx context.Context, idempotenceKey, paymentID string) (*Payment, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	payment, ok := f.payments[paymentID]
+	if !ok {
+		return nil, f.error("CancelPayment", http.StatusNotFound, "payment not found")
+	}
+
+	switch payment.Status {
+	case "canceled":
+	case "pending", "waiting_for_capture":
+		payment.Status = "canceled"
+		payment.Paid = false
+		payment.Refundable = false
+		payment.CancellationDatails = &CancellationDatails{Party: "merchant", Reason: "canceled_by_merchant"}
+	default:
+		return nil, f.error("CancelPayment", http.StatusBadRequest, "payment is "+payment.Status)
+	}
+
+	return f.copyPayment(paymentID), nil
+}
+
+func (f *fakeProvider) CreateRefund(ctx context.Context, createRefund CreateRefund, idempotenceKey string) (*Refund, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if id, ok := f.idempotence["refund:"+idempotenceKey]; ok {
+		refund := *f.refunds[id]
+		return &refund, nil
+	}
+
+	payment, ok := f.payments[createRefund.PaymentID]
+	if !ok {
+		return nil, f.error("CreateRefund", http.StatusNotFound, "payment not found")
+	}
+	if payment.Status != "succeeded" {
+		return nil, f.error("CreateRefund", http.StatusBadRequest, "payment is "+payment.Status)
+	}
+
+	amount, err := strconv.ParseFloat(createRefund.Amount.Value, 64)
+	if err != nil || amount <= 0 {
+		return nil, f.error("CreateRefund", http.StatusBadRequest, "invalid amount")
+	}
+
+	paid, _ := strconv.ParseFloat(payment.Amount.Value, 64)
+	refunded := 0.0
+	if payment.RefundedAmount != nil {
+		refunded, _ = strconv.ParseFloat(payment.RefundedAmount.Value, 64)
+	}
+	if refunded+amount > paid {
+		return nil, f.error("CreateRefund", http.StatusBadRequest, "refund amount exceeds payment amount")
+	}
+
+	payment.RefundedAmount = &Amount{
+		Value:    strconv.FormatFloat(refunded+amount, 'f', 2, 64),
+		Currency: payment.Amount.Currency,
+	}
+	payment.Refundable = refunded+amount < paid
+
+	refund := &Refund{
+		ID:        uuid.New().String(),
+		Status:    "succeeded",
+		PaymentID: payment.ID,
+		CreatedAt: time.Now(),
+		Amount:    createRefund.Amount,
+	}
+	f.refunds[refund.ID] = refund
+	f.idempotence["refund:"+idempotenceKey] = refund.ID
+
+	copied := *refund
+	return &copied, nil
+}
+
+func (f *fakeProvider) GetRefund(ctx context.Context, refundID string) (*Refund, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	refund, ok := f.refunds[refundID]
+	if !ok {
+		return nil, f.error("GetRefund", http.StatusNotFound, "refund not found")
+	}
+
+	copied := *refund
+	return &copied, nil
+}
+
+func (f *fakeProvider) capture(payment *Payment) {
+	now := time.Now()
+	payment.Status = "succeeded"
+	payment.ExpiresAt = nil
+	payment.CapturedAt = &now
+	payment.Refundable = true
+}
+
+func (f *fakeProvider) copyPayment(id string) *Payment {
+	payment := *f.payments[id]
+	return &payment
+}
+
+func (f *fakeProvider) error(operation string, statusCode int, description string) error {
+	gwErr := newGatewayError(operation, statusCode, nil)
+	gwErr.Description = description
+	return gwErr
+}
@@ -1,109 +1,269 @@
 package order
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/tenant"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	//systemRole   string = "system"
+	systemRole   string = "system"
 	clientRole   string = "client"
 	deliveryRole string = "delivery"
 	adminRole    string = "admin"
 )
 
 type orderHandler struct {
-	log            *logrus.Entry
-	orderService   OrderService
-	authadapter    *authAdapter
-	paymentAdapter *paymentAdapter
-	redirectPath   string
+	log           *logrus.Entry
+	orderService  OrderService
+	tenantService TenantService
+	tenants       *tenant.Registry
+	resolver      tenant.Resolver
+	authenticator Authenticator
+	payments      *PaymentProviders
+	capture       *paymentCapture
+	redirectPath  string
 }
 
-func NewHandler(orderService OrderService, log *logrus.Entry, authadapter *authAdapter, paymentAdapter *paymentAdapter, redirectPath string) *orderHandler {
+func NewHandler(orderService OrderService, tenantService TenantService, tenants *tenant.Registry, resolver tenant.Resolver, log *logrus.Entry, authenticator Authenticator, payments *PaymentProviders, redirectPath string) *orderHandler {
 	return &orderHandler{
-		log:            log,
-		orderService:   orderService,
-		authadapter:    authadapter,
-		paymentAdapter: paymentAdapter,
-		redirectPath:   redirectPath,
+		log:           log,
+		orderService:  orderService,
+		tenantService: tenantService,
+		tenants:       tenants,
+		resolver:      resolver,
+		authenticator: authenticator,
+		payments:      payments,
+		capture:       newPaymentCapture(orderService, payments, log),
+		redirectPath:  redirectPath,
 	}
 }
 
 func (h *orderHandler) Register(router *gin.Engine) {
-	order := router.Group("/order")
+	api := router.Group("", h.requestIDMiddleware, h.errorMiddleware)
+
+	order := api.Group("/order")
 	{
 		order.POST("", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.CreateOrder)
 		order.POST("/take", h.authWithRoleMiddleware([]string{deliveryRole}), h.TakeOrderСourier)
 		order.POST("/delivered", h.authWithRoleMiddleware([]string{deliveryRole}), h.DeliveredOrderСourier)
+		order.POST("/accept", h.authWithRoleMiddleware([]string{adminRole}), h.AcceptOrder)
 		order.GET("/all", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.GetOrdersByUserID)
 		order.GET("", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.GetOrderByID)
 		order.GET("/delivery", h.authWithRoleMiddleware([]string{deliveryRole}), h.GetUnaxeptedOrderByAddressShopId)
 		order.GET("/delivery/my", h.authWithRoleMiddleware([]string{deliveryRole}), h.GetOrdersByDeliveryID)
+		order.GET("/delivery/my/cash", h.authWithRoleMiddleware([]string{deliveryRole}), h.GetMyCashReport)
+		order.GET("/delivery/cash", h.authWithRoleMiddleware([]string{adminRole}), h.GetCourierCashReport)
+		order.GET("/redirect/:id", h.CheckRedirect)
 		order.GET("/redirect/:id/:domain", h.CheckRedirect)
+		order.POST("/:id/reorder", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.Reorder)
+		order.POST("/:id/pay", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.PayOrder)
+		order.POST("/:id/cancel", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.CancelOrder)
 	}
-	payment := router.Group("/payment")
+	payment := api.Group("/payment")
 	{
-		payment.POST("/capture", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.CapturePayment) // old
-		payment.POST("/cancel", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.CancelPayment)   // old
-		payment.GET("", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.GetPayment)
-		payment.POST("/refund", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.CreateRefund)
-		payment.GET("/refund", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.GetRefund)
+		payment.POST("/capture", h.authWithRoleMiddleware([]string{adminRole}), h.CapturePayment) // old
+		payment.POST("/cancel", h.authWithRoleMiddleware([]string{adminRole}), h.CancelPayment)   // old
+		payment.GET("", h.authWithRoleMiddleware([]string{adminRole}), h.GetPayment)
+		payment.POST("/refund", h.authWithRoleMiddleware([]string{adminRole}), h.CreateRefund)
+		payment.GET("/refund", h.authWithRoleMiddleware([]string{adminRole}), h.GetRefund)
 	}
-	cart := router.Group("/cart")
+	cart := api.Group("/cart")
 	{
 		cart.GET("", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.GetOrCreateCart)
 		cart.POST("/add", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.CartProductAdd)
 		cart.POST("/del", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.CartProductDelete)
 		cart.DELETE("", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.CartProductClear)
+		cart.POST("/quote", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.CartQuote)
+		cart.POST("/promo", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.CartPromoApply)
+		cart.DELETE("/promo", h.authWithRoleMiddleware([]string{adminRole, clientRole}), h.CartPromoRemove)
+	}
+	system := api.Group("/system")
+	{
+		system.POST("/tenants", h.authWithRoleMiddlewareSystem([]string{systemRole}), h.CreateTenant)
+		system.GET("/tenants/:domain/settings", h.authWithRoleMiddlewareSystem([]string{systemRole}), h.GetTenantSettings)
+		system.PUT("/tenants/:domain/settings", h.authWithRoleMiddlewareSystem([]string{systemRole}), h.UpdateTenantSettings)
+	}
+	// Метрики содержат внутреннее состояние сервиса и доступны только системному пользователю
+	api.GET("/debug/vars", h.authWithRoleMiddlewareSystem([]string{systemRole}), gin.WrapH(expvar.Handler()))
+}
+
+func (h *orderHandler) CreateTenant(c *gin.Context) {
+	h.log.Debugf("handler CreateTenant")
+
+	var body CreateTenantRequest
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.log.Debugf("CreateTenant: failed to read body - %v", err)
+		h.errorResponse(c, apiErrInvalidBody)
+		return
+	}
+
+	h.log.Debugf("CreateTenant: domain - %s, email - %s", body.Domain, body.Email)
+
+	id, err := h.tenantService.CreateTenant(c.Request.Context(), body)
+	if err != nil {
+		h.log.Debugf("CreateTenant: CreateTenant err - %v", err)
+		h.errorResponse(c, err)
+		return
 	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"domain": id,
+	})
+}
+
+// Тенант из параметра пути :domain для системных маршрутов
+func (h *orderHandler) pathTenant(c *gin.Context) (string, bool) {
+	id, err := tenant.Parse(c.Param("domain"))
+	if err != nil || !h.tenants.Has(id) {
+		return "", false
+	}
+	return id.String(), true
+}
+
+func (h *orderHandler) GetTenantSettings(c *gin.Context) {
+	h.log.Debugf("handler GetTenantSettings")
+
+	domain, ok := h.pathTenant(c)
+	if !ok {
+		h.errorResponse(c, apiErrTenantNotFound)
+		return
+	}
+
+	settings, err := h.orderService.GetTenantSettings(c.Request.Context(), domain)
+	if err != nil {
+		h.log.Debugf("GetTenantSettings: GetTenantSettings err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	mode, _ := captureMode(settings.CaptureMode)
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment_provider":            settings.PaymentProvider,
+		"available_payment_providers": h.payments.Names(),
+		"capture_mode":                mode,
+	})
+}
+
+func (h *orderHandler) UpdateTenantSettings(c *gin.Context) {
+	h.log.Debugf("handler UpdateTenantSettings")
+
+	domain, ok := h.pathTenant(c)
+	if !ok {
+		h.errorResponse(c, apiErrTenantNotFound)
+		return
+	}
+
+	// Не переданные поля не изменяются
+	var body struct {
+		PaymentProvider *string `json:"payment_provider"`
+		CaptureMode     *string `json:"capture_mode"`
+	}
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.log.Debugf("UpdateTenantSettings: failed to read body - %v", err)
+		h.errorResponse(c, apiErrInvalidBody)
+		return
+	}
+
+	if body.PaymentProvider != nil && *body.PaymentProvider != "" && !h.payments.Has(*body.PaymentProvider) {
+		h.errorResponse(c, toAPIError(errPaymentProviderUnknown).WithDetails(gin.H{"available_payment_providers": h.payments.Names()}))
+		return
+	}
+
+	if body.CaptureMode != nil {
+		if _, err := captureMode(*body.CaptureMode); err != nil {
+			h.errorResponse(c, err)
+			return
+		}
+	}
+
+	settings, err := h.orderService.GetTenantSettings(c.Request.Context(), domain)
+	if err != nil {
+		h.log.Debugf("UpdateTenantSettings: GetTenantSettings err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	if body.PaymentProvider != nil {
+		settings.PaymentProvider = *body.PaymentProvider
+	}
+	if body.CaptureMode != nil {
+		settings.CaptureMode = *body.CaptureMode
+	}
+
+	if err := h.orderService.UpdateTenantSettings(c.Request.Context(), settings, domain); err != nil {
+		h.log.Debugf("UpdateTenantSettings: UpdateTenantSettings err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	mode, _ := captureMode(settings.CaptureMode)
+
+	c.JSON(http.StatusOK, gin.H{
+		"payment_provider": settings.PaymentProvider,
+		"capture_mode":     mode,
+	})
 }
 
 func (h *orderHandler) CheckRedirect(c *gin.Context) {
 	h.log.Debugf("handler CheckRedirect")
 
 	id := c.Param("id")
-	domain := c.Param("domain")
 
-	order, err := h.orderService.GetOrderByPaymentKey(id, domain)
+	domain, ok := h.resolveTenant(c)
+	if !ok {
+		h.log.Debug("CheckRedirect: unknown tenant")
+		h.errorResponse(c, apiErrTenantNotFound)
+		return
+	}
+
+	checkout, err := h.orderService.GetCheckoutByPaymentKey(c.Request.Context(), id, domain)
 	if err != nil {
-		h.log.Errorf("CheckRedirect: GetOrderByPaymentKey err - %v", err)
+		if err == errCheckoutWithPaymentKeyNotfound {
+			h.log.Errorf("CheckRedirect: checkout not found with id - %s, domain - %s ", id, domain)
+			h.errorResponse(c, err)
+			return
+		}
+		h.log.Errorf("CheckRedirect: GetCheckoutByPaymentKey err - %v", err)
 		c.JSON(200, gin.H{})
 		return
 	}
 
-	if order == nil {
-		h.log.Errorf("CheckRedirect: order not found with id - %s, domain - %s ", id, domain)
-		c.AbortWithStatus(http.StatusNotFound)
+	provider, err := h.payments.Get(checkout.PaymentProvider)
+	if err != nil {
+		h.log.Errorf("CheckRedirect: payment provider %s err - %v", checkout.PaymentProvider, err)
+		c.JSON(200, gin.H{})
 		return
 	}
 
-	payment, _, err := h.paymentAdapter.GetPayment(order.PaymentID)
+	payment, err := provider.GetPayment(c.Request.Context(), checkout.PaymentID)
 	if err != nil {
-		h.log.Errorf("CheckRedirect: paymentAdapter GetPayment err - %v", err)
+		h.log.Errorf("CheckRedirect: %s GetPayment err - %v", provider.Name(), err)
 		c.JSON(200, gin.H{})
 		return
 	}
 
 	if payment == nil {
-		h.log.Errorf("CheckRedirect: payment not found (paymentId - %s)", order.PaymentID)
+		h.log.Errorf("CheckRedirect: payment not found (paymentId - %s)", checkout.PaymentID)
 		c.JSON(200, gin.H{})
 		return
 	}
 
-	if payment.Status == "succeeded" {
-		err := h.orderService.PaymentSuccess(order.ID, domain)
-		if err != nil {
-			h.log.Errorf("CheckRedirect: PaymentSuccess err - %v", err)
-			c.JSON(200, gin.H{})
-			return
-		}
+	if err := h.capture.apply(c.Request.Context(), checkout.ID, payment, domain); err != nil {
+		h.log.Errorf("CheckRedirect: apply payment status %s err - %v", payment.Status, err)
 	}
 
 	c.JSON(200, gin.H{})
@@ -115,14 +275,14 @@ func (h *orderHandler) CreateOrder(c *gin.Context) {
 	domain := h.getDomain(c)
 	if domain == "" {
 		h.log.Debug("CreateOrder: domain is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
 	userID, err := h.getUserId(c)
 	if err != nil {
 		h.log.Debugf("CartProductClear: getUserId err - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		h.errorResponse(c, apiErrUserNotDefined)
 		return
 	}
 
@@ -130,87 +290,298 @@ func (h *orderHandler) CreateOrder(c *gin.Context) {
 
 	if err := c.ShouldBindJSON(&order); err != nil {
 		h.log.Debugf("CreateOrder: failed to read body - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, "failed to read body")
+		h.errorResponse(c, apiErrInvalidBody)
 		return
 	}
 
 	h.log.Debugf("CreateOrder: body - %+v", order)
 
-	cart, err := h.orderService.GetCartWithProductsByUserID(userID, domain)
+	order.PaymentMethod, err = paymentMethod(order.PaymentMethod)
+	if err != nil {
+		h.log.Debugf("CreateOrder: paymentMethod err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	cart, err := h.orderService.GetCartWithProductsByUserID(c.Request.Context(), userID, domain)
 	if err != nil {
 		h.log.Debugf("CreateOrder: GetCartWithProductsByUserID err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "failed get cart")
+		h.errorResponse(c, err)
 		return
 	}
 
-	if cart == nil {
-		h.log.Debugf("CreateOrder: cart not found - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+	if cart == nil || len(cart.Products) == 0 {
+		h.log.Debug("CreateOrder: cart is empty")
+		h.errorResponse(c, errCartEmpty)
 		return
 	}
 
-	var totalPrice uint = 0
-	for _, v := range cart.Products {
-		totalPrice += v.Price
+	order.Products = cart.Products
+	order.PaymentKey = uuid.New().String()
+	order.PaymentProvider = ""
+	order.CaptureMode = ""
+	order.UserID = userID
+
+	// При оплате при получении платёж в шлюзе не создаётся
+	var provider PaymentProvider
+	if !payOnDelivery(order.PaymentMethod) {
+		provider, order.CaptureMode, err = h.tenantPayment(c.Request.Context(), domain)
+		if err != nil {
+			h.log.Errorf("CreateOrder: tenantPayment err - %v", err)
+			h.errorResponse(c, err)
+			return
+		}
+		order.PaymentProvider = provider.Name()
 	}
 
-	if totalPrice == 0 {
-		h.log.Debug("CreateOrder: total cart price = 0")
-		h.newErrorResponse(c, http.StatusNoContent, "total cart price = 0")
+	checkout, err := h.orderService.CreateCheckout(c.Request.Context(), &order, cart.PromoCodeID, domain)
+	if err != nil {
+		h.log.Debugf("CreateOrder: CreateCheckout err - %v", err)
+		h.errorResponse(c, err)
 		return
 	}
 
-	order.TotalPrice = float64(totalPrice)
-	order.Products = cart.Products
+	h.log.Debugf("CreateOrder: res body - %+v", checkout)
 
-	h.log.Debugf("CreateOrder: res body - %+v", order)
+	orderIds := make([]string, 0, len(checkout.Orders))
+	for _, o := range checkout.Orders {
+		orderIds = append(orderIds, strconv.FormatUint(uint64(o.ID), 10))
+	}
 
-	order.PaymentKey = uuid.New().String()
-	order.UserID = userID
+	if provider == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"url":            nil,
+			"checkout_id":    checkout.ID,
+			"order_ids":      orderIds,
+			"payment_method": checkout.PaymentMethod,
+		})
+		return
+	}
+
+	createPayment := h.checkoutPayment(checkout, orderIds, order.DeliveryAddress, domain)
 
-	orderId, err := h.orderService.CreateOrder(&order, domain)
+	payment, err := provider.CreatePayment(c.Request.Context(), createPayment, checkout.PaymentKey)
 	if err != nil {
-		h.log.Debugf("CreateOrder: CreateOrder err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		h.log.Debugf("CreateOrder: %s CreatePayment err - %v", provider.Name(), err)
+		if err := h.orderService.PaymentCanceled(c.Request.Context(), checkout.ID, domain); err != nil {
+			h.log.Errorf("CreateOrder: PaymentCanceled err - %v", err)
+		}
+		h.errorResponse(c, err)
 		return
 	}
 
-	returnUrl := fmt.Sprintf("%s%s%s/%s", h.redirectPath, "/redirect/", order.PaymentKey, domain)
-	var createPayment CreatePayment = CreatePayment{
-		Amount: Amount{
-			Value:    strconv.FormatFloat(order.TotalPrice, 'f', 2, 64),
-			Currency: "RUB",
-		},
-		Capture: true,
-		Confirmation: &Confirmation{
-			Type:      "redirect",
-			ReturnUrl: &returnUrl,
-		},
-		Description: &order.DeliveryAddress,
-		Metadata: Metadata{
-			OrderID: strconv.FormatUint(uint64(orderId), 10),
-		},
+	err = h.orderService.UpdateCheckoutPaymentID(c.Request.Context(), checkout.ID, payment.ID, domain)
+	if err != nil {
+		h.log.Debugf("CreateOrder: UpdateCheckoutPaymentID err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	var url *string
+	if payment.Confirmation != nil {
+		url = payment.Confirmation.ConfirmationURL
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":         url,
+		"checkout_id": checkout.ID,
+		"order_ids":   orderIds,
+	})
+}
+
+// Повторная оплата заказа: ссылка на текущий платёж, если он ещё ожидает оплаты,
+// иначе новый платёж оформления взамен отменённого или истёкшего
+func (h *orderHandler) PayOrder(c *gin.Context) {
+	h.log.Debugf("handler PayOrder")
+
+	orderId, err := convertStringToUint(c.Param("id"))
+	if err != nil {
+		h.log.Debugf("PayOrder: convertStringToUint err (id - %v)", c.Param("id"))
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "path parameter id is not a id"))
+		return
 	}
 
-	payment, _, err := h.paymentAdapter.CreatePayment(createPayment, order.PaymentKey)
+	domain := h.getDomain(c)
+	if domain == "" {
+		h.log.Debug("PayOrder: domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
+		return
+	}
+
+	userId, err := h.getUserId(c)
+	if err != nil {
+		h.log.Debugf("PayOrder: getUserId err - %v", err)
+		h.errorResponse(c, apiErrUserNotDefined)
+		return
+	}
+
+	order, err := h.orderService.GetOrderByID(c.Request.Context(), userId, orderId, domain)
+	if err != nil {
+		h.log.Debugf("PayOrder: GetOrderByID err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	checkout, err := h.orderService.GetCheckoutByOrderID(c.Request.Context(), order.ID, domain)
+	if err == nil && checkout == nil {
+		err = errChangePaymentIdNotFound
+	}
+	if err != nil {
+		h.log.Debugf("PayOrder: GetCheckoutByOrderID err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	if payOnDelivery(checkout.PaymentMethod) {
+		h.errorResponse(c, errOrderNotPayable)
+		return
+	}
+
+	if checkout.PaymentStatus.Code != WaitingProcessingPayment && checkout.PaymentStatus.Code != CanceledPayment {
+		h.errorResponse(c, errOrderAlreadyPaid)
+		return
+	}
+
+	provider, err := h.payments.Get(checkout.PaymentProvider)
+	if err != nil {
+		h.log.Errorf("PayOrder: payment provider %s err - %v", checkout.PaymentProvider, err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	orderIds := make([]string, 0, len(checkout.Orders))
+	for _, o := range checkout.Orders {
+		orderIds = append(orderIds, strconv.FormatUint(uint64(o.ID), 10))
+	}
+
+	reason := CanceledPaymentAttempt
+	if checkout.PaymentStatus.Code == WaitingProcessingPayment && checkout.PaymentID != "" {
+		payment, err := provider.GetPayment(c.Request.Context(), checkout.PaymentID)
+		var gwErr *GatewayError
+		switch {
+		case errors.As(err, &gwErr) && gwErr.HTTPCode == http.StatusNotFound:
+			reason = NotFoundPaymentAttempt
+		case err != nil:
+			h.log.Debugf("PayOrder: %s GetPayment err - %v", provider.Name(), err)
+			h.errorResponse(c, err)
+			return
+		default:
+			switch payment.Status {
+			case "pending":
+				if (payment.ExpiresAt == nil || payment.ExpiresAt.After(time.Now())) &&
+					payment.Confirmation != nil && payment.Confirmation.ConfirmationURL != nil {
+					c.JSON(http.StatusOK, gin.H{
+						"url":         payment.Confirmation.ConfirmationURL,
+						"checkout_id": checkout.ID,
+						"order_ids":   orderIds,
+						"payment_id":  payment.ID,
+						"renewed":     false,
+					})
+					return
+				}
+
+				// Прежний платёж отменяется, чтобы покупатель не оплатил заказ дважды;
+				// без подтверждения шлюза новый платёж не создаётся
+				reason = ExpiredPaymentAttempt
+				if _, err := provider.CancelPayment(c.Request.Context(), "cancel-"+payment.ID, payment.ID); err != nil {
+					h.log.Errorf("PayOrder: %s CancelPayment (paymentId - %s) err - %v", provider.Name(), payment.ID, err)
+					h.errorResponse(c, err)
+					return
+				}
+			case "succeeded", "waiting_for_capture":
+				if err := h.capture.apply(c.Request.Context(), checkout.ID, payment, domain); err != nil {
+					h.log.Errorf("PayOrder: apply payment status %s err - %v", payment.Status, err)
+				}
+				h.errorResponse(c, errOrderAlreadyPaid)
+				return
+			}
+		}
+	}
+
+	paymentKey := uuid.New().String()
+	err = h.orderService.RenewCheckoutPayment(c.Request.Context(), checkout.ID, checkout.PaymentID, reason, paymentKey, domain)
 	if err != nil {
-		h.log.Debugf("CreateOrder: paymentAdapter CreatePayment err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "failed create payment")
+		h.log.Debugf("PayOrder: RenewCheckoutPayment err - %v", err)
+		h.errorResponse(c, err)
 		return
 	}
+	checkout.PaymentKey = paymentKey
 
-	err = h.orderService.UpdateOrderPaymentID(orderId, payment.ID, domain)
+	createPayment := h.checkoutPayment(checkout, orderIds, order.DeliveryAddress, domain)
+
+	payment, err := provider.CreatePayment(c.Request.Context(), createPayment, paymentKey)
 	if err != nil {
-		h.log.Debugf("CreateOrder: UpdateOrderPaymentID err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "update paymentId in orderIdFailed")
+		h.log.Debugf("PayOrder: %s CreatePayment err - %v", provider.Name(), err)
+		if err := h.orderService.PaymentCanceled(c.Request.Context(), checkout.ID, domain); err != nil {
+			h.log.Errorf("PayOrder: PaymentCanceled err - %v", err)
+		}
+		h.errorResponse(c, err)
 		return
 	}
 
+	err = h.orderService.UpdateCheckoutPaymentID(c.Request.Context(), checkout.ID, payment.ID, domain)
+	if err != nil {
+		h.log.Debugf("PayOrder: UpdateCheckoutPaymentID err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	var url *string
+	if payment.Confirmation != nil {
+		url = payment.Confirmation.ConfirmationURL
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"url": payment.Confirmation.ConfirmationURL,
+		"url":         url,
+		"checkout_id": checkout.ID,
+		"order_ids":   orderIds,
+		"payment_id":  payment.ID,
+		"renewed":     true,
 	})
 }
 
+// Отмена своего заказа: удержание средств снимается, резервы товаров освобождаются
+func (h *orderHandler) CancelOrder(c *gin.Context) {
+	h.log.Debugf("handler CancelOrder")
+
+	orderId, err := convertStringToUint(c.Param("id"))
+	if err != nil {
+		h.log.Debugf("CancelOrder: convertStringToUint err (id - %v)", c.Param("id"))
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "path parameter id is not a id"))
+		return
+	}
+
+	domain := h.getDomain(c)
+	if domain == "" {
+		h.log.Debug("CancelOrder: domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
+		return
+	}
+
+	userId, err := h.getUserId(c)
+	if err != nil {
+		h.log.Debugf("CancelOrder: getUserId err - %v", err)
+		h.errorResponse(c, apiErrUserNotDefined)
+		return
+	}
+
+	order, err := h.orderService.GetOrderByID(c.Request.Context(), userId, orderId, domain)
+	if err != nil {
+		h.log.Debugf("CancelOrder: GetOrderByID err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	err = h.capture.cancelOrder(c.Request.Context(), order.ID, domain)
+	if err != nil {
+		h.log.Debugf("CancelOrder: cancelOrder (orderId - %d) err - %v", order.ID, err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
 func (h *orderHandler) CapturePayment(c *gin.Context) {
 	h.log.Debugf("handler CapturePayment")
 
@@ -221,12 +592,13 @@ func (h *orderHandler) CapturePayment(c *gin.Context) {
 
 	if err := c.ShouldBindJSON(&body); err != nil {
 		h.log.Debugf("CapturePayment: failed to read body - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, "failed to read body")
+		h.errorResponse(c, apiErrInvalidBody)
 		return
 	}
 
 	if body.PaymentID == "" {
-		h.newErrorResponse(c, http.StatusBadRequest, "CapturePayment: PaymentID is not defined (body)")
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "paymentID is not defined (body)"))
+		return
 	}
 
 	if body.IdempotenceKey == "" {
@@ -235,13 +607,30 @@ func (h *orderHandler) CapturePayment(c *gin.Context) {
 
 	h.log.Debugf("CapturePayment: body - %+v", body)
 
-	payment, httpcode, err := h.paymentAdapter.CapturePayment(body.IdempotenceKey, body.PaymentID)
+	provider, err := h.paymentProviderByPaymentID(c, body.PaymentID)
+	if err != nil {
+		h.log.Debugf("CapturePayment: payment provider err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	payment, err := provider.CapturePayment(c.Request.Context(), body.IdempotenceKey, body.PaymentID)
 	if err != nil {
-		h.log.Debugf("CapturePayment: paymentAdapter CapturePayment err (http - %d) - %v", httpcode, err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "failed capture payment")
+		h.log.Debugf("CapturePayment: %s CapturePayment err - %v", provider.Name(), err)
+		h.errorResponse(c, err)
 		return
 	}
 
+	if domain := h.getDomain(c); domain != "" {
+		checkout, err := h.orderService.GetCheckoutByPaymentID(c.Request.Context(), payment.ID, domain)
+		if err == nil {
+			err = h.capture.apply(c.Request.Context(), checkout.ID, payment, domain)
+		}
+		if err != nil && !errors.Is(err, errCheckoutWithPaymentIdNotFound) {
+			h.log.Errorf("CapturePayment: apply payment status err - %v", err)
+		}
+	}
+
 	c.JSON(http.StatusOK, payment)
 }
 
@@ -255,12 +644,13 @@ func (h *orderHandler) CancelPayment(c *gin.Context) {
 
 	if err := c.ShouldBindJSON(&body); err != nil {
 		h.log.Debugf("CancelPayment: failed to read body - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, "failed to read body")
+		h.errorResponse(c, apiErrInvalidBody)
 		return
 	}
 
 	if body.PaymentID == "" {
-		h.newErrorResponse(c, http.StatusBadRequest, "CancelPayment: PaymentID is not defined (body)")
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "paymentID is not defined (body)"))
+		return
 	}
 
 	if body.IdempotenceKey == "" {
@@ -269,13 +659,30 @@ func (h *orderHandler) CancelPayment(c *gin.Context) {
 
 	h.log.Debugf("CancelPayment: body - %+v", body)
 
-	payment, httpcode, err := h.paymentAdapter.CancelPayment(body.IdempotenceKey, body.PaymentID)
+	provider, err := h.paymentProviderByPaymentID(c, body.PaymentID)
 	if err != nil {
-		h.log.Debugf("CancelPayment: paymentAdapter CancelPayment err (http - %d) - %v", httpcode, err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "failed cancel payment")
+		h.log.Debugf("CancelPayment: payment provider err - %v", err)
+		h.errorResponse(c, err)
 		return
 	}
 
+	payment, err := provider.CancelPayment(c.Request.Context(), body.IdempotenceKey, body.PaymentID)
+	if err != nil {
+		h.log.Debugf("CancelPayment: %s CancelPayment err - %v", provider.Name(), err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	if domain := h.getDomain(c); domain != "" && payment.Status == "canceled" {
+		checkout, err := h.orderService.GetCheckoutByPaymentID(c.Request.Context(), payment.ID, domain)
+		if err == nil {
+			err = h.orderService.PaymentCanceled(c.Request.Context(), checkout.ID, domain)
+		}
+		if err != nil {
+			h.log.Errorf("CancelPayment: release order reservations err - %v", err)
+		}
+	}
+
 	c.JSON(http.StatusOK, payment)
 }
 
@@ -289,7 +696,7 @@ func (h *orderHandler) CreateRefund(c *gin.Context) {
 
 	if err := c.ShouldBindJSON(&body); err != nil {
 		h.log.Debugf("CreateRefund: failed to read body - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, "failed to read body")
+		h.errorResponse(c, apiErrInvalidBody)
 		return
 	}
 
@@ -299,10 +706,17 @@ func (h *orderHandler) CreateRefund(c *gin.Context) {
 		body.IdempotenceKey = uuid.New().String()
 	}
 
-	refund, httpcode, err := h.paymentAdapter.CreateRefund(body.CreateRefund, body.IdempotenceKey)
+	provider, err := h.paymentProviderByPaymentID(c, body.PaymentID)
+	if err != nil {
+		h.log.Debugf("CreateRefund: payment provider err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	refund, err := provider.CreateRefund(c.Request.Context(), body.CreateRefund, body.IdempotenceKey)
 	if err != nil {
-		h.log.Debugf("CreateRefund: paymentAdapter CreateRefund err (httpcode - %d) - %v", httpcode, err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "failed create refund")
+		h.log.Debugf("CreateRefund: %s CreateRefund err - %v", provider.Name(), err)
+		h.errorResponse(c, err)
 		return
 	}
 
@@ -318,16 +732,23 @@ func (h *orderHandler) GetRefund(c *gin.Context) {
 
 	if err := c.ShouldBindJSON(&body); err != nil {
 		h.log.Debugf("GetRefund: failed to read body - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, "failed to read body")
+		h.errorResponse(c, apiErrInvalidBody)
 		return
 	}
 
 	h.log.Debugf("GetRefund: body - %+v", body)
 
-	refund, httpcode, err := h.paymentAdapter.GetRefund(body.RefundId)
+	provider, err := h.tenantPaymentProvider(c.Request.Context(), h.getDomain(c))
 	if err != nil {
-		h.log.Debugf("GetRefund: paymentAdapter GetRefund err (httpcode - %d) - %v", httpcode, err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "failed get refund")
+		h.log.Debugf("GetRefund: payment provider err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	refund, err := provider.GetRefund(c.Request.Context(), body.RefundId)
+	if err != nil {
+		h.log.Debugf("GetRefund: %s GetRefund err - %v", provider.Name(), err)
+		h.errorResponse(c, err)
 		return
 	}
 
@@ -343,16 +764,23 @@ func (h *orderHandler) GetPayment(c *gin.Context) {
 
 	if err := c.ShouldBindJSON(&body); err != nil {
 		h.log.Debugf("GetPayment: failed to read body - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, "failed to read body")
+		h.errorResponse(c, apiErrInvalidBody)
 		return
 	}
 
 	h.log.Debugf("GetPayment: body - %+v", body)
 
-	payment, httpcode, err := h.paymentAdapter.GetPayment(body.PaymentId)
+	provider, err := h.paymentProviderByPaymentID(c, body.PaymentId)
 	if err != nil {
-		h.log.Debugf("GetPayment: paymentAdapter GetPayment err (httpcode - %d) - %v", httpcode, err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "failed get payment")
+		h.log.Debugf("GetPayment: payment provider err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	payment, err := provider.GetPayment(c.Request.Context(), body.PaymentId)
+	if err != nil {
+		h.log.Debugf("GetPayment: %s GetPayment err - %v", provider.Name(), err)
+		h.errorResponse(c, err)
 		return
 	}
 
@@ -365,40 +793,35 @@ func (h *orderHandler) TakeOrderСourier(c *gin.Context) {
 	orderIdStr := c.Query("orderId")
 	if orderIdStr == "" {
 		h.log.Debug("TakeOrderСourier: orderId is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "missing query parameter (orderId)")
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "missing query parameter (orderId)"))
 		return
 	}
 
 	orderId, err := convertStringToUint(orderIdStr)
 	if err != nil {
 		h.log.Debugf("TakeOrderСourier: convertStringToUint err (orderIdStr - %v)", orderIdStr)
-		h.newErrorResponse(c, http.StatusBadRequest, "query parameter orderId is not a id")
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "query parameter orderId is not a id"))
 		return
 	}
 
 	domain := h.getDomain(c)
 	if domain == "" {
 		h.log.Debug("TakeOrderСourier: domain is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
 	userId, err := h.getUserId(c)
 	if err != nil {
 		h.log.Debugf("TakeOrderСourier: getUserId err - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		h.errorResponse(c, apiErrUserNotDefined)
 		return
 	}
 
-	err = h.orderService.TakeOrderСourier(userId, orderId, domain)
+	err = h.orderService.TakeOrderСourier(c.Request.Context(), userId, orderId, domain)
 	if err != nil {
-		if err == errTakeOrderNotFound {
-			h.log.Debug("TakeOrderСourier: TakeOrderСourier notfound")
-			c.AbortWithStatus(http.StatusNotFound)
-			return
-		}
 		h.log.Debugf("TakeOrderСourier: TakeOrderСourier err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		h.errorResponse(c, err)
 		return
 	}
 
@@ -411,43 +834,94 @@ func (h *orderHandler) DeliveredOrderСourier(c *gin.Context) {
 	orderIdStr := c.Query("orderId")
 	if orderIdStr == "" {
 		h.log.Debug("DeliveredOrderСourier: orderId is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "missing query parameter (orderId)")
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "missing query parameter (orderId)"))
 		return
 	}
 
 	orderId, err := convertStringToUint(orderIdStr)
 	if err != nil {
 		h.log.Debugf("TakeOrderСourier: convertStringToUint err (orderIdStr - %v)", orderIdStr)
-		h.newErrorResponse(c, http.StatusBadRequest, "query parameter orderId is not a id")
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "query parameter orderId is not a id"))
 		return
 	}
 
 	domain := h.getDomain(c)
 	if domain == "" {
 		h.log.Debug("DeliveredOrderСourier: domain is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
 	userId, err := h.getUserId(c)
 	if err != nil {
 		h.log.Debugf("DeliveredOrderСourier: getUserId err - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		h.errorResponse(c, apiErrUserNotDefined)
 		return
 	}
 
-	err = h.orderService.DeliveredOrderСourier(userId, orderId, domain)
-	if err != nil {
-		if err == errOrderWithCourierNotFound {
-			h.log.Debug("DeliveredOrderСourier: DeliveredOrderСourier notfound")
-			c.AbortWithStatus(http.StatusNotFound)
+	// Сумма, полученная курьером при оплате при получении
+	var collectedAmount *float64
+	if amountStr := c.Query("collectedAmount"); amountStr != "" {
+		amount, err := strconv.ParseFloat(amountStr, 64)
+		if err != nil || amount < 0 {
+			h.log.Debugf("DeliveredOrderСourier: invalid collectedAmount - %s", amountStr)
+			h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "query parameter collectedAmount is not a amount"))
 			return
 		}
+		collectedAmount = &amount
+	}
+
+	err = h.orderService.DeliveredOrderСourier(c.Request.Context(), userId, orderId, collectedAmount, domain)
+	if err != nil {
 		h.log.Debugf("DeliveredOrderСourier: DeliveredOrderСourier err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		h.errorResponse(c, err)
+		return
+	}
+
+	// Доставка уже зафиксирована; при ошибке списание повторит ReservationWorker
+	if err := h.capture.captureOrder(c.Request.Context(), orderId, domain); err != nil {
+		h.log.Errorf("DeliveredOrderСourier: captureOrder (orderId - %d) err - %v", orderId, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (h *orderHandler) AcceptOrder(c *gin.Context) {
+	h.log.Debugf("handler AcceptOrder")
+
+	orderIdStr := c.Query("orderId")
+	if orderIdStr == "" {
+		h.log.Debug("AcceptOrder: orderId is not defined")
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "missing query parameter (orderId)"))
+		return
+	}
+
+	orderId, err := convertStringToUint(orderIdStr)
+	if err != nil {
+		h.log.Debugf("AcceptOrder: convertStringToUint err (orderIdStr - %v)", orderIdStr)
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "query parameter orderId is not a id"))
+		return
+	}
+
+	domain := h.getDomain(c)
+	if domain == "" {
+		h.log.Debug("AcceptOrder: domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
+		return
+	}
+
+	err = h.orderService.AcceptOrder(c.Request.Context(), orderId, domain)
+	if err != nil {
+		h.log.Debugf("AcceptOrder: AcceptOrder err - %v", err)
+		h.errorResponse(c, err)
 		return
 	}
 
+	// Принятие уже зафиксировано; при ошибке списание повторит ReservationWorker
+	if err := h.capture.captureOrder(c.Request.Context(), orderId, domain); err != nil {
+		h.log.Errorf("AcceptOrder: captureOrder (orderId - %d) err - %v", orderId, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{})
 }
 
@@ -457,25 +931,25 @@ func (h *orderHandler) GetOrdersByUserID(c *gin.Context) {
 	domain := h.getDomain(c)
 	if domain == "" {
 		h.log.Debug("GetOrdersByUserID: domain is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
 	userId, err := h.getUserId(c)
 	if err != nil {
 		h.log.Debugf("GetOrdersByUserID: getUserId err - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		h.errorResponse(c, apiErrUserNotDefined)
 		return
 	}
 
-	orders, err := h.orderService.GetOrdersByUserID(userId, domain)
+	orders, err := h.orderService.GetOrdersByUserID(c.Request.Context(), userId, domain)
 	if err != nil {
 		h.log.Debugf("GetOrdersByUserID: GetOrdersByUserID err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		h.errorResponse(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, orders)
+	c.JSON(http.StatusOK, newOrderResponses(orders, parseLocale(c.GetHeader("Accept-Language"))))
 }
 
 func (h *orderHandler) GetOrderByID(c *gin.Context) {
@@ -484,44 +958,85 @@ func (h *orderHandler) GetOrderByID(c *gin.Context) {
 	orderIdStr := c.Query("orderId")
 	if orderIdStr == "" {
 		h.log.Debug("GetOrderByID: orderId is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "missing query parameter (orderId)")
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "missing query parameter (orderId)"))
 		return
 	}
 
 	orderId, err := convertStringToUint(orderIdStr)
 	if err != nil {
 		h.log.Debugf("GetOrderByID: convertStringToUint err (orderIdStr - %v)", orderIdStr)
-		h.newErrorResponse(c, http.StatusBadRequest, "query parameter orderId is not a id")
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "query parameter orderId is not a id"))
 		return
 	}
 
 	domain := h.getDomain(c)
 	if domain == "" {
 		h.log.Debug("GetOrderByID: domain is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
 	userId, err := h.getUserId(c)
 	if err != nil {
 		h.log.Debugf("GetOrderByID: getUserId err - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		h.errorResponse(c, apiErrUserNotDefined)
+		return
+	}
+
+	order, err := h.orderService.GetOrderByID(c.Request.Context(), userId, orderId, domain)
+	if err != nil {
+		h.log.Debugf("GetOrderByID: GetOrderByID err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newOrderResponse(order, parseLocale(c.GetHeader("Accept-Language"))))
+}
+
+func (h *orderHandler) Reorder(c *gin.Context) {
+	h.log.Debugf("handler Reorder")
+
+	orderId, err := convertStringToUint(c.Param("id"))
+	if err != nil {
+		h.log.Debugf("Reorder: convertStringToUint err (id - %v)", c.Param("id"))
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "path parameter id is not a id"))
+		return
+	}
+
+	domain := h.getDomain(c)
+	if domain == "" {
+		h.log.Debug("Reorder: domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
-	order, err := h.orderService.GetOrderByID(userId, orderId, domain)
+	userId, err := h.getUserId(c)
 	if err != nil {
-		if err == errOrderWithUserIdAndOrderIdNotFound {
-			h.log.Debug("GetOrderByID: GetOrderByID notfound")
-			h.newErrorResponse(c, http.StatusNotFound, err.Error())
+		h.log.Debugf("Reorder: getUserId err - %v", err)
+		h.errorResponse(c, apiErrUserNotDefined)
+		return
+	}
+
+	var body struct {
+		Mode string `json:"mode"`
+	}
+
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			h.log.Debugf("Reorder: failed to read body - %v", err)
+			h.errorResponse(c, apiErrInvalidBody)
 			return
 		}
-		h.log.Debugf("GetOrderByID: GetOrderByID err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
+
+	report, err := h.orderService.Reorder(c.Request.Context(), userId, orderId, body.Mode, domain)
+	if err != nil {
+		h.log.Debugf("Reorder: Reorder err - %v", err)
+		h.errorResponse(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, order)
+	c.JSON(http.StatusOK, report)
 }
 
 func (h *orderHandler) GetOrdersByDeliveryID(c *gin.Context) {
@@ -530,25 +1045,25 @@ func (h *orderHandler) GetOrdersByDeliveryID(c *gin.Context) {
 	domain := h.getDomain(c)
 	if domain == "" {
 		h.log.Debug("GetOrdersByDeliveryID: domain is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
 	userId, err := h.getUserId(c)
 	if err != nil {
 		h.log.Debugf("GetOrdersByDeliveryID: getUserId err - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		h.errorResponse(c, apiErrUserNotDefined)
 		return
 	}
 
-	orders, err := h.orderService.GetOrdersByDeliveryID(userId, domain)
+	orders, err := h.orderService.GetOrdersByDeliveryID(c.Request.Context(), userId, domain)
 	if err != nil {
 		h.log.Debugf("GetOrdersByDeliveryID: GetOrdersByDeliveryID err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		h.errorResponse(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, orders)
+	c.JSON(http.StatusOK, newOrderResponses(orders, parseLocale(c.GetHeader("Accept-Language"))))
 }
 
 func (h *orderHandler) GetUnaxeptedOrderByAddressShopId(c *gin.Context) {
@@ -560,7 +1075,7 @@ func (h *orderHandler) GetUnaxeptedOrderByAddressShopId(c *gin.Context) {
 
 	if err := c.ShouldBindJSON(&body); err != nil {
 		h.log.Debugf("GetUnaxeptedOrderByAddressShopId: failed to read body - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, "failed to read body")
+		h.errorResponse(c, apiErrInvalidBody)
 		return
 	}
 
@@ -569,18 +1084,79 @@ func (h *orderHandler) GetUnaxeptedOrderByAddressShopId(c *gin.Context) {
 	domain := h.getDomain(c)
 	if domain == "" {
 		h.log.Debug("GetOrdersByDeliveryID: domain is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
-	orders, err := h.orderService.GetUnaxeptedOrderByAddressShopId(body.AddressShopId, domain)
+	orders, err := h.orderService.GetUnaxeptedOrderByAddressShopId(c.Request.Context(), body.AddressShopId, domain)
 	if err != nil {
 		h.log.Debugf("GetUnaxeptedOrderByAddressShopId: GetUnaxeptedOrderByAddressShopId err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		h.errorResponse(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, orders)
+	c.JSON(http.StatusOK, newOrderResponses(orders, parseLocale(c.GetHeader("Accept-Language"))))
+}
+
+// Сверка наличных всех курьеров (или курьера courierId) за период from - to (YYYY-MM-DD)
+func (h *orderHandler) GetCourierCashReport(c *gin.Context) {
+	h.log.Debugf("handler GetCourierCashReport")
+
+	var courierID *uint
+	if courierIdStr := c.Query("courierId"); courierIdStr != "" {
+		id, err := convertStringToUint(courierIdStr)
+		if err != nil {
+			h.log.Debugf("GetCourierCashReport: convertStringToUint err (courierIdStr - %v)", courierIdStr)
+			h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "query parameter courierId is not a id"))
+			return
+		}
+		courierID = &id
+	}
+
+	h.cashReport(c, courierID)
+}
+
+// Сверка наличных текущего курьера
+func (h *orderHandler) GetMyCashReport(c *gin.Context) {
+	h.log.Debugf("handler GetMyCashReport")
+
+	userId, err := h.getUserId(c)
+	if err != nil {
+		h.log.Debugf("GetMyCashReport: getUserId err - %v", err)
+		h.errorResponse(c, apiErrUserNotDefined)
+		return
+	}
+
+	h.cashReport(c, &userId)
+}
+
+func (h *orderHandler) cashReport(c *gin.Context, courierID *uint) {
+	domain := h.getDomain(c)
+	if domain == "" {
+		h.log.Debug("cashReport: domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
+		return
+	}
+
+	from, to, err := cashReportPeriod(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		h.log.Debugf("cashReport: cashReportPeriod err - %v", err)
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "query parameters from and to must be dates (YYYY-MM-DD)"))
+		return
+	}
+
+	reports, err := h.orderService.GetCourierCashReport(c.Request.Context(), courierID, from, to, domain)
+	if err != nil {
+		h.log.Debugf("cashReport: GetCourierCashReport err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from.Format(cashReportDateLayout),
+		"to":       to.AddDate(0, 0, -1).Format(cashReportDateLayout),
+		"couriers": reports,
+	})
 }
 
 func (h *orderHandler) GetOrCreateCart(c *gin.Context) {
@@ -589,31 +1165,31 @@ func (h *orderHandler) GetOrCreateCart(c *gin.Context) {
 	domain := h.getDomain(c)
 	if domain == "" {
 		h.log.Debug("GetCart: domain is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
 	userID, err := h.getUserId(c)
 	if err != nil {
 		h.log.Debugf("GetCart: getUserId err - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		h.errorResponse(c, apiErrUserNotDefined)
 		return
 	}
 
-	cart, err := h.orderService.GetCartWithProductsByUserID(userID, domain)
+	cart, err := h.orderService.GetCartWithProductsByUserID(c.Request.Context(), userID, domain)
 	if err != nil {
 		h.log.Debugf("GetCart: GetCartWithProductsByUserID err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "failed get cart")
+		h.errorResponse(c, err)
 		return
 	}
 
 	if cart == nil {
 		newCart := Cart{}
 		newCart.UserID = userID
-		_, err := h.orderService.CreateCart(&newCart, domain)
+		_, err := h.orderService.CreateCart(c.Request.Context(), &newCart, domain)
 		if err != nil {
 			h.log.Debugf("GetCart: CreateCart err - %v", err)
-			h.newErrorResponse(c, http.StatusInternalServerError, "failed create cart")
+			h.errorResponse(c, err)
 			return
 		}
 		cart = &newCart
@@ -628,14 +1204,14 @@ func (h *orderHandler) CartProductAdd(c *gin.Context) {
 	domain := h.getDomain(c)
 	if domain == "" {
 		h.log.Debug("CartProductAdd: domain is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
 	userID, err := h.getUserId(c)
 	if err != nil {
 		h.log.Debugf("CartProductAdd: getUserId err - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		h.errorResponse(c, apiErrUserNotDefined)
 		return
 	}
 
@@ -643,16 +1219,16 @@ func (h *orderHandler) CartProductAdd(c *gin.Context) {
 
 	if err := c.ShouldBindJSON(&body); err != nil {
 		h.log.Debugf("CartProductAdd: failed to read body - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, "failed to read body")
+		h.errorResponse(c, apiErrInvalidBody)
 		return
 	}
 
 	h.log.Debugf("CartProductAdd: body - %+v", body)
 
-	err = h.orderService.AddProductToCart(userID, &body, domain)
+	err = h.orderService.AddProductToCart(c.Request.Context(), userID, &body, domain)
 	if err != nil {
 		h.log.Debugf("CartProductAdd: CartProductAdd err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "failed add product to cart")
+		h.errorResponse(c, err)
 		return
 	}
 
@@ -665,14 +1241,14 @@ func (h *orderHandler) CartProductDelete(c *gin.Context) {
 	domain := h.getDomain(c)
 	if domain == "" {
 		h.log.Debug("CartProductDelete: domain is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
 	userID, err := h.getUserId(c)
 	if err != nil {
 		h.log.Debugf("CartProductDelete: getUserId err - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		h.errorResponse(c, apiErrUserNotDefined)
 		return
 	}
 
@@ -680,16 +1256,16 @@ func (h *orderHandler) CartProductDelete(c *gin.Context) {
 
 	if err := c.ShouldBindJSON(&body); err != nil {
 		h.log.Debugf("CartProductDelete: failed to read body - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, "failed to read body")
+		h.errorResponse(c, apiErrInvalidBody)
 		return
 	}
 
 	h.log.Debugf("CartProductDelete: body - %+v", body)
 
-	err = h.orderService.RemoveProductFromCart(userID, &body, domain)
+	err = h.orderService.RemoveProductFromCart(c.Request.Context(), userID, &body, domain)
 	if err != nil {
 		h.log.Debugf("CartProductDelete: CartProductDelete err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "failed delete product from cart")
+		h.errorResponse(c, err)
 		return
 	}
 
@@ -702,37 +1278,204 @@ func (h *orderHandler) CartProductClear(c *gin.Context) {
 	domain := h.getDomain(c)
 	if domain == "" {
 		h.log.Debug("CartProductClear: domain is not defined")
-		h.newErrorResponse(c, http.StatusBadRequest, "domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
 		return
 	}
 
 	userID, err := h.getUserId(c)
 	if err != nil {
 		h.log.Debugf("CartProductClear: getUserId err - %v", err)
-		h.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		h.errorResponse(c, apiErrUserNotDefined)
 		return
 	}
 
-	err = h.orderService.ClearCartProducts(userID, domain)
+	err = h.orderService.ClearCartProducts(c.Request.Context(), userID, domain)
 	if err != nil {
 		h.log.Debugf("CartProductClear: ClearCartProducts err - %v", err)
-		h.newErrorResponse(c, http.StatusInternalServerError, "failed delete products from cart")
+		h.errorResponse(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{})
 }
 
-type response struct {
-	Message string `json:"message"`
+func (h *orderHandler) CartQuote(c *gin.Context) {
+	h.log.Debugf("handler CartQuote")
+
+	domain := h.getDomain(c)
+	if domain == "" {
+		h.log.Debug("CartQuote: domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
+		return
+	}
+
+	userID, err := h.getUserId(c)
+	if err != nil {
+		h.log.Debugf("CartQuote: getUserId err - %v", err)
+		h.errorResponse(c, apiErrUserNotDefined)
+		return
+	}
+
+	var body QuoteRequest
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.log.Debugf("CartQuote: failed to read body - %v", err)
+		h.errorResponse(c, apiErrInvalidBody)
+		return
+	}
+
+	h.log.Debugf("CartQuote: body - %+v", body)
+
+	quote, err := h.orderService.QuoteCart(c.Request.Context(), userID, body, domain)
+	if err != nil {
+		h.log.Debugf("CartQuote: QuoteCart err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, quote)
 }
 
-// Формирование respone
-func (h *orderHandler) newErrorResponse(c *gin.Context, statusCode int, message string) {
-	h.log.Errorf(message)
-	c.AbortWithStatusJSON(statusCode, &response{
-		Message: message,
-	})
+func (h *orderHandler) CartPromoApply(c *gin.Context) {
+	h.log.Debugf("handler CartPromoApply")
+
+	domain := h.getDomain(c)
+	if domain == "" {
+		h.log.Debug("CartPromoApply: domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
+		return
+	}
+
+	userID, err := h.getUserId(c)
+	if err != nil {
+		h.log.Debugf("CartPromoApply: getUserId err - %v", err)
+		h.errorResponse(c, apiErrUserNotDefined)
+		return
+	}
+
+	var body struct {
+		QuoteRequest
+		Code string `json:"code"`
+	}
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		h.log.Debugf("CartPromoApply: failed to read body - %v", err)
+		h.errorResponse(c, apiErrInvalidBody)
+		return
+	}
+
+	h.log.Debugf("CartPromoApply: body - %+v", body)
+
+	if body.Code == "" {
+		h.errorResponse(c, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "promo code is not defined (body)"))
+		return
+	}
+
+	quote, err := h.orderService.ApplyPromoCode(c.Request.Context(), userID, body.Code, body.QuoteRequest, domain)
+	if err != nil {
+		h.log.Debugf("CartPromoApply: ApplyPromoCode err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, quote)
+}
+
+func (h *orderHandler) CartPromoRemove(c *gin.Context) {
+	h.log.Debugf("handler CartPromoRemove")
+
+	domain := h.getDomain(c)
+	if domain == "" {
+		h.log.Debug("CartPromoRemove: domain is not defined")
+		h.errorResponse(c, apiErrTenantNotDefined)
+		return
+	}
+
+	userID, err := h.getUserId(c)
+	if err != nil {
+		h.log.Debugf("CartPromoRemove: getUserId err - %v", err)
+		h.errorResponse(c, apiErrUserNotDefined)
+		return
+	}
+
+	err = h.orderService.RemovePromoCode(c.Request.Context(), userID, domain)
+	if err != nil {
+		h.log.Debugf("CartPromoRemove: RemovePromoCode err - %v", err)
+		h.errorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// Платёжный шлюз магазина по его настройкам
+func (h *orderHandler) tenantPaymentProvider(ctx context.Context, domain string) (PaymentProvider, error) {
+	settings, err := h.orderService.GetTenantSettings(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	return h.payments.Get(settings.PaymentProvider)
+}
+
+// Запрос на создание платежа оформления; возврат покупателя - на страницу проверки оплаты по ключу платежа
+func (h *orderHandler) checkoutPayment(checkout *Checkout, orderIds []string, description string, domain string) CreatePayment {
+	returnUrl := fmt.Sprintf("%s%s%s/%s", h.redirectPath, "/redirect/", checkout.PaymentKey, domain)
+	return CreatePayment{
+		Amount: Amount{
+			Value:    strconv.FormatFloat(checkout.TotalPrice, 'f', 2, 64),
+			Currency: "RUB",
+		},
+		Capture: checkout.CaptureMode == AutoCaptureMode,
+		Confirmation: &Confirmation{
+			Type:      "redirect",
+			ReturnUrl: &returnUrl,
+		},
+		Description: &description,
+		Metadata: Metadata{
+			OrderID:    strings.Join(orderIds, ","),
+			CheckoutID: strconv.FormatUint(uint64(checkout.ID), 10),
+		},
+	}
+}
+
+// Платёжный шлюз и режим списания для новых оформлений по настройкам магазина
+func (h *orderHandler) tenantPayment(ctx context.Context, domain string) (PaymentProvider, string, error) {
+	settings, err := h.orderService.GetTenantSettings(ctx, domain)
+	if err != nil {
+		return nil, "", err
+	}
+
+	provider, err := h.payments.Get(settings.PaymentProvider)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mode, err := captureMode(settings.CaptureMode)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return provider, mode, nil
+}
+
+// Платёжный шлюз, в котором создан платёж. Платёж должен принадлежать оформлению магазина:
+// платежи других магазинов и платежи вне оформлений не запрашиваются в шлюзе.
+func (h *orderHandler) paymentProviderByPaymentID(c *gin.Context, paymentID string) (PaymentProvider, error) {
+	if paymentID == "" {
+		return nil, errCheckoutWithPaymentIdNotFound
+	}
+
+	checkout, err := h.orderService.GetCheckoutByPaymentID(c.Request.Context(), paymentID, h.getDomain(c))
+	if err != nil {
+		return nil, err
+	}
+	return h.payments.Get(checkout.PaymentProvider)
+}
+
+// Передача ошибки в errorMiddleware, который формирует ответ
+func (h *orderHandler) errorResponse(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
 }
 
 // Получение domain их контекста "domain"
@@ -748,6 +1491,23 @@ func (h *orderHandler) getDomain(c *gin.Context) string {
 	return ""
 }
 
+// Определение тенанта запроса и проверка по реестру (без обращения к БД).
+// В устаревших маршрутах домен передаётся параметром пути :domain.
+func (h *orderHandler) resolveTenant(c *gin.Context) (string, bool) {
+	var id tenant.ID
+	var err error
+	if domain := c.Param("domain"); domain != "" {
+		id, err = tenant.Parse(domain)
+	} else {
+		id, err = h.resolver.Resolve(c.Request)
+	}
+
+	if err != nil || !h.tenants.Has(id) {
+		return "", false
+	}
+	return id.String(), true
+}
+
 // Получение id пользователя из контекста "userId"
 func (h *orderHandler) getUserId(c *gin.Context) (uint, error) {
 	userId, exists := c.Get("userId")
@@ -767,21 +1527,10 @@ func (h *orderHandler) authWithRoleMiddleware(role []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		h.log.Debug("handle authWithRoleMiddleware")
 
-		host := c.Request.Host
-
-		var shopDomain string
-		if strings.Contains(host, ".") {
-			arr := strings.Split(host, ".")
-			if len(arr) == 2 {
-				shopDomain = strings.Split(host, ".")[0]
-			} else {
-				h.log.Debug("authWithRoleMiddleware: host split error - len > 2")
-				c.AbortWithStatus(http.StatusNotFound)
-				return
-			}
-		} else {
-			h.log.Debug("authWithRoleMiddleware: domain is not defined (not contains `.`)")
-			c.AbortWithStatus(http.StatusNotFound)
+		shopDomain, ok := h.resolveTenant(c)
+		if !ok {
+			h.log.Debug("authWithRoleMiddleware: unknown tenant")
+			h.errorResponse(c, apiErrTenantNotFound)
 			return
 		}
 
@@ -789,14 +1538,14 @@ func (h *orderHandler) authWithRoleMiddleware(role []string) gin.HandlerFunc {
 
 		if tokenString == "" {
 			h.log.Debug("authWithRoleMiddleware: authorization token not found")
-			c.AbortWithStatus(http.StatusUnauthorized)
+			h.errorResponse(c, apiErrUnauthorized)
 			return
 		}
 
-		code, userId, err := h.authadapter.Auth(role, tokenString, shopDomain)
+		code, userId, err := h.authenticator.Auth(c.Request.Context(), role, tokenString, shopDomain)
 		if err != nil {
 			h.log.Debugf("authWithRoleMiddleware: auth in authservice error - %v", err)
-			h.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+			h.errorResponse(c, err)
 			return
 		}
 
@@ -807,73 +1556,126 @@ func (h *orderHandler) authWithRoleMiddleware(role []string) gin.HandlerFunc {
 			c.Next()
 		case 403:
 			h.log.Debugf("authWithRoleMiddleware: auth in authservice with code - %d", 403)
-			c.AbortWithStatus(http.StatusForbidden)
+			h.errorResponse(c, apiErrForbidden)
 			return
 		case 401:
 			h.log.Debugf("authWithRoleMiddleware: auth in authservice with code - %d", 401)
-			c.AbortWithStatus(http.StatusUnauthorized)
+			h.errorResponse(c, apiErrUnauthorized)
 			return
 		case 404:
 			h.log.Debugf("authWithRoleMiddleware: auth in authservice with code - %d", 404)
-			c.AbortWithStatus(http.StatusNotFound)
+			h.errorResponse(c, apiErrTenantNotFound)
 			return
 		default:
 			h.log.Debugf("authWithRoleMiddleware: auth in authservice with unexpected code - %d, err - %v", code, err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			h.errorResponse(c, apiErrInternal)
 			return
 		}
 	}
 }
 
-// Проверка домена (query) + Авторизация и аутентификация (jwt)
-// func (h *orderHandler) authWithRoleMiddlewareSystem(role []string) gin.HandlerFunc {
-// 	return func(c *gin.Context) {
-// 		h.log.Debug("handle authWithRoleMiddlewareSystem")
-
-// 		domain := c.Query("domain")
-// 		if domain == "" {
-// 			h.log.Errorf("authWithRoleMiddlewareSystem: missing query parameter (domain)")
-// 			h.newErrorResponse(c, http.StatusBadRequest, "missing query parameter (domain)")
-// 			return
-// 		}
-
-// 		tokenString := c.Request.Header.Get("Authorization")
-
-// 		if tokenString == "" {
-// 			h.log.Debug("authWithRoleMiddlewareSystem: authorization token not found")
-// 			c.AbortWithStatus(http.StatusUnauthorized)
-// 			return
-// 		}
-
-// 		code, userId, err := h.authadapter.Auth(role, tokenString, domain)
-// 		if err != nil {
-// 			h.log.Debugf("authWithRoleMiddlewareSystem: auth in authservice error - %v", err)
-// 			h.newErrorResponse(c, http.StatusInternalServerError, err.Error())
-// 			return
-// 		}
-
-// 		switch code {
-// 		case 200:
-// 			c.Set("domain", domain)
-// 			c.Set("userId", userId)
-// 			c.Next()
-// 		case 403:
-// 			h.log.Debugf("authWithRoleMiddlewareSystem: auth in authservice with code - %d", 403)
-// 			c.AbortWithStatus(http.StatusForbidden)
-// 			return
-// 		case 401:
-// 			h.log.Debugf("authWithRoleMiddlewareSystem: auth in authservice with code - %d", 401)
-// 			c.AbortWithStatus(http.StatusUnauthorized)
-// 			return
-// 		case 404:
-// 			h.log.Debugf("authWithRoleMiddlewareSystem: auth in authservice with code - %d", 404)
-// 			c.AbortWithStatus(http.StatusNotFound)
-// 			return
-// 		default:
-// 			h.log.Debugf("authWithRoleMiddlewareSystem: auth in authservice with unexpected code - %d, err - %v", code, err)
-// 			h.log.Errorf("fatal unexpected auth result with code - %v", code)
-// 			c.AbortWithStatus(http.StatusInternalServerError)
-// 			return
-// 		}
-// 	}
-// }
+// Авторизация системных запросов (jwt) в домене public
+func (h *orderHandler) authWithRoleMiddlewareSystem(role []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h.log.Debug("handle authWithRoleMiddlewareSystem")
+
+		tokenString := c.Request.Header.Get("Authorization")
+
+		if tokenString == "" {
+			h.log.Debug("authWithRoleMiddlewareSystem: authorization token not found")
+			h.errorResponse(c, apiErrUnauthorized)
+			return
+		}
+
+		code, userId, err := h.authenticator.Auth(c.Request.Context(), role, tokenString, "public")
+		if err != nil {
+			h.log.Debugf("authWithRoleMiddlewareSystem: auth in authservice error - %v", err)
+			h.errorResponse(c, err)
+			return
+		}
+
+		switch code {
+		case 200:
+			c.Set("userId", userId)
+			c.Next()
+		case 403:
+			h.log.Debugf("authWithRoleMiddlewareSystem: auth in authservice with code - %d", 403)
+			h.errorResponse(c, apiErrForbidden)
+			return
+		case 401:
+			h.log.Debugf("authWithRoleMiddlewareSystem: auth in authservice with code - %d", 401)
+			h.errorResponse(c, apiErrUnauthorized)
+			return
+		case 404:
+			h.log.Debugf("authWithRoleMiddlewareSystem: auth in authservice with code - %d", 404)
+			h.errorResponse(c, apiErrTenantNotFound)
+			return
+		default:
+			h.log.Debugf("authWithRoleMiddlewareSystem: auth in authservice with unexpected code - %d, err - %v", code, err)
+			h.log.Errorf("fatal unexpected auth result with code - %v", code)
+			h.errorResponse(c, apiErrInternal)
+			return
+		}
+	}
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// Идентификатор запроса: из заголовка X-Request-ID (если корректен) или новый.
+// Возвращается в заголовке ответа и в теле ошибок.
+func (h *orderHandler) requestIDMiddleware(c *gin.Context) {
+	requestID := c.GetHeader(requestIDHeader)
+	if !validRequestID(requestID) {
+		requestID = uuid.New().String()
+	}
+
+	c.Set("requestId", requestID)
+	c.Header(requestIDHeader, requestID)
+	c.Next()
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+type errorResponse struct {
+	Code      ErrorCode   `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id"`
+}
+
+// Формирование ответа по последней ошибке запроса (h.errorResponse).
+// Клиенту передаются только код и сообщение из каталога, исходная ошибка пишется в лог.
+func (h *orderHandler) errorMiddleware(c *gin.Context) {
+	c.Next()
+
+	if len(c.Errors) == 0 || c.Writer.Written() {
+		return
+	}
+
+	err := c.Errors.Last().Err
+	apiErr := toAPIError(err)
+	requestID := c.GetString("requestId")
+
+	if apiErr.HTTPCode >= http.StatusInternalServerError {
+		h.log.Errorf("%s %s (request id - %s) err - %v", c.Request.Method, c.Request.URL.Path, requestID, err)
+	} else {
+		h.log.Debugf("%s %s (request id - %s) err - %v", c.Request.Method, c.Request.URL.Path, requestID, err)
+	}
+
+	c.JSON(apiErr.HTTPCode, &errorResponse{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		Details:   apiErr.Details,
+		RequestID: requestID,
+	})
+}
@@ -0,0 +1,301 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/tenant"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Аутентификация по токену, совпадающему с ролью пользователя
+type roleAuthenticator struct{}
+
+func (roleAuthenticator) Auth(ctx context.Context, role []string, clientToken, domain string) (int, uint, error) {
+	if !hasAnyRole([]string{clientToken}, role) {
+		return http.StatusForbidden, 0, nil
+	}
+	return http.StatusOK, 1, nil
+}
+
+// Сервис заказов с оформлениями по id платежа
+type fakePaymentLookupService struct {
+	OrderService
+	checkouts map[string]*Checkout
+}
+
+func (f *fakePaymentLookupService) GetCheckoutByPaymentID(ctx context.Context, paymentID string, schema string) (*Checkout, error) {
+	checkout, ok := f.checkouts[paymentID]
+	if !ok {
+		return nil, errCheckoutWithPaymentIdNotFound
+	}
+	return checkout, nil
+}
+
+// Роутер магазина shop1 с имитацией шлюза
+func newTestRouter(t *testing.T, service OrderService, provider PaymentProvider) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	log := logrus.NewEntry(logger)
+
+	payments, err := NewPaymentProviders(FakeProvider, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenants := tenant.NewRegistry(func() ([]string, error) { return nil, nil }, 0, log)
+	tenants.Add("shop1")
+
+	resolver, err := tenant.NewResolver(tenant.ResolverConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	NewHandler(service, nil, tenants, resolver, log, roleAuthenticator{}, payments, "").Register(router)
+	return router
+}
+
+func TestPaymentRoutesAccess(t *testing.T) {
+	provider := NewFakeProvider()
+	own, err := provider.CreatePayment(context.Background(), CreatePayment{Amount: Amount{Value: "100.00", Currency: "RUB"}}, "own")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Платёж другого магазина в том же шлюзе
+	foreign, err := provider.CreatePayment(context.Background(), CreatePayment{Amount: Amount{Value: "100.00", Currency: "RUB"}}, "foreign")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &fakePaymentLookupService{checkouts: map[string]*Checkout{
+		own.ID: {PaymentID: own.ID, PaymentProvider: FakeProvider},
+	}}
+	router := newTestRouter(t, service, provider)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		role    string
+		body    string
+		code    int
+		errCode ErrorCode
+	}{
+		{name: "client payment", method: http.MethodGet, path: "/payment", role: clientRole, body: `{"paymentId": "` + own.ID + `"}`, code: http.StatusForbidden, errCode: ErrCodeForbidden},
+		{name: "client refund", method: http.MethodPost, path: "/payment/refund", role: clientRole, body: `{"paymentId": "` + own.ID + `"}`, code: http.StatusForbidden, errCode: ErrCodeForbidden},
+		{name: "client get refund", method: http.MethodGet, path: "/payment/refund", role: clientRole, body: `{"refundId": "1"}`, code: http.StatusForbidden, errCode: ErrCodeForbidden},
+		{name: "admin own payment", method: http.MethodGet, path: "/payment", role: adminRole, body: `{"paymentId": "` + own.ID + `"}`, code: http.StatusOK},
+		{name: "admin foreign payment", method: http.MethodGet, path: "/payment", role: adminRole, body: `{"paymentId": "` + foreign.ID + `"}`, code: http.StatusNotFound, errCode: ErrCodeCheckoutNotFound},
+		{name: "admin foreign refund", method: http.MethodPost, path: "/payment/refund", role: adminRole, body: `{"paymentId": "` + foreign.ID + `"}`, code: http.StatusNotFound, errCode: ErrCodeCheckoutNotFound},
+		{name: "admin without payment id", method: http.MethodGet, path: "/payment", role: adminRole, body: `{}`, code: http.StatusNotFound, errCode: ErrCodeCheckoutNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			r.Host = "shop1.localhost"
+			r.Header.Set("Authorization", tt.role)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("code = %d, want %d (body - %s)", w.Code, tt.code, w.Body.String())
+			}
+			if tt.errCode == "" {
+				return
+			}
+
+			var resp errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.errCode {
+				t.Errorf("error code = %s, want %s", resp.Code, tt.errCode)
+			}
+		})
+	}
+
+	// Отказ в доступе не обращается к шлюзу: возврат по чужому платежу не создан
+	if len(provider.refunds) != 0 {
+		t.Errorf("refunds = %d, want 0", len(provider.refunds))
+	}
+}
+
+// Сервис заказов с одним оформлением для повторной оплаты
+type fakePayOrderService struct {
+	OrderService
+	checkout  *Checkout
+	renewErr  error
+	renewals  []string // причины замены платежа
+	paymentID string   // платёж, сохранённый в оформлении
+	paid      int
+}
+
+func (f *fakePayOrderService) GetOrderByID(ctx context.Context, userId, orderId uint, schema string) (*Order, error) {
+	return &Order{Model: f.checkout.Orders[0].Model, DeliveryAddress: "Москва"}, nil
+}
+
+func (f *fakePayOrderService) GetCheckoutByOrderID(ctx context.Context, orderID uint, schema string) (*Checkout, error) {
+	return f.checkout, nil
+}
+
+func (f *fakePayOrderService) RenewCheckoutPayment(ctx context.Context, checkoutID uint, previousPaymentID, reason, paymentKey string, schema string) error {
+	if f.renewErr != nil {
+		return f.renewErr
+	}
+	f.renewals = append(f.renewals, reason)
+	return nil
+}
+
+func (f *fakePayOrderService) UpdateCheckoutPaymentID(ctx context.Context, checkoutID uint, paymentID string, schema string) error {
+	f.paymentID = paymentID
+	return nil
+}
+
+func (f *fakePayOrderService) PaymentSuccess(ctx context.Context, checkoutID uint, schema string) error {
+	f.paid++
+	return nil
+}
+
+func TestPayOrder(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name       string
+		status     string     // статус оплаты оформления
+		method     string     // способ оплаты оформления
+		gateway    string     // статус текущего платежа в шлюзе; пусто - платёж не найден
+		expiresAt  *time.Time // срок текущего платежа
+		failCancel bool       // шлюз не отменяет платёж
+		renewErr   error      // ошибка замены платежа
+		code       int        // код ответа
+		errCode    ErrorCode  // код ошибки
+		renewed    bool       // создан новый платёж
+		reason     string     // причина замены платежа
+		paid       int        // оформление переведено в оплаченные
+	}{
+		{name: "pending payment link", status: WaitingProcessingPayment, gateway: "pending", code: http.StatusOK},
+		{name: "expired payment", status: WaitingProcessingPayment, gateway: "pending", expiresAt: &expired, code: http.StatusOK, renewed: true, reason: ExpiredPaymentAttempt},
+		{name: "expired payment not canceled", status: WaitingProcessingPayment, gateway: "pending", expiresAt: &expired, failCancel: true, code: http.StatusServiceUnavailable, errCode: ErrCodePaymentUnavailable},
+		{name: "payment not found", status: WaitingProcessingPayment, code: http.StatusOK, renewed: true, reason: NotFoundPaymentAttempt},
+		{name: "canceled checkout", status: CanceledPayment, gateway: "canceled", code: http.StatusOK, renewed: true, reason: CanceledPaymentAttempt},
+		{name: "paid in gateway", status: WaitingProcessingPayment, gateway: "succeeded", code: http.StatusConflict, errCode: ErrCodeOrderAlreadyPaid, paid: 1},
+		{name: "paid checkout", status: PaidPayment, gateway: "succeeded", code: http.StatusConflict, errCode: ErrCodeOrderAlreadyPaid},
+		{name: "pay on delivery", status: PayOnDeliveryPayment, method: CashOnDeliveryPaymentMethod, code: http.StatusConflict, errCode: ErrCodeOrderNotPayable},
+		{name: "renewal in progress", status: CanceledPayment, gateway: "canceled", renewErr: errPaymentRenewConflict, code: http.StatusConflict, errCode: ErrCodePaymentConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewFakeProvider()
+			returnUrl := "https://pay.example/confirm"
+			current, err := provider.CreatePayment(context.Background(), CreatePayment{
+				Amount:       Amount{Value: "100.00", Currency: "RUB"},
+				Confirmation: &Confirmation{Type: "redirect", ReturnUrl: &returnUrl},
+			}, "current")
+			if err != nil {
+				t.Fatal(err)
+			}
+			paymentID := current.ID
+			switch tt.gateway {
+			case "":
+				paymentID = "unknown"
+			case "succeeded":
+				provider.capture(provider.payments[current.ID])
+			default:
+				provider.payments[current.ID].Status = tt.gateway
+				provider.payments[current.ID].ExpiresAt = tt.expiresAt
+			}
+
+			method := tt.method
+			if method == "" {
+				method = OnlinePaymentMethod
+			}
+			service := &fakePayOrderService{
+				checkout: &Checkout{
+					Model:           gorm.Model{ID: 5},
+					PaymentID:       paymentID,
+					PaymentProvider: FakeProvider,
+					PaymentMethod:   method,
+					PaymentStatus:   PaymentStatus{Code: tt.status},
+					TotalPrice:      100,
+					Orders:          []Order{{Model: gorm.Model{ID: 1}}},
+				},
+				renewErr: tt.renewErr,
+			}
+
+			var gateway PaymentProvider = provider
+			if tt.failCancel {
+				gateway = &cancelFailingProvider{fakeProvider: provider}
+			}
+			router := newTestRouter(t, service, gateway)
+
+			r := httptest.NewRequest(http.MethodPost, "/order/1/pay", nil)
+			r.Host = "shop1.localhost"
+			r.Header.Set("Authorization", clientRole)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("code = %d, want %d (body - %s)", w.Code, tt.code, w.Body.String())
+			}
+
+			var resp struct {
+				errorResponse
+				URL       *string `json:"url"`
+				PaymentID string  `json:"payment_id"`
+				Renewed   bool    `json:"renewed"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.errCode {
+				t.Errorf("error code = %s, want %s", resp.Code, tt.errCode)
+			}
+			if service.paid != tt.paid {
+				t.Errorf("paid = %d, want %d", service.paid, tt.paid)
+			}
+
+			if tt.code != http.StatusOK {
+				if len(service.renewals) != 0 || service.paymentID != "" {
+					t.Errorf("payment renewed on error: reasons %v, payment %q", service.renewals, service.paymentID)
+				}
+				return
+			}
+
+			if resp.Renewed != tt.renewed || resp.URL == nil {
+				t.Errorf("renewed = %v, url = %v, want %v with url", resp.Renewed, resp.URL, tt.renewed)
+			}
+			if !tt.renewed {
+				if resp.PaymentID != current.ID || *resp.URL != returnUrl || len(service.renewals) != 0 {
+					t.Errorf("payment id = %s, renewals = %v, want current payment %s", resp.PaymentID, service.renewals, current.ID)
+				}
+				return
+			}
+
+			if len(service.renewals) != 1 || service.renewals[0] != tt.reason {
+				t.Errorf("renewal reasons = %v, want [%s]", service.renewals, tt.reason)
+			}
+			if resp.PaymentID == current.ID || service.paymentID != resp.PaymentID {
+				t.Errorf("new payment = %s, saved = %s, previous = %s", resp.PaymentID, service.paymentID, current.ID)
+			}
+			// Прежний платёж отменён в шлюзе до создания нового
+			if previous := provider.payments[current.ID]; tt.gateway == "pending" && previous.Status != "canceled" {
+				t.Errorf("previous payment status = %s, want canceled", previous.Status)
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mserebryaakov/aggregator-order-service/config"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/postgres"
+	"github.com/sirupsen/logrus"
+	gormpostgres "gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// Запросы, собранные в режиме DryRun
+type sqlRecorder struct {
+	logger.Interface
+	queries []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	query, _ := fc()
+	r.queries = append(r.queries, query)
+}
+
+var tableReference = regexp.MustCompile(`(?i)\b(?:FROM|JOIN|UPDATE|INTO)\s+("[^"]+"(?:\."[^"]+")?)`)
+
+// Хэндл тенанта без подключения к БД: запросы только собираются
+func dryRunTenantDB(t *testing.T, schemaName string) (*gorm.DB, *sqlRecorder) {
+	recorder := &sqlRecorder{Interface: logger.Discard}
+
+	db, err := gorm.Open(gormpostgres.New(gormpostgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		NamingStrategy:         schema.NamingStrategy{TablePrefix: schemaName + "."},
+		Logger:                 recorder,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, recorder
+}
+
+// Все таблицы в запросах, включая подзапросы и блокировки, адресуются в схему тенанта
+func TestTenantQueriesUseSchemaPrefix(t *testing.T) {
+	db, recorder := dryRunTenantDB(t, "tenant_a")
+
+	order := &Order{
+		Model:       gorm.Model{ID: 1},
+		AddressesID: 2,
+		Products:    []Products{{Model: gorm.Model{ID: 3}}},
+	}
+	if err := reserveStock(db, order); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := finishStockReservations(db, 1, CommittedStockReservation); err != nil {
+		t.Fatal(err)
+	}
+
+	err := db.Model(&Checkout{}).Where("id = ?", 1).Update("payment_status_id", paymentStatusID(db, PaidPayment)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Model(&Order{}).
+		Where("checkout_id = ? AND delivery_status_id = ?", 1, deliveryStatusID(db, WaitingProcessingDelivery)).
+		Update("delivery_status_id", deliveryStatusID(db, WaitingProcessing)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// В DryRun строка не читается, лимит на пользователя включает подсчёт использований
+	promo := PromoCode{PerUserLimit: 1}
+	if err := lockPromoCode(db, &PromoRedemption{PromoCodeID: 1, UserID: 1}, &promo); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.queries) == 0 {
+		t.Fatal("no queries recorded")
+	}
+
+	for _, query := range recorder.queries {
+		refs := tableReference.FindAllStringSubmatch(query, -1)
+		if len(refs) == 0 {
+			t.Errorf("no table references in %q", query)
+		}
+		for _, ref := range refs {
+			if !strings.HasPrefix(ref[1], `"tenant_a".`) {
+				t.Errorf("table %s outside tenant schema in %q", ref[1], query)
+			}
+		}
+	}
+}
+
+// Пул подключений к реальной БД для интеграционных тестов (параметры подключения - POSTGRES_*).
+// Тест пропускается, если не задана ORDER_INTEGRATION_TESTS.
+func integrationConnectionPool(t *testing.T) (*postgres.SchemaConnectionPool, *logrus.Entry) {
+	if os.Getenv("ORDER_INTEGRATION_TESTS") == "" {
+		t.Skip("ORDER_INTEGRATION_TESTS is not set")
+	}
+
+	env, err := config.GetPostgresEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := logrus.NewEntry(logrus.New())
+	scp := postgres.NewSchemaConnectionPool(postgres.Config{
+		Host:     env.PgHost,
+		Port:     env.PgPort,
+		Username: env.PgUser,
+		Password: env.PgPassword,
+		DBName:   env.PgDbName,
+		SSLMode:  env.SSLMode,
+		TimeZone: env.TimeZone,
+	}, log)
+	t.Cleanup(func() { scp.Close() })
+
+	return scp, log
+}
+
+// Интеграционная проверка на реальной БД (параметры подключения - POSTGRES_*):
+// данные, записанные через хэндл одного тенанта, не видны через хэндл другого
+func TestTenantIsolation(t *testing.T) {
+	scp, log := integrationConnectionPool(t)
+
+	migrator := postgres.NewMigrator(scp, Migrations, log)
+	storage := NewStorage(scp, 0)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	schemaA := fmt.Sprintf("isolation_a_%d", suffix)
+	schemaB := fmt.Sprintf("isolation_b_%d", suffix)
+
+	for _, schemaName := range []string{schemaA, schemaB} {
+		if err := migrator.CreateSchema(schemaName); err != nil {
+			t.Fatal(err)
+		}
+		schemaName := schemaName
+		t.Cleanup(func() {
+			if err := migrator.DropSchema(schemaName); err != nil {
+				t.Errorf("DropSchema %s: %v", schemaName, err)
+			}
+		})
+
+		if _, err := migrator.Migrate(schemaName); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dbA, err := scp.GetConnectionPool(schemaA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbB, err := scp.GetConnectionPool(schemaB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Лишний статус сдвигает id справочника B: подзапросы статусов, ушедшие в чужую схему, дадут другой id
+	if err := dbB.Create(&PaymentStatus{Code: "isolation", Name: "isolation"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, schemaName := range []string{schemaA, schemaB} {
+		if err := storage.EnsureStatuses(ctx, schemaName); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seed := func(db *gorm.DB, quantity uint) (Addresses, Products, PromoCode) {
+		address := Addresses{City: "Москва"}
+		if err := db.Create(&address).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		product := Products{Name: "Товар", Price: 100, AddressesID: &address.ID}
+		if err := db.Create(&product).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Create(&Stock{ProductsID: product.ID, Quantity: quantity}).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		promo := PromoCode{Code: "SALE", Type: FixedPromoCode, Value: 10, UsageLimit: 1}
+		if err := db.Create(&promo).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		return address, product, promo
+	}
+
+	addressA, productA, promoA := seed(dbA, 5)
+	addressB, productB, promoB := seed(dbB, 1)
+
+	// Одинаковые id в обеих схемах: запрос к чужой схеме затронул бы строку с тем же id
+	if productA.ID != productB.ID || promoA.ID != promoB.ID {
+		t.Fatalf("expected equal ids in fresh schemas: products %d/%d, promo codes %d/%d", productA.ID, productB.ID, promoA.ID, promoB.ID)
+	}
+
+	checkout := func(address Addresses, products []Products, promo PromoCode, schemaName string) uint {
+		id, err := storage.CreateCheckout(ctx, &Checkout{
+			UserID:        1,
+			TotalPrice:    100,
+			PaymentMethod: OnlinePaymentMethod,
+			Orders: []Order{{
+				UserID:      1,
+				AddressesID: int(address.ID),
+				Products:    products,
+				TotalPrice:  100,
+			}},
+		}, &PromoRedemption{PromoCodeID: promo.ID, UserID: 1, Amount: 10}, schemaName)
+		if err != nil {
+			t.Fatalf("CreateCheckout in %s: %v", schemaName, err)
+		}
+		return id
+	}
+
+	stock := func(productID uint, schemaName string) Stock {
+		stocks, err := storage.GetStocksByProductIDs(ctx, []uint{productID}, schemaName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stocks) != 1 {
+			t.Fatalf("stocks in %s = %d, want 1", schemaName, len(stocks))
+		}
+		return stocks[0]
+	}
+
+	checkoutA := checkout(addressA, []Products{productA, productA}, promoA, schemaA)
+
+	// Резерв и блокировка промокода в A не затрагивают B
+	if s := stock(productB.ID, schemaB); s.Quantity != 1 || s.Reserved != 0 {
+		t.Errorf("stock B = %d/%d, want 1/0", s.Quantity, s.Reserved)
+	}
+	if s := stock(productA.ID, schemaA); s.Quantity != 3 || s.Reserved != 2 {
+		t.Errorf("stock A = %d/%d, want 3/2", s.Quantity, s.Reserved)
+	}
+
+	used, err := storage.CountPromoRedemptions(ctx, promoB.ID, 1, schemaB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 0 {
+		t.Errorf("promo redemptions in B = %d, want 0", used)
+	}
+
+	orders, err := storage.GetOrdersByUserID(ctx, 1, schemaB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 0 {
+		t.Errorf("orders in B = %d, want 0", len(orders))
+	}
+
+	expired, err := storage.GetExpiredUnpaidCheckouts(ctx, time.Now().Add(time.Hour), schemaB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Errorf("expired checkouts in B = %d, want 0", len(expired))
+	}
+
+	// Промокод с исчерпанным лимитом в A по-прежнему доступен в B
+	checkoutB := checkout(addressB, []Products{productB}, promoB, schemaB)
+	if checkoutA != checkoutB {
+		t.Fatalf("expected equal checkout ids: %d/%d", checkoutA, checkoutB)
+	}
+
+	if err := storage.PaymentSuccess(ctx, checkoutA, schemaA); err != nil {
+		t.Fatal(err)
+	}
+	// Повторное уведомление об оплате ничего не меняет
+	if err := storage.PaymentSuccess(ctx, checkoutA, schemaA); err != nil {
+		t.Fatalf("repeated PaymentSuccess: %v", err)
+	}
+
+	paymentCode := func(schemaName string) string {
+		orders, err := storage.GetOrdersByUserID(ctx, 1, schemaName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(orders) != 1 {
+			t.Fatalf("orders in %s = %d, want 1", schemaName, len(orders))
+		}
+
+		checkout, err := storage.GetCheckoutByOrderID(ctx, orders[0].ID, schemaName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checkout == nil {
+			t.Fatalf("checkout of order %d not found in %s", orders[0].ID, schemaName)
+		}
+		return checkout.PaymentStatus.Code
+	}
+
+	if code := paymentCode(schemaA); code != PaidPayment {
+		t.Errorf("payment status in A = %s, want %s", code, PaidPayment)
+	}
+	if code := paymentCode(schemaB); code != WaitingProcessingPayment {
+		t.Errorf("payment status in B = %s, want %s", code, WaitingProcessingPayment)
+	}
+
+	if s := stock(productB.ID, schemaB); s.Quantity != 0 || s.Reserved != 1 {
+		t.Errorf("stock B = %d/%d, want 0/1", s.Quantity, s.Reserved)
+	}
+
+	for schemaName, want := range map[string]int{schemaA: 0, schemaB: 1} {
+		expired, err := storage.GetExpiredUnpaidCheckouts(ctx, time.Now().Add(time.Hour), schemaName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(expired) != want {
+			t.Errorf("expired checkouts in %s = %d, want %d", schemaName, len(expired), want)
+		}
+	}
+
+	// Оплата отменённого оформления не меняет статус и не списывает снятый резерв
+	if err := storage.PaymentCanceled(ctx, checkoutB, schemaB); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.PaymentSuccess(ctx, checkoutB, schemaB); !errors.Is(err, errPaymentStatusConflict) {
+		t.Errorf("PaymentSuccess of canceled checkout err = %v, want %v", err, errPaymentStatusConflict)
+	}
+	if code := paymentCode(schemaB); code != CanceledPayment {
+		t.Errorf("payment status in B = %s, want %s", code, CanceledPayment)
+	}
+	if s := stock(productB.ID, schemaB); s.Quantity != 1 || s.Reserved != 0 {
+		t.Errorf("stock B = %d/%d, want 1/0", s.Quantity, s.Reserved)
+	}
+}
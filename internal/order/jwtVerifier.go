@@ -0,0 +1,384 @@
+package order
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mserebryaakov/aggregator-order-service/pkg/httpclient"
+	"github.com/sirupsen/logrus"
+)
+
+type JWTVerifierLogHook struct{}
+
+func (h *JWTVerifierLogHook) Fire(entry *logrus.Entry) error {
+	entry.Message = "JWTVerifier: " + entry.Message
+	return nil
+}
+
+func (h *JWTVerifierLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+const (
+	defaultJWKSRefreshInterval = 10 * time.Minute
+	// Внеплановая загрузка ключей при неизвестном kid не чаще одного раза за интервал
+	jwksMinRefreshInterval = 30 * time.Second
+)
+
+var (
+	errJWTMalformed        = errors.New("malformed token")
+	errJWTUnsupportedAlg   = errors.New("unsupported token algorithm")
+	errJWTUnknownKey       = errors.New("unknown token key")
+	errJWTInvalidSignature = errors.New("invalid token signature")
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwtClaims struct {
+	Exp    int64           `json:"exp"`
+	Nbf    int64           `json:"nbf"`
+	Sub    string          `json:"sub"`
+	UserID json.Number     `json:"userId"`
+	Domain string          `json:"domain"`
+	Roles  []string        `json:"roles"`
+	Role   json.RawMessage `json:"role"`
+}
+
+// Локальная проверка токенов клиентов по ключам, опубликованным сервисом авторизации (JWKS).
+// Поддерживаются RS256 и ES256; ключи кэшируются и перечитываются периодически
+// и при появлении неизвестного kid (ротация ключей).
+type jwtVerifier struct {
+	sync.RWMutex
+	keys            map[string]crypto.PublicKey
+	fetchedAt       time.Time
+	jwksURL         string
+	refreshInterval time.Duration
+	client          *httpclient.Client
+	timeout         time.Duration
+	log             *logrus.Entry
+
+	fetchMu sync.Mutex
+}
+
+// client - клиент сервиса авторизации (повторы и автомат отключения общие с проверкой токенов)
+func NewJWTVerifier(log *logrus.Entry, jwksURL string, client *httpclient.Client, refreshInterval, timeout time.Duration) *jwtVerifier {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultAdapterTimeout
+	}
+
+	return &jwtVerifier{
+		keys:            make(map[string]crypto.PublicKey),
+		jwksURL:         jwksURL,
+		refreshInterval: refreshInterval,
+		client:          client,
+		timeout:         timeout,
+		log:             log,
+	}
+}
+
+func (v *jwtVerifier) Auth(ctx context.Context, role []string, clientToken, domain string) (int, uint, error) {
+	claims, err := v.verify(ctx, strings.TrimPrefix(clientToken, "Bearer "))
+	if err != nil {
+		if errors.Is(err, errJWTMalformed) || errors.Is(err, errJWTUnsupportedAlg) ||
+			errors.Is(err, errJWTUnknownKey) || errors.Is(err, errJWTInvalidSignature) {
+			v.log.Debugf("Auth: token rejected - %v", err)
+			return http.StatusUnauthorized, 0, nil
+		}
+		return 0, 0, NewError(ServerAppError, "failed verify token", upstreamStatus(err), err)
+	}
+
+	// Токен без срока действия не принимается
+	now := time.Now().Unix()
+	if claims.Exp <= 0 || now >= claims.Exp || claims.Nbf != 0 && now < claims.Nbf {
+		return http.StatusUnauthorized, 0, nil
+	}
+
+	if claims.Domain != domain {
+		return http.StatusUnauthorized, 0, nil
+	}
+
+	userID, err := claims.userID()
+	if err != nil {
+		v.log.Debugf("Auth: user id claim - %v", err)
+		return http.StatusUnauthorized, 0, nil
+	}
+
+	if !hasAnyRole(claims.roles(), role) {
+		return http.StatusForbidden, 0, nil
+	}
+
+	return http.StatusOK, userID, nil
+}
+
+func (c *jwtClaims) userID() (uint, error) {
+	raw := c.UserID.String()
+	if raw == "" {
+		raw = c.Sub
+	}
+
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("incorrect user id - %q", raw)
+	}
+	return uint(id), nil
+}
+
+// Роли из claim roles (массив) или role (строка или массив)
+func (c *jwtClaims) roles() []string {
+	roles := append([]string{}, c.Roles...)
+	if len(c.Role) == 0 {
+		return roles
+	}
+
+	var single string
+	if err := json.Unmarshal(c.Role, &single); err == nil {
+		return append(roles, single)
+	}
+
+	var many []string
+	if err := json.Unmarshal(c.Role, &many); err == nil {
+		roles = append(roles, many...)
+	}
+	return roles
+}
+
+func hasAnyRole(have, want []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *jwtVerifier) verify(ctx context.Context, token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errJWTMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errJWTMalformed
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	switch header.Alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errJWTUnsupportedAlg
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, errJWTInvalidSignature
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return nil, errJWTUnsupportedAlg
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return nil, errJWTInvalidSignature
+		}
+	default:
+		return nil, errJWTUnsupportedAlg
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errJWTMalformed
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return errJWTMalformed
+	}
+	return nil
+}
+
+// Ключ по kid; при устаревшем кэше или неизвестном kid ключи загружаются заново
+func (v *jwtVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.RLock()
+	key, ok := v.keys[kid]
+	fetchedAt := v.fetchedAt
+	v.RUnlock()
+
+	stale := time.Since(fetchedAt) > v.refreshInterval
+	if ok && !stale {
+		return key, nil
+	}
+
+	if !stale && time.Since(fetchedAt) < jwksMinRefreshInterval {
+		return nil, errJWTUnknownKey
+	}
+
+	if err := v.fetch(ctx, fetchedAt); err != nil {
+		if ok {
+			// Сервис ключей недоступен - используется ранее загруженный ключ
+			v.log.Errorf("key: fetch jwks err - %v", err)
+			return key, nil
+		}
+		return nil, err
+	}
+
+	v.RLock()
+	key, ok = v.keys[kid]
+	v.RUnlock()
+
+	if !ok {
+		return nil, errJWTUnknownKey
+	}
+	return key, nil
+}
+
+// Загрузка ключей; параллельные вызовы выполняют один запрос
+func (v *jwtVerifier) fetch(ctx context.Context, seen time.Time) error {
+	v.fetchMu.Lock()
+	defer v.fetchMu.Unlock()
+
+	v.RLock()
+	fetched := v.fetchedAt.After(seen)
+	v.RUnlock()
+	if fetched {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks responded with code - %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			v.log.Errorf("fetch: skip key %s - %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	v.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.Unlock()
+
+	v.log.Debugf("fetch: loaded %d keys", len(keys))
+
+	return nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve - %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type - %s", k.Kty)
+	}
+}
@@ -0,0 +1,145 @@
+package order
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mserebryaakov/aggregator-order-service/pkg/httpclient"
+	"github.com/sirupsen/logrus"
+)
+
+func signTestJWT(t *testing.T, key *ecdsa.PrivateKey, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := encode(map[string]string{"alg": "ES256", "kid": "test"}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerifierRequiresExpiry(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": {{
+			Kty: "EC",
+			Kid: "test",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	t.Cleanup(server.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	log := logrus.NewEntry(logger)
+
+	verifier := NewJWTVerifier(log, server.URL, httpclient.New(httpclient.Config{Name: "jwks-test"}, log), time.Minute, time.Second)
+
+	tests := []struct {
+		name string
+		exp  interface{}
+		code int
+	}{
+		{name: "valid", exp: time.Now().Add(time.Hour).Unix(), code: http.StatusOK},
+		{name: "expired", exp: time.Now().Add(-time.Minute).Unix(), code: http.StatusUnauthorized},
+		{name: "zero exp", exp: 0, code: http.StatusUnauthorized},
+		{name: "missing exp", code: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := map[string]interface{}{
+				"userId": 7,
+				"domain": "shop",
+				"role":   clientRole,
+			}
+			if tt.exp != nil {
+				claims["exp"] = tt.exp
+			}
+
+			code, _, err := verifier.Auth(context.Background(), []string{clientRole}, signTestJWT(t, key, claims), "shop")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.code {
+				t.Errorf("code = %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
+
+// Недоступный сервис ключей при первой загрузке - ошибка зависимости, а не внутренняя
+func TestJWTVerifierJWKSUnavailable(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	log := logrus.NewEntry(logger)
+
+	client := httpclient.New(httpclient.Config{Name: "jwks-test", FailureThreshold: 1, OpenTimeout: time.Minute}, log)
+	verifier := NewJWTVerifier(log, server.URL, client, time.Minute, time.Second)
+
+	token := signTestJWT(t, key, map[string]interface{}{
+		"userId": 7,
+		"domain": "shop",
+		"role":   clientRole,
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	})
+
+	// Первая ошибка открывает автомат отключения, следующая загрузка ключей отклоняется им
+	if _, _, err := verifier.Auth(context.Background(), []string{clientRole}, token, "shop"); err == nil {
+		t.Fatal("expected error while jwks is unavailable")
+	}
+
+	_, _, err = verifier.Auth(context.Background(), []string{clientRole}, token, "shop")
+	if !errors.Is(err, httpclient.ErrCircuitOpen) {
+		t.Fatalf("err = %v, want %v", err, httpclient.ErrCircuitOpen)
+	}
+	if apiErr := toAPIError(err); apiErr.Code != ErrCodeUpstreamUnavailable {
+		t.Errorf("api error = %s, want %s", apiErr.Code, ErrCodeUpstreamUnavailable)
+	}
+
+	var appErr *AppError
+	if !errors.As(err, &appErr) || appErr.HTTPCode != http.StatusServiceUnavailable {
+		t.Errorf("app error = %v, want code %d", err, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,242 @@
+package order
+
+import (
+	"time"
+
+	"github.com/mserebryaakov/aggregator-order-service/pkg/postgres"
+	"gorm.io/gorm"
+)
+
+// Миграции схемы тенанта. Новые миграции добавляются в конец с увеличением версии,
+// применённые миграции не изменяются. Миграции работают с собственными копиями моделей,
+// чтобы последующие изменения model.go не меняли уже выпущенные версии схемы.
+var Migrations = []postgres.Migration{
+	{
+		Version: 1,
+		Name:    "initial",
+		Up:      migrateInitial,
+	},
+	{
+		Version: 2,
+		Name:    "checkouts_pricing_stock",
+		Up:      migrateCheckouts,
+	},
+	{
+		Version: 3,
+		Name:    "status_code_unique",
+		Up: func(tx *gorm.DB) error {
+			type PaymentStatus struct {
+				gorm.Model
+				Code string `gorm:"uniqueIndex"`
+			}
+
+			type DeliveryStatus struct {
+				gorm.Model
+				Code string `gorm:"uniqueIndex"`
+			}
+
+			// Коды для статусов, созданных до появления справочника кодов
+			for id, code := range legacyDeliveryStatusCodes {
+				err := tx.Model(&DeliveryStatus{}).Where("id = ? AND (code IS NULL OR code = '')", id).Update("code", code).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			for id, code := range legacyPaymentStatusCodes {
+				err := tx.Model(&PaymentStatus{}).Where("id = ? AND (code IS NULL OR code = '')", id).Update("code", code).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			// Статусы раньше создавались с явными id, последовательности нужно сдвинуть
+			for _, table := range []string{"delivery_statuses", "payment_statuses"} {
+				err := tx.Exec("SELECT setval(pg_get_serial_sequence(?, 'id'), (SELECT COALESCE(MAX(id), 0) + 1 FROM "+table+"), false)", table).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			if !tx.Migrator().HasIndex(&DeliveryStatus{}, "Code") {
+				if err := tx.Migrator().CreateIndex(&DeliveryStatus{}, "Code"); err != nil {
+					return err
+				}
+			}
+
+			if !tx.Migrator().HasIndex(&PaymentStatus{}, "Code") {
+				return tx.Migrator().CreateIndex(&PaymentStatus{}, "Code")
+			}
+
+			return nil
+		},
+	},
+	{
+		Version: 4,
+		Name:    "payment_provider",
+		Up: func(tx *gorm.DB) error {
+			type TenantSettings struct {
+				gorm.Model
+				PaymentProvider string
+			}
+
+			type Checkout struct {
+				PaymentProvider string
+			}
+
+			type Order struct {
+				PaymentProvider string
+			}
+
+			if err := tx.AutoMigrate(&TenantSettings{}, &Checkout{}, &Order{}); err != nil {
+				return err
+			}
+
+			// Существующие платежи созданы в собственном платёжном сервисе
+			for _, model := range []interface{}{&Checkout{}, &Order{}} {
+				err := tx.Model(model).Where("payment_provider IS NULL OR payment_provider = ''").
+					Update("payment_provider", PaymentServiceProvider).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		Version: 5,
+		Name:    "two_stage_payments",
+		Up: func(tx *gorm.DB) error {
+			type TenantSettings struct {
+				CaptureMode string
+			}
+
+			type Checkout struct {
+				CaptureMode     string
+				AuthorizedAt    *time.Time
+				AuthorizedUntil *time.Time
+			}
+
+			type Order struct {
+				CaptureMode string
+				AcceptedAt  *time.Time
+			}
+
+			type PaymentStatus struct {
+				gorm.Model
+				Code string
+				Name string
+			}
+
+			if err := tx.AutoMigrate(&TenantSettings{}, &Checkout{}, &Order{}); err != nil {
+				return err
+			}
+
+			// Существующие оформления оплачивались со списанием сразу
+			for _, model := range []interface{}{&Checkout{}, &Order{}} {
+				err := tx.Model(model).Where("capture_mode IS NULL OR capture_mode = ''").
+					Update("capture_mode", AutoCaptureMode).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			var status PaymentStatus
+			return tx.Where(PaymentStatus{Code: AuthorizedPayment}).Attrs(PaymentStatus{Name: "Средства удержаны"}).FirstOrCreate(&status).Error
+		},
+	},
+	{
+		Version: 6,
+		Name:    "payment_method",
+		Up: func(tx *gorm.DB) error {
+			type Checkout struct {
+				PaymentMethod string
+			}
+
+			type Order struct {
+				PaymentMethod   string
+				CollectedAmount *float64
+				CollectedAt     *time.Time
+			}
+
+			type PaymentStatus struct {
+				gorm.Model
+				Code string
+				Name string
+			}
+
+			if err := tx.AutoMigrate(&Checkout{}, &Order{}); err != nil {
+				return err
+			}
+
+			for _, model := range []interface{}{&Checkout{}, &Order{}} {
+				err := tx.Model(model).Where("payment_method IS NULL OR payment_method = ''").
+					Update("payment_method", OnlinePaymentMethod).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			var status PaymentStatus
+			return tx.Where(PaymentStatus{Code: PayOnDeliveryPayment}).Attrs(PaymentStatus{Name: "Оплата при получении"}).FirstOrCreate(&status).Error
+		},
+	},
+	{
+		Version: 7,
+		Name:    "payment_attempts",
+		Up: func(tx *gorm.DB) error {
+			type Checkout struct {
+				gorm.Model
+				PaymentID        string `gorm:"index"`
+				PaymentKey       string `gorm:"index"`
+				PaymentProvider  string
+				PaymentRenewedAt *time.Time
+			}
+
+			type PaymentAttempt struct {
+				gorm.Model
+				CheckoutID      uint     `gorm:"index"`
+				Checkout        Checkout `gorm:"foreignKey:CheckoutID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+				PaymentID       string   `gorm:"index"`
+				PaymentKey      string
+				PaymentProvider string
+				Status          string
+			}
+
+			if err := tx.AutoMigrate(&PaymentAttempt{}, &Checkout{}); err != nil {
+				return err
+			}
+
+			// Платежи существующих оформлений становятся их первыми попытками
+			var checkouts []Checkout
+			return tx.Where("payment_id <> ''").FindInBatches(&checkouts, 500, func(batch *gorm.DB, _ int) error {
+				attempts := make([]PaymentAttempt, 0, len(checkouts))
+				for _, checkout := range checkouts {
+					attempts = append(attempts, PaymentAttempt{
+						CheckoutID:      checkout.ID,
+						PaymentID:       checkout.PaymentID,
+						PaymentKey:      checkout.PaymentKey,
+						PaymentProvider: checkout.PaymentProvider,
+						Status:          ActivePaymentAttempt,
+					})
+				}
+				return tx.Create(&attempts).Error
+			}).Error
+		},
+	},
+}
+
+// Прежние фиксированные id статусов
+var legacyDeliveryStatusCodes = map[uint]string{
+	1: WaitingProcessing,
+	2: ProcessOfDelivery,
+	3: DeliveredDelivery,
+	4: WaitingProcessingDelivery,
+}
+
+var legacyPaymentStatusCodes = map[uint]string{
+	1: WaitingProcessingPayment,
+	2: PaidPayment,
+	3: CanceledPayment,
+}
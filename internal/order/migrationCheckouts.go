@@ -0,0 +1,193 @@
+package order
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Оформления, расчёт стоимости, промокоды и остатки товаров поверх начальной схемы.
+// Модели зафиксированы на момент миграции; AutoMigrate добавляет недостающие таблицы и колонки.
+func migrateCheckouts(tx *gorm.DB) error {
+	type Shop struct {
+		gorm.Model
+		Name        string
+		Description string
+		ContactInfo string
+	}
+
+	type Addresses struct {
+		gorm.Model
+		ShopID      *uint
+		Shop        Shop `gorm:"foreignKey:ShopID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+		Region      string
+		City        string
+		Street      string
+		ContactInfo string
+	}
+
+	type Category struct {
+		gorm.Model
+		ParentCategoryID *uint
+		Name             string
+		Path             string
+	}
+
+	type Products struct {
+		gorm.Model
+		Name        string
+		Description string
+		ImageID     string
+		Price       uint
+		AddressesID *uint
+		Weight      uint
+		CategoryID  *uint
+		Category    Category `gorm:"foreignKey:CategoryID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	}
+
+	type PaymentStatus struct {
+		gorm.Model
+		Code string
+		Name string
+	}
+
+	type DeliveryStatus struct {
+		gorm.Model
+		Code string
+		Name string
+	}
+
+	type DeliveryTariff struct {
+		gorm.Model
+		ShopID        *uint `gorm:"uniqueIndex"`
+		Shop          Shop  `gorm:"foreignKey:ShopID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		Type          string
+		BaseFee       float64
+		PerKmFee      float64
+		PerKgFee      float64
+		FreeThreshold float64
+		TaxPercent    float64
+		TaxIncluded   bool
+	}
+
+	type OrderPriceLine struct {
+		gorm.Model
+		OrderID uint
+		Type    string
+		Name    string
+		Amount  float64
+	}
+
+	type OrderItem struct {
+		gorm.Model
+		OrderID    uint `gorm:"index"`
+		ProductsID uint
+		Name       string
+		Price      uint
+	}
+
+	type Order struct {
+		gorm.Model
+		UserID           uint
+		Products         []Products `gorm:"many2many:order_products;"`
+		DeliveryAddress  string
+		TotalPrice       float64
+		AddressesID      int
+		Addresses        Addresses `gorm:"foreignKey:AddressesID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		PaymentID        string
+		PaymentKey       string
+		DeliveryStatusID *uint
+		DeliveryStatus   DeliveryStatus `gorm:"foreignKey:DeliveryStatusID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		PaymentStatusID  *uint
+		PaymentStatus    PaymentStatus `gorm:"foreignKey:PaymentStatusID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		CourierID        *uint
+		DeliveryDistance float64
+		PriceLines       []OrderPriceLine `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		CheckoutID       *uint            `gorm:"index"`
+		Items            []OrderItem      `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	}
+
+	type PromoCode struct {
+		gorm.Model
+		Code          string `gorm:"uniqueIndex"`
+		Type          string
+		Value         float64
+		MinOrderValue float64
+		ValidFrom     *time.Time
+		ValidTo       *time.Time
+		UsageLimit    uint
+		PerUserLimit  uint
+		UsedCount     uint
+		Categories    []Category `gorm:"many2many:promo_code_categories;"`
+	}
+
+	type Checkout struct {
+		gorm.Model
+		UserID          uint
+		Orders          []Order `gorm:"foreignKey:CheckoutID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		TotalPrice      float64
+		PaymentID       string `gorm:"index"`
+		PaymentKey      string `gorm:"index"`
+		PaymentStatusID *uint
+		PaymentStatus   PaymentStatus `gorm:"foreignKey:PaymentStatusID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		PromoCodeID     *uint
+		PromoCode       PromoCode `gorm:"foreignKey:PromoCodeID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	}
+
+	type Cart struct {
+		gorm.Model
+		UserID      uint
+		Products    []Products `gorm:"many2many:cart_products;"`
+		PromoCodeID *uint
+		PromoCode   *PromoCode `gorm:"foreignKey:PromoCodeID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	}
+
+	type PromoRedemption struct {
+		gorm.Model
+		PromoCodeID uint
+		PromoCode   PromoCode `gorm:"foreignKey:PromoCodeID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		UserID      uint
+		CheckoutID  uint
+		Checkout    Checkout `gorm:"foreignKey:CheckoutID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		Amount      float64
+	}
+
+	type Stock struct {
+		gorm.Model
+		ProductsID  uint      `gorm:"index"`
+		Products    Products  `gorm:"foreignKey:ProductsID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		AddressesID *uint     `gorm:"index"`
+		Addresses   Addresses `gorm:"foreignKey:AddressesID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		Quantity    uint
+		Reserved    uint
+	}
+
+	type StockReservation struct {
+		gorm.Model
+		OrderID  uint  `gorm:"index"`
+		Order    Order `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		StockID  uint
+		Stock    Stock `gorm:"foreignKey:StockID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		Quantity uint
+		Status   string
+	}
+
+	return tx.AutoMigrate(
+		&Shop{},
+		&Addresses{},
+		&Category{},
+		&Products{},
+		&PaymentStatus{},
+		&DeliveryStatus{},
+		&DeliveryTariff{},
+		&PromoCode{},
+		&Checkout{},
+		&Order{},
+		&OrderPriceLine{},
+		&OrderItem{},
+		&Cart{},
+		&PromoRedemption{},
+		&Stock{},
+		&StockReservation{},
+	)
+}
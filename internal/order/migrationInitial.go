@@ -0,0 +1,86 @@
+package order
+
+import "gorm.io/gorm"
+
+// Начальная схема тенанта - модели в том виде, в каком они были до появления миграций.
+// Модели зафиксированы и не меняются вместе с model.go: имена типов совпадают с моделями,
+// поэтому имена таблиц, связей и ограничений остаются прежними.
+func migrateInitial(tx *gorm.DB) error {
+	type Shop struct {
+		gorm.Model
+		Name        string
+		Description string
+		ContactInfo string
+	}
+
+	type Addresses struct {
+		gorm.Model
+		Region      string
+		City        string
+		Street      string
+		ContactInfo string
+	}
+
+	type Category struct {
+		gorm.Model
+		ParentCategoryID *uint
+		Name             string
+		Path             string
+	}
+
+	type Products struct {
+		gorm.Model
+		Name        string
+		Description string
+		ImageID     string
+		Price       uint
+		CategoryID  *uint
+		Category    Category `gorm:"foreignKey:CategoryID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	}
+
+	type PaymentStatus struct {
+		gorm.Model
+		Code string
+		Name string
+	}
+
+	type DeliveryStatus struct {
+		gorm.Model
+		Code string
+		Name string
+	}
+
+	type Order struct {
+		gorm.Model
+		UserID           uint
+		Products         []Products `gorm:"many2many:order_products;"`
+		DeliveryAddress  string
+		TotalPrice       float64
+		AddressesID      int
+		Addresses        Addresses `gorm:"foreignKey:AddressesID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		PaymentID        string
+		PaymentKey       string
+		DeliveryStatusID *uint
+		DeliveryStatus   DeliveryStatus `gorm:"foreignKey:DeliveryStatusID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		PaymentStatusID  *uint
+		PaymentStatus    PaymentStatus `gorm:"foreignKey:PaymentStatusID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+		CourierID        *uint
+	}
+
+	type Cart struct {
+		gorm.Model
+		UserID   uint
+		Products []Products `gorm:"many2many:cart_products;"`
+	}
+
+	return tx.AutoMigrate(
+		&Shop{},
+		&Addresses{},
+		&Category{},
+		&Products{},
+		&PaymentStatus{},
+		&DeliveryStatus{},
+		&Order{},
+		&Cart{},
+	)
+}
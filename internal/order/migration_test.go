@@ -0,0 +1,110 @@
+package order
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mserebryaakov/aggregator-order-service/pkg/postgres"
+	"gorm.io/gorm"
+)
+
+func TestMigrationVersions(t *testing.T) {
+	names := make(map[string]struct{}, len(Migrations))
+	for i, migration := range Migrations {
+		if migration.Version != uint(i+1) {
+			t.Errorf("migration %q: version = %d, want %d", migration.Name, migration.Version, i+1)
+		}
+		if migration.Up == nil {
+			t.Errorf("migration %d: Up is nil", migration.Version)
+		}
+		if _, ok := names[migration.Name]; ok {
+			t.Errorf("migration %d: duplicate name %q", migration.Version, migration.Name)
+		}
+		names[migration.Name] = struct{}{}
+	}
+}
+
+// Каждая версия меняет схему и на новой схеме: изменения следующей версии
+// не появляются раньше неё, а после всех версий схема совпадает с model.go
+func TestMigrationsStepByStep(t *testing.T) {
+	scp, log := integrationConnectionPool(t)
+
+	schemaName := fmt.Sprintf("migrations_%d", time.Now().UnixNano())
+	migrator := postgres.NewMigrator(scp, nil, log)
+	if err := migrator.CreateSchema(schemaName); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := migrator.DropSchema(schemaName); err != nil {
+			t.Errorf("DropSchema %s: %v", schemaName, err)
+		}
+	})
+
+	db, err := scp.GetConnectionPool(schemaName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Изменения схемы, которые вносит версия
+	steps := []struct {
+		version uint
+		check   func(m gorm.Migrator) bool
+	}{
+		{1, func(m gorm.Migrator) bool { return m.HasTable(&Order{}) && m.HasTable(&Cart{}) }},
+		{2, func(m gorm.Migrator) bool { return m.HasTable(&Checkout{}) && m.HasColumn(&Products{}, "Weight") }},
+		{3, func(gorm.Migrator) bool { return hasIndex(t, db, schemaName, "idx_payment_statuses_code") }},
+		{4, func(m gorm.Migrator) bool { return m.HasColumn(&Checkout{}, "PaymentProvider") }},
+		{5, func(m gorm.Migrator) bool { return m.HasColumn(&Checkout{}, "CaptureMode") }},
+		{6, func(m gorm.Migrator) bool { return m.HasColumn(&Checkout{}, "PaymentMethod") }},
+		{7, func(m gorm.Migrator) bool { return m.HasTable(&PaymentAttempt{}) }},
+	}
+	if len(steps) != len(Migrations) {
+		t.Fatalf("steps = %d, migrations = %d", len(steps), len(Migrations))
+	}
+
+	for i, step := range steps {
+		if step.check(db.Migrator()) {
+			t.Errorf("version %d: changes are present before the migration", step.version)
+		}
+
+		applied, err := postgres.NewMigrator(scp, Migrations[:i+1], log).Migrate(schemaName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if applied != 1 {
+			t.Errorf("version %d: applied = %d, want 1", step.version, applied)
+		}
+
+		if !step.check(db.Migrator()) {
+			t.Errorf("version %d: changes are missing after the migration", step.version)
+		}
+	}
+
+	models := []interface{}{
+		&Shop{}, &Addresses{}, &Category{}, &Products{}, &PaymentStatus{}, &DeliveryStatus{},
+		&DeliveryTariff{}, &PromoCode{}, &Checkout{}, &Order{}, &OrderPriceLine{}, &OrderItem{},
+		&Cart{}, &PromoRedemption{}, &Stock{}, &StockReservation{}, &TenantSettings{}, &PaymentAttempt{},
+	}
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		for _, column := range stmt.Schema.DBNames {
+			if !db.Migrator().HasColumn(model, column) {
+				t.Errorf("%s: column %s is missing", stmt.Schema.Table, column)
+			}
+		}
+	}
+}
+
+// Индексы создаются миграциями через search_path, поэтому их имена не содержат префикса схемы
+func hasIndex(t *testing.T, db *gorm.DB, schemaName, index string) bool {
+	var count int64
+	err := db.Raw("SELECT COUNT(*) FROM pg_indexes WHERE schemaname = ? AND indexname = ?", schemaName, index).Scan(&count).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
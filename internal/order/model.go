@@ -1,18 +1,41 @@
 package order
 
-import "gorm.io/gorm"
+import (
+	"time"
 
-var (
+	"gorm.io/gorm"
+)
+
+// Коды статусов (DeliveryStatus.Code / PaymentStatus.Code)
+const (
 	// delivery
-	WaitingProcessing         uint = 1 // Ожидание обработки
-	ProcessOfDelivery         uint = 2 // В процессе доставки
-	DeliveredDelivery         uint = 3 // Доставлено
-	WaitingProcessingDelivery uint = 4 // Ожидание оплаты
+	WaitingProcessing         = "waiting_processing"  // Ожидание обработки
+	ProcessOfDelivery         = "process_of_delivery" // В процессе доставки
+	DeliveredDelivery         = "delivered"           // Доставлено
+	WaitingProcessingDelivery = "waiting_payment"     // Ожидание оплаты
 
 	// payment
-	WaitingProcessingPayment uint = 1 // Ожидание оплаты
-	PaidPayment              uint = 2 // Оплачено
-	CanceledPayment          uint = 3 // Отменено
+	WaitingProcessingPayment = "waiting_payment" // Ожидание оплаты
+	PaidPayment              = "paid"            // Оплачено
+	CanceledPayment          = "canceled"        // Отменено
+	AuthorizedPayment        = "authorized"      // Средства удержаны, ожидают списания
+	PayOnDeliveryPayment     = "pay_on_delivery" // Оплата при получении
+)
+
+// Способ оплаты заказа. Для оплаты при получении платёж в шлюзе не создаётся,
+// курьер фиксирует полученную сумму при доставке.
+const (
+	OnlinePaymentMethod         = "online"
+	CashOnDeliveryPaymentMethod = "cash_on_delivery"
+	CardOnDeliveryPaymentMethod = "card_on_delivery"
+)
+
+// Момент списания оплаты (TenantSettings.CaptureMode). Кроме auto платёж
+// сначала удерживается и списывается, когда все заказы оформления доставлены или приняты магазином.
+const (
+	AutoCaptureMode       = "auto"        // Списание сразу при оплате
+	OnDeliveryCaptureMode = "on_delivery" // Списание при доставке курьером
+	OnAcceptCaptureMode   = "on_accept"   // Списание при принятии заказа магазином
 )
 
 type Shop struct {
@@ -24,6 +47,8 @@ type Shop struct {
 
 type Addresses struct {
 	gorm.Model
+	ShopID      *uint  `json:"shop_id"`
+	Shop        Shop   `gorm:"foreignKey:ShopID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
 	Region      string `json:"region"`
 	City        string `json:"city"`
 	Street      string `json:"street"`
@@ -36,6 +61,8 @@ type Products struct {
 	Description string   `json:"description"`
 	ImageID     string   `json:"image_id"`
 	Price       uint     `json:"price"`
+	AddressesID *uint    `json:"addresses_id"` // Пункт выдачи магазина-продавца
+	Weight      uint     `json:"weight"`       // Вес в граммах
 	CategoryID  *uint    `json:"category_id"`
 	Category    Category `gorm:"foreignKey:CategoryID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
 }
@@ -50,35 +77,201 @@ type Category struct {
 // Order service
 type PaymentStatus struct {
 	gorm.Model
-	Code string `json:"code"`
+	Code string `gorm:"uniqueIndex" json:"code"`
 	Name string `json:"name"`
 }
 
 type DeliveryStatus struct {
 	gorm.Model
-	Code string `json:"code"`
+	Code string `gorm:"uniqueIndex" json:"code"`
 	Name string `json:"name"`
 }
 
 type Order struct {
 	gorm.Model
-	UserID           uint           `json:"user_id"`
-	Products         []Products     `gorm:"many2many:order_products;" json:"products"`
-	DeliveryAddress  string         `json:"delivery_address"`
-	TotalPrice       float64        `json:"total_price"`
-	AddressesID      int            `json:"addresses_id"`
-	Addresses        Addresses      `gorm:"foreignKey:AddressesID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
-	PaymentID        string         `json:"payment_id"`
-	PaymentKey       string         `json:"payment_key"`
-	DeliveryStatusID *uint          `json:"delivery_status_id"`
-	DeliveryStatus   DeliveryStatus `gorm:"foreignKey:DeliveryStatusID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
-	PaymentStatusID  *uint          `json:"payment_status_id"`
-	PaymentStatus    PaymentStatus  `gorm:"foreignKey:PaymentStatusID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
-	CourierID        *uint          `json:"courier_id"`
+	UserID           uint             `json:"user_id"`
+	Products         []Products       `gorm:"many2many:order_products;" json:"products"`
+	DeliveryAddress  string           `json:"delivery_address"`
+	TotalPrice       float64          `json:"total_price"`
+	AddressesID      int              `json:"addresses_id"`
+	Addresses        Addresses        `gorm:"foreignKey:AddressesID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	PaymentID        string           `json:"payment_id"`
+	PaymentKey       string           `json:"payment_key"`
+	PaymentProvider  string           `json:"payment_provider"`
+	CaptureMode      string           `json:"capture_mode"`
+	PaymentMethod    string           `json:"payment_method"`
+	CollectedAmount  *float64         `json:"collected_amount"` // Получено курьером при оплате при получении
+	CollectedAt      *time.Time       `json:"collected_at"`
+	AcceptedAt       *time.Time       `json:"accepted_at"` // Принят магазином
+	DeliveryStatusID *uint            `json:"delivery_status_id"`
+	DeliveryStatus   DeliveryStatus   `gorm:"foreignKey:DeliveryStatusID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	PaymentStatusID  *uint            `json:"payment_status_id"`
+	PaymentStatus    PaymentStatus    `gorm:"foreignKey:PaymentStatusID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	CourierID        *uint            `json:"courier_id"`
+	DeliveryDistance float64          `json:"delivery_distance"` // Расстояние доставки в км
+	PriceLines       []OrderPriceLine `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"price_lines"`
+	CheckoutID       *uint            `gorm:"index" json:"checkout_id"`
+	Items            []OrderItem      `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"items"`
+}
+
+// Товар заказа с ценой на момент оформления
+type OrderItem struct {
+	gorm.Model
+	OrderID    uint   `gorm:"index" json:"order_id"`
+	ProductsID uint   `json:"products_id"`
+	Name       string `json:"name"`
+	Price      uint   `json:"price"`
+}
+
+// Оформление корзины: заказы по адресам выдачи с единым платежом
+type Checkout struct {
+	gorm.Model
+	UserID           uint          `json:"user_id"`
+	Orders           []Order       `gorm:"foreignKey:CheckoutID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"orders"`
+	TotalPrice       float64       `json:"total_price"`
+	PaymentID        string        `gorm:"index" json:"payment_id"`
+	PaymentKey       string        `gorm:"index" json:"payment_key"`
+	PaymentProvider  string        `json:"payment_provider"`
+	CaptureMode      string        `json:"capture_mode"`
+	PaymentMethod    string        `json:"payment_method"`
+	AuthorizedAt     *time.Time    `json:"authorized_at"`
+	AuthorizedUntil  *time.Time    `json:"authorized_until"`   // Срок удержания по данным шлюза
+	PaymentRenewedAt *time.Time    `json:"payment_renewed_at"` // Создание нового платежа взамен прежнего
+	PaymentStatusID  *uint         `json:"payment_status_id"`
+	PaymentStatus    PaymentStatus `gorm:"foreignKey:PaymentStatusID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	PromoCodeID      *uint         `json:"promo_code_id"`
+	PromoCode        PromoCode     `gorm:"foreignKey:PromoCodeID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
+}
+
+// Тип тарифа доставки
+const (
+	FlatDeliveryTariff     = "flat"
+	DistanceDeliveryTariff = "distance"
+	WeightDeliveryTariff   = "weight"
+)
+
+// Тариф доставки и налог магазина (ShopID == nil - тариф по умолчанию)
+type DeliveryTariff struct {
+	gorm.Model
+	ShopID        *uint   `gorm:"uniqueIndex" json:"shop_id"`
+	Shop          Shop    `gorm:"foreignKey:ShopID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Type          string  `json:"type"`
+	BaseFee       float64 `json:"base_fee"`
+	PerKmFee      float64 `json:"per_km_fee"`
+	PerKgFee      float64 `json:"per_kg_fee"`
+	FreeThreshold float64 `json:"free_threshold"` // Бесплатная доставка от суммы (0 - отключено)
+	TaxPercent    float64 `json:"tax_percent"`
+	TaxIncluded   bool    `json:"tax_included"` // Налог уже включен в цену товара
+}
+
+// Тип строки расчёта стоимости заказа
+const (
+	SubtotalPriceLine = "subtotal"
+	DeliveryPriceLine = "delivery"
+	DiscountPriceLine = "discount"
+	TaxPriceLine      = "tax"
+)
+
+// Строка расчёта стоимости заказа
+type OrderPriceLine struct {
+	gorm.Model
+	OrderID uint    `json:"order_id"`
+	Type    string  `json:"type"`
+	Name    string  `json:"name"`
+	Amount  float64 `json:"amount"`
 }
 
 type Cart struct {
 	gorm.Model
-	UserID   uint       `json:"user_id"`
-	Products []Products `gorm:"many2many:cart_products;" json:"products"`
+	UserID      uint       `json:"user_id"`
+	Products    []Products `gorm:"many2many:cart_products;" json:"products"`
+	PromoCodeID *uint      `json:"promo_code_id"`
+	PromoCode   *PromoCode `gorm:"foreignKey:PromoCodeID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"-"`
+}
+
+// Тип промокода
+const (
+	PercentPromoCode = "percent"
+	FixedPromoCode   = "fixed"
+)
+
+// Промокод (Categories пустой - применяется ко всем товарам)
+type PromoCode struct {
+	gorm.Model
+	Code          string     `gorm:"uniqueIndex" json:"code"`
+	Type          string     `json:"type"`
+	Value         float64    `json:"value"`
+	MinOrderValue float64    `json:"min_order_value"`
+	ValidFrom     *time.Time `json:"valid_from"`
+	ValidTo       *time.Time `json:"valid_to"`
+	UsageLimit    uint       `json:"usage_limit"`    // 0 - без ограничений
+	PerUserLimit  uint       `json:"per_user_limit"` // 0 - без ограничений
+	UsedCount     uint       `json:"used_count"`
+	Categories    []Category `gorm:"many2many:promo_code_categories;" json:"categories"`
+}
+
+// Использование промокода в заказе
+type PromoRedemption struct {
+	gorm.Model
+	PromoCodeID uint      `json:"promo_code_id"`
+	PromoCode   PromoCode `gorm:"foreignKey:PromoCodeID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	UserID      uint      `json:"user_id"`
+	CheckoutID  uint      `json:"checkout_id"`
+	Checkout    Checkout  `gorm:"foreignKey:CheckoutID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Amount      float64   `json:"amount"`
+}
+
+// Остаток товара (AddressesID == nil - общий склад, иначе пункт выдачи)
+type Stock struct {
+	gorm.Model
+	ProductsID  uint      `gorm:"index" json:"products_id"`
+	Products    Products  `gorm:"foreignKey:ProductsID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	AddressesID *uint     `gorm:"index" json:"addresses_id"`
+	Addresses   Addresses `gorm:"foreignKey:AddressesID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Quantity    uint      `json:"quantity"` // Доступно к продаже
+	Reserved    uint      `json:"reserved"` // Зарезервировано неоплаченными заказами
+}
+
+// Статус резерва товара
+const (
+	ReservedStockReservation  = "reserved"
+	CommittedStockReservation = "committed"
+	ReleasedStockReservation  = "released"
+)
+
+// Резерв товара под заказ
+type StockReservation struct {
+	gorm.Model
+	OrderID  uint   `gorm:"index" json:"order_id"`
+	Order    Order  `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	StockID  uint   `json:"stock_id"`
+	Stock    Stock  `gorm:"foreignKey:StockID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Quantity uint   `json:"quantity"`
+	Status   string `json:"status"`
+}
+
+// Настройки магазина (одна запись в схеме тенанта)
+type TenantSettings struct {
+	gorm.Model
+	PaymentProvider string `json:"payment_provider"` // пусто - шлюз по умолчанию
+	CaptureMode     string `json:"capture_mode"`     // пусто - auto
+}
+
+// Статус попытки оплаты: active - текущий платёж оформления, остальные - причина замены платежа
+const (
+	ActivePaymentAttempt   = "active"
+	CanceledPaymentAttempt = "canceled"
+	ExpiredPaymentAttempt  = "expired"
+	NotFoundPaymentAttempt = "not_found"
+)
+
+// Платёж, созданный для оформления; при повторной оплате создаётся новая попытка
+type PaymentAttempt struct {
+	gorm.Model
+	CheckoutID      uint     `gorm:"index" json:"checkout_id"`
+	Checkout        Checkout `gorm:"foreignKey:CheckoutID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	PaymentID       string   `gorm:"index" json:"payment_id"`
+	PaymentKey      string   `json:"payment_key"`
+	PaymentProvider string   `json:"payment_provider"`
+	Status          string   `json:"status"`
 }
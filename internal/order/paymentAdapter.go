@@ -2,12 +2,14 @@ package order
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
 
+	"github.com/mserebryaakov/aggregator-order-service/pkg/httpclient"
 	"github.com/sirupsen/logrus"
 )
 
@@ -23,32 +25,41 @@ func (h *PaymentAdapterLogHook) Levels() []logrus.Level {
 }
 
 type paymentAdapter struct {
-	client      http.Client
+	client      *httpclient.Client
+	timeout     time.Duration
 	log         *logrus.Entry
 	paymentHost string
 	paymentPort string
 }
 
-func NewPaymentAdapter(log *logrus.Entry, paymentHost, paymentPort string) *paymentAdapter {
-	c := http.Client{
-		Timeout: time.Second * 10,
+// client - клиент с повторами и автоматом отключения платёжного сервиса;
+// timeout - ограничение времени одного запроса к платёжному сервису (вместе с повторами)
+func NewPaymentAdapter(log *logrus.Entry, paymentHost, paymentPort string, client *httpclient.Client, timeout time.Duration) *paymentAdapter {
+	if timeout <= 0 {
+		timeout = defaultAdapterTimeout
 	}
 
 	return &paymentAdapter{
-		client:      c,
+		client:      client,
+		timeout:     timeout,
 		log:         log,
 		paymentHost: paymentHost,
 		paymentPort: paymentPort,
 	}
 }
 
+func (p *paymentAdapter) Name() string {
+	return PaymentServiceProvider
+}
+
 type Amount struct {
 	Value    string `json:"value"`
 	Currency string `json:"currency"`
 }
 
 type Metadata struct {
-	OrderID string `json:"order_id"`
+	OrderID    string `json:"order_id"`
+	CheckoutID string `json:"checkout_id,omitempty"`
 }
 
 type Recipient struct {
@@ -112,108 +123,64 @@ type CreateRefund struct {
 	Amount    Amount `json:"amount"`
 }
 
-func (p *paymentAdapter) CreatePayment(createPayment CreatePayment, idempotenceKey string) (*Payment, int, error) {
+func (p *paymentAdapter) CreatePayment(ctx context.Context, createPayment CreatePayment, idempotenceKey string) (*Payment, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	ctx = httpclient.Idempotent(ctx)
+
 	createPaymentBytes, err := json.Marshal(createPayment)
 	if err != nil {
 		p.log.Debugf("CreatePayment: error marshal createPayment - %v", err)
-		return nil, 0, fmt.Errorf("error marshal createPayment")
+		return nil, NewError(JsonAppError, "error marshal createPayment", 500, err)
 	}
 
-	url := fmt.Sprintf("http://%s%s%s", p.paymentHost, p.paymentPort, "/payment")
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(createPaymentBytes))
+	req, err := p.newRequest(ctx, http.MethodPost, "/payment", bytes.NewReader(createPaymentBytes))
 	if err != nil {
 		p.log.Errorf("CreatePayment: failed create CreatePayment request - /payment - %v", err)
-		return nil, 0, fmt.Errorf("failed CreatePayment request")
+		return nil, NewError(ServerAppError, "failed create CreatePayment request", 500, err)
 	}
 
 	q := req.URL.Query()
 	q.Add("idempotenceKey", idempotenceKey)
 	req.URL.RawQuery = q.Encode()
 
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := p.client.Do(req)
-	if err != nil {
-		p.log.Errorf("CreatePayment: failed CreatePayment request - %v", err)
-		return nil, 0, fmt.Errorf("failed CreatePayment request")
-	}
-	defer resp.Body.Close()
-
-	bts, err := io.ReadAll(resp.Body)
-	if err != nil {
-		p.log.Debugf("CreatePayment: failed readAll body - %v", err)
-		return nil, 0, fmt.Errorf("failed readAll body")
-	}
-
-	switch resp.StatusCode {
-	case http.StatusOK:
-		var payment Payment
-		buf := bytes.NewBuffer(bts)
-		err = json.NewDecoder(buf).Decode(&payment)
-		if err != nil {
-			p.log.Errorf("CreatePayment: failed to decode response body - %v", err)
-			return nil, 500, fmt.Errorf("failed to decode response body")
-		}
-		return &payment, 200, nil
-	case http.StatusBadRequest:
-		p.log.Errorf("CreatePayment: failed CreatePayment response (StatusBadRequest) - %v, body - %s", err, string(bts))
-		return nil, 400, fmt.Errorf("failed CreatePayment response (StatusBadRequest)")
-	default:
-		p.log.Errorf("CreatePayment: failed CreatePayment response (unexpected error) - %d, body - %s", resp.StatusCode, string(bts))
-		return nil, 500, fmt.Errorf("failed CreatePayment response (unexpected error)")
+	var payment Payment
+	if err := p.do(req, "CreatePayment", &payment); err != nil {
+		return nil, err
 	}
+	return &payment, nil
 }
 
-func (p *paymentAdapter) GetPayment(paymentId string) (*Payment, int, error) {
-	url := fmt.Sprintf("http://%s%s%s", p.paymentHost, p.paymentPort, "/payment")
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+func (p *paymentAdapter) GetPayment(ctx context.Context, paymentId string) (*Payment, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	req, err := p.newRequest(ctx, http.MethodGet, "/payment", nil)
 	if err != nil {
 		p.log.Errorf("GetPayment: failed create GetPayment request - /payment - %v", err)
-		return nil, 0, fmt.Errorf("failed GetPayment request")
+		return nil, NewError(ServerAppError, "failed create GetPayment request", 500, err)
 	}
 
 	q := req.URL.Query()
 	q.Add("paymentId", paymentId)
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := p.client.Do(req)
-	if err != nil {
-		p.log.Errorf("GetPayment: failed GetPayment request - %v", err)
-		return nil, 0, fmt.Errorf("failed GetPayment request")
-	}
-	defer resp.Body.Close()
-
-	bts, err := io.ReadAll(resp.Body)
-	if err != nil {
-		p.log.Debugf("GetPayment: failed readAll body - %v", err)
-		return nil, 0, fmt.Errorf("failed readAll body")
-	}
-
-	switch resp.StatusCode {
-	case http.StatusOK:
-		var payment Payment
-		buf := bytes.NewBuffer(bts)
-		err = json.NewDecoder(buf).Decode(&payment)
-		if err != nil {
-			p.log.Errorf("GetPayment: failed to decode response body - %v", err)
-			return nil, 500, fmt.Errorf("failed to decode response body")
-		}
-		return &payment, 200, nil
-	case http.StatusBadRequest:
-		return nil, 400, fmt.Errorf("GetPayment: incorrect request (GetPayment request) - StatusBadRequest, body - %s", string(bts))
-	case http.StatusNotFound:
-		return nil, 404, fmt.Errorf("GetPayment: (GetPayment request) - StatusNotFound")
-	default:
-		return nil, 500, fmt.Errorf("GetPayment: unexpected status code (GetPayment request) - %v, body - %s", resp.StatusCode, string(bts))
+	var payment Payment
+	if err := p.do(req, "GetPayment", &payment); err != nil {
+		return nil, err
 	}
+	return &payment, nil
 }
 
-func (p *paymentAdapter) CapturePayment(idempotenceKey, paymentID string) (*Payment, int, error) {
-	url := fmt.Sprintf("http://%s%s%s", p.paymentHost, p.paymentPort, "/payment/capture")
-	req, err := http.NewRequest(http.MethodPost, url, nil)
+func (p *paymentAdapter) CapturePayment(ctx context.Context, idempotenceKey, paymentID string) (*Payment, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	ctx = httpclient.Idempotent(ctx)
+
+	req, err := p.newRequest(ctx, http.MethodPost, "/payment/capture", nil)
 	if err != nil {
 		p.log.Errorf("CapturePayment: failed create CapturePayment request - /payment/capture - %v", err)
-		return nil, 0, fmt.Errorf("failed CapturePayment request")
+		return nil, NewError(ServerAppError, "failed create CapturePayment request", 500, err)
 	}
 
 	q := req.URL.Query()
@@ -221,44 +188,22 @@ func (p *paymentAdapter) CapturePayment(idempotenceKey, paymentID string) (*Paym
 	q.Add("paymentID", paymentID)
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := p.client.Do(req)
-	if err != nil {
-		p.log.Errorf("CapturePayment: failed CapturePayment request - %v", err)
-		return nil, 0, fmt.Errorf("failed CapturePayment request")
-	}
-	defer resp.Body.Close()
-
-	bts, err := io.ReadAll(resp.Body)
-	if err != nil {
-		p.log.Debugf("CreatePayment: failed readAll body - %v", err)
-		return nil, 0, fmt.Errorf("failed readAll body")
-	}
-
-	switch resp.StatusCode {
-	case http.StatusOK:
-		var payment Payment
-		buf := bytes.NewBuffer(bts)
-		err = json.NewDecoder(buf).Decode(&payment)
-		if err != nil {
-			p.log.Errorf("CapturePayment: failed to decode response body - %v", err)
-			return nil, 500, fmt.Errorf("failed to decode response body")
-		}
-		return &payment, 200, nil
-	case http.StatusBadRequest:
-		p.log.Errorf("CapturePayment: failed CapturePayment response (StatusBadRequest) - %v, body - %s", err, string(bts))
-		return nil, 400, fmt.Errorf("failed CapturePayment response (StatusBadRequest)")
-	default:
-		p.log.Errorf("CapturePayment: failed CapturePayment response (unexpected error) - %d, body - %s", resp.StatusCode, string(bts))
-		return nil, 500, fmt.Errorf("failed CapturePayment response (unexpected error)")
+	var payment Payment
+	if err := p.do(req, "CapturePayment", &payment); err != nil {
+		return nil, err
 	}
+	return &payment, nil
 }
 
-func (p *paymentAdapter) CancelPayment(idempotenceKey, paymentID string) (*Payment, int, error) {
-	url := fmt.Sprintf("http://%s%s%s", p.paymentHost, p.paymentPort, "/payment/cancel")
-	req, err := http.NewRequest(http.MethodPost, url, nil)
+func (p *paymentAdapter) CancelPayment(ctx context.Context, idempotenceKey, paymentID string) (*Payment, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	ctx = httpclient.Idempotent(ctx)
+
+	req, err := p.newRequest(ctx, http.MethodPost, "/payment/cancel", nil)
 	if err != nil {
 		p.log.Errorf("CancelPayment: failed create CancelPayment request - /payment/cancel - %v", err)
-		return nil, 0, fmt.Errorf("failed CancelPayment request")
+		return nil, NewError(ServerAppError, "failed create CancelPayment request", 500, err)
 	}
 
 	q := req.URL.Query()
@@ -266,130 +211,143 @@ func (p *paymentAdapter) CancelPayment(idempotenceKey, paymentID string) (*Payme
 	q.Add("paymentID", paymentID)
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := p.client.Do(req)
-	if err != nil {
-		p.log.Errorf("CancelPayment: failed CancelPayment request - %v", err)
-		return nil, 0, fmt.Errorf("failed CancelPayment request")
-	}
-	defer resp.Body.Close()
-
-	bts, err := io.ReadAll(resp.Body)
-	if err != nil {
-		p.log.Debugf("CancelPayment: failed readAll body - %v", err)
-		return nil, 0, fmt.Errorf("failed readAll body")
-	}
-
-	switch resp.StatusCode {
-	case http.StatusOK:
-		var payment Payment
-		buf := bytes.NewBuffer(bts)
-		err = json.NewDecoder(buf).Decode(&payment)
-		if err != nil {
-			p.log.Errorf("CancelPayment: failed to decode response body - %v", err)
-			return nil, 500, fmt.Errorf("failed to decode response body")
-		}
-		return &payment, 200, nil
-	case http.StatusBadRequest:
-		p.log.Errorf("CancelPayment: failed CancelPayment response (StatusBadRequest) - %v, body - %s", err, string(bts))
-		return nil, 400, fmt.Errorf("failed CancelPayment response (StatusBadRequest)")
-	default:
-		p.log.Errorf("CancelPayment: failed CancelPayment response (unexpected error) - %d, body - %s", resp.StatusCode, string(bts))
-		return nil, 500, fmt.Errorf("failed CancelPayment response (unexpected error)")
+	var payment Payment
+	if err := p.do(req, "CancelPayment", &payment); err != nil {
+		return nil, err
 	}
+	return &payment, nil
 }
 
-func (p *paymentAdapter) CreateRefund(createRefund CreateRefund, idempotenceKey string) (*Refund, int, error) {
+func (p *paymentAdapter) CreateRefund(ctx context.Context, createRefund CreateRefund, idempotenceKey string) (*Refund, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	ctx = httpclient.Idempotent(ctx)
+
 	createRefundBytes, err := json.Marshal(createRefund)
 	if err != nil {
 		p.log.Debugf("CreateRefund: error marshal CreateRefund - %v", err)
-		return nil, 0, fmt.Errorf("error marshal createRefund")
+		return nil, NewError(JsonAppError, "error marshal createRefund", 500, err)
 	}
 
-	url := fmt.Sprintf("http://%s%s%s", p.paymentHost, p.paymentPort, "/refund")
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(createRefundBytes))
+	req, err := p.newRequest(ctx, http.MethodPost, "/refund", bytes.NewReader(createRefundBytes))
 	if err != nil {
 		p.log.Errorf("CreateRefund: failed create CreateRefund request - /refund - %v", err)
-		return nil, 0, fmt.Errorf("failed CreateRefund request")
+		return nil, NewError(ServerAppError, "failed create CreateRefund request", 500, err)
 	}
 
 	q := req.URL.Query()
 	q.Add("idempotenceKey", idempotenceKey)
 	req.URL.RawQuery = q.Encode()
 
-	req.Header.Set("Content-Type", "application/json")
+	var refund Refund
+	if err := p.do(req, "CreateRefund", &refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+func (p *paymentAdapter) GetRefund(ctx context.Context, refundId string) (*Refund, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	req, err := p.newRequest(ctx, http.MethodGet, "/refund", nil)
+	if err != nil {
+		p.log.Errorf("GetRefund: failed create GetRefund request - /refund - %v", err)
+		return nil, NewError(ServerAppError, "failed create GetRefund request", 500, err)
+	}
+
+	q := req.URL.Query()
+	q.Add("refundId", refundId)
+	req.URL.RawQuery = q.Encode()
+
+	var refund Refund
+	if err := p.do(req, "GetRefund", &refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+func (p *paymentAdapter) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	url := fmt.Sprintf("http://%s%s%s", p.paymentHost, p.paymentPort, path)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
 
+// Выполнение запроса к платёжному сервису и разбор успешного ответа в out.
+// Ошибки возвращаются как *GatewayError.
+func (p *paymentAdapter) do(req *http.Request, operation string, out interface{}) error {
 	resp, err := p.client.Do(req)
 	if err != nil {
-		p.log.Errorf("CreateRefund: failed CreateRefund request - %v", err)
-		return nil, 0, fmt.Errorf("failed CreateRefund request")
+		p.log.Errorf("%s: failed %s request - %v", operation, operation, err)
+		return &GatewayError{
+			Operation: operation,
+			HTTPCode:  http.StatusServiceUnavailable,
+			Retryable: true,
+			Err:       err,
+		}
 	}
 	defer resp.Body.Close()
 
 	bts, err := io.ReadAll(resp.Body)
 	if err != nil {
-		p.log.Debugf("CreateRefund: failed readAll body - %v", err)
-		return nil, 0, fmt.Errorf("failed readAll body")
+		p.log.Debugf("%s: failed readAll body - %v", operation, err)
+		return &GatewayError{
+			Operation:  operation,
+			HTTPCode:   http.StatusBadGateway,
+			StatusCode: resp.StatusCode,
+			Retryable:  true,
+			Err:        err,
+		}
 	}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		var refund Refund
-		buf := bytes.NewBuffer(bts)
-		err = json.NewDecoder(buf).Decode(&refund)
-		if err != nil {
-			p.log.Errorf("CreateRefund: failed to decode response body - %v", err)
-			return nil, 500, fmt.Errorf("failed to decode response body")
+	if resp.StatusCode != http.StatusOK {
+		gwErr := newGatewayError(operation, resp.StatusCode, bts)
+		p.log.Errorf("%s: failed %s response - %v, body - %s", operation, operation, gwErr, string(bts))
+		return gwErr
+	}
+
+	if err := json.Unmarshal(bts, out); err != nil {
+		p.log.Errorf("%s: failed to decode response body - %v", operation, err)
+		return &GatewayError{
+			Operation:  operation,
+			HTTPCode:   http.StatusBadGateway,
+			StatusCode: resp.StatusCode,
+			Err:        err,
 		}
-		return &refund, 200, nil
-	case http.StatusBadRequest:
-		p.log.Errorf("CreateRefund: failed CreateRefund response (StatusBadRequest) - %v, body - %s", err, string(bts))
-		return nil, 400, fmt.Errorf("failed CreateRefund response (StatusBadRequest)")
-	default:
-		p.log.Errorf("CreateRefund: failed CreateRefund response (unexpected error) - %d, body - %s", resp.StatusCode, string(bts))
-		return nil, 500, fmt.Errorf("failed CreateRefund response (unexpected error)")
 	}
+
+	return nil
 }
 
-func (p *paymentAdapter) GetRefund(refundId string) (*Refund, int, error) {
-	url := fmt.Sprintf("http://%s%s%s", p.paymentHost, p.paymentPort, "/refund")
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// Проверка доступности платёжного сервиса: любой ответ, кроме 5xx, считается успешным
+func (p *paymentAdapter) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s%s%s", p.paymentHost, p.paymentPort, "/payment")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		p.log.Errorf("GetRefund: failed create GetRefund request - /refund - %v", err)
-		return nil, 0, fmt.Errorf("failed GetRefund request")
+		return err
 	}
 
-	q := req.URL.Query()
-	q.Add("refundId", refundId)
-	req.URL.RawQuery = q.Encode()
-
 	resp, err := p.client.Do(req)
 	if err != nil {
-		p.log.Errorf("GetRefund: failed GetRefund request - %v", err)
-		return nil, 0, fmt.Errorf("failed GetRefund request")
+		return err
 	}
 	defer resp.Body.Close()
 
-	bts, err := io.ReadAll(resp.Body)
-	if err != nil {
-		p.log.Debugf("CreatePayment: failed readAll body - %v", err)
-		return nil, 0, fmt.Errorf("failed readAll body")
-	}
+	io.Copy(io.Discard, resp.Body)
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		var refund Refund
-		buf := bytes.NewBuffer(bts)
-		err = json.NewDecoder(buf).Decode(&refund)
-		if err != nil {
-			p.log.Errorf("GetRefund: failed to decode response body - %v", err)
-			return nil, 500, fmt.Errorf("failed to decode response body")
-		}
-		return &refund, 200, nil
-	case http.StatusBadRequest:
-		return nil, 400, fmt.Errorf("GetRefund: incorrect request (GetRefund request) - StatusBadRequest, body - %s", string(bts))
-	case http.StatusNotFound:
-		return nil, 404, fmt.Errorf("GetRefund: (GetRefund request) - StatusNotFound")
-	default:
-		return nil, 500, fmt.Errorf("GetRefund: unexpected status code (GetRefund request) - %v, body - %s", resp.StatusCode, string(bts))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("payment service responded with code - %d", resp.StatusCode)
 	}
+
+	return nil
 }
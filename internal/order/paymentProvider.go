@@ -0,0 +1,71 @@
+package order
+
+import (
+	"context"
+	"sort"
+)
+
+// Платёжные шлюзы
+const (
+	PaymentServiceProvider = "payment_service" // собственный платёжный сервис (paymentAdapter)
+	FakeProvider           = "fake"            // имитация шлюза в памяти процесса
+)
+
+// Платёжный шлюз. Ошибки шлюза возвращаются как *GatewayError.
+type PaymentProvider interface {
+	Name() string
+	CreatePayment(ctx context.Context, createPayment CreatePayment, idempotenceKey string) (*Payment, error)
+	GetPayment(ctx context.Context, paymentID string) (*Payment, error)
+	CapturePayment(ctx context.Context, idempotenceKey, paymentID string) (*Payment, error)
+	CancelPayment(ctx context.Context, idempotenceKey, paymentID string) (*Payment, error)
+	CreateRefund(ctx context.Context, createRefund CreateRefund, idempotenceKey string) (*Refund, error)
+	GetRefund(ctx context.Context, refundID string) (*Refund, error)
+}
+
+// Подключённые платёжные шлюзы; шлюз по умолчанию используется магазинами без настройки
+type PaymentProviders struct {
+	providers   map[string]PaymentProvider
+	defaultName string
+}
+
+func NewPaymentProviders(defaultName string, providers ...PaymentProvider) (*PaymentProviders, error) {
+	p := &PaymentProviders{
+		providers:   make(map[string]PaymentProvider, len(providers)),
+		defaultName: defaultName,
+	}
+	for _, provider := range providers {
+		p.providers[provider.Name()] = provider
+	}
+
+	if !p.Has(defaultName) {
+		return nil, errPaymentProviderUnknown
+	}
+	return p, nil
+}
+
+// Шлюз по имени; пустое имя - шлюз по умолчанию
+func (p *PaymentProviders) Get(name string) (PaymentProvider, error) {
+	if name == "" {
+		name = p.defaultName
+	}
+
+	provider, ok := p.providers[name]
+	if !ok {
+		return nil, errPaymentProviderUnknown
+	}
+	return provider, nil
+}
+
+func (p *PaymentProviders) Has(name string) bool {
+	_, ok := p.providers[name]
+	return ok
+}
+
+func (p *PaymentProviders) Names() []string {
+	names := make([]string, 0, len(p.providers))
+	for name := range p.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,102 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Имитация шлюза под другим именем
+type namedProvider struct {
+	*fakeProvider
+	name string
+}
+
+func (p *namedProvider) Name() string {
+	return p.name
+}
+
+func TestNewPaymentProvidersUnknownDefault(t *testing.T) {
+	if _, err := NewPaymentProviders(PaymentServiceProvider, NewFakeProvider()); !errors.Is(err, errPaymentProviderUnknown) {
+		t.Errorf("err = %v, want %v", err, errPaymentProviderUnknown)
+	}
+}
+
+func TestPaymentProvidersGet(t *testing.T) {
+	fake := NewFakeProvider()
+	second := &namedProvider{fakeProvider: NewFakeProvider(), name: "second"}
+
+	payments, err := NewPaymentProviders(FakeProvider, second, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want PaymentProvider
+		err  error
+	}{
+		{name: "", want: fake},
+		{name: FakeProvider, want: fake},
+		{name: "second", want: second},
+		{name: PaymentServiceProvider, err: errPaymentProviderUnknown},
+	}
+
+	for _, tt := range tests {
+		got, err := payments.Get(tt.name)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Get(%q) err = %v, want %v", tt.name, err, tt.err)
+		}
+		if got != tt.want {
+			t.Errorf("Get(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if want := []string{FakeProvider, "second"}; !reflect.DeepEqual(payments.Names(), want) {
+		t.Errorf("Names() = %v, want %v", payments.Names(), want)
+	}
+}
+
+// Списание выполняется в шлюзе, где создан платёж, а не в текущем шлюзе магазина
+func TestCaptureUsesCheckoutProvider(t *testing.T) {
+	second := &namedProvider{fakeProvider: NewFakeProvider(), name: "second"}
+	payments, err := NewPaymentProviders(FakeProvider, NewFakeProvider(), second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkout := &Checkout{Model: gorm.Model{ID: 5}, Orders: []Order{{}}}
+	authorizedCheckout(t, second.fakeProvider, checkout)
+	checkout.PaymentProvider = second.Name()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	service := &fakeCheckoutService{checkout: checkout}
+	pc := newPaymentCapture(service, payments, logrus.NewEntry(logger))
+
+	if err := pc.capture(context.Background(), checkout, "shop"); err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := second.GetPayment(context.Background(), checkout.PaymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != "succeeded" {
+		t.Errorf("payment status = %s, want succeeded", payment.Status)
+	}
+	if len(service.paid) != 1 {
+		t.Errorf("paid checkouts = %v, want [%d]", service.paid, checkout.ID)
+	}
+
+	// Шлюз оформления отключён в конфигурации
+	checkout.PaymentProvider = "removed"
+	if err := pc.capture(context.Background(), checkout, "shop"); !errors.Is(err, errPaymentProviderUnknown) {
+		t.Errorf("err = %v, want %v", err, errPaymentProviderUnknown)
+	}
+}
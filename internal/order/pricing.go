@@ -0,0 +1,149 @@
+package order
+
+import (
+	"fmt"
+	"math"
+)
+
+// Итоговый расчёт стоимости заказа
+type Quote struct {
+	Subtotal    float64          `json:"subtotal"`
+	DeliveryFee float64          `json:"delivery_fee"`
+	Discount    float64          `json:"discount"`
+	Tax         float64          `json:"tax"`
+	Total       float64          `json:"total"`
+	Lines       []OrderPriceLine `json:"lines"`
+}
+
+// Скидка, применяемая к заказу
+type Discount struct {
+	Name   string
+	Amount float64
+}
+
+// Входные данные для расчёта стоимости
+type pricingInput struct {
+	Products  []Products
+	Tariff    *DeliveryTariff
+	Distance  float64
+	Discounts []Discount
+}
+
+// Шаг расчёта стоимости
+type pricingStep func(in *pricingInput, q *Quote) error
+
+// Порядок важен: доставка считается от суммы со скидкой, налог - от итоговой суммы товаров и доставки
+var pricingPipeline = []pricingStep{
+	subtotalStep,
+	discountStep,
+	deliveryStep,
+	taxStep,
+	totalStep,
+}
+
+func calculateQuote(in *pricingInput) (*Quote, error) {
+	q := &Quote{}
+	for _, step := range pricingPipeline {
+		if err := step(in, q); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+func subtotalStep(in *pricingInput, q *Quote) error {
+	for _, p := range in.Products {
+		q.Subtotal += float64(p.Price)
+	}
+	q.Subtotal = roundMoney(q.Subtotal)
+	q.addLine(SubtotalPriceLine, "Товары", q.Subtotal)
+	return nil
+}
+
+func discountStep(in *pricingInput, q *Quote) error {
+	for _, d := range in.Discounts {
+		amount := math.Min(roundMoney(d.Amount), q.Subtotal-q.Discount)
+		if amount <= 0 {
+			continue
+		}
+		q.Discount += amount
+		q.addLine(DiscountPriceLine, d.Name, -amount)
+	}
+	q.Discount = roundMoney(q.Discount)
+	return nil
+}
+
+func deliveryStep(in *pricingInput, q *Quote) error {
+	t := in.Tariff
+	if t == nil {
+		q.addLine(DeliveryPriceLine, "Доставка", 0)
+		return nil
+	}
+
+	if t.FreeThreshold > 0 && q.Subtotal-q.Discount >= t.FreeThreshold {
+		q.addLine(DeliveryPriceLine, "Бесплатная доставка", 0)
+		return nil
+	}
+
+	fee := t.BaseFee
+	switch t.Type {
+	case FlatDeliveryTariff, "":
+	case DistanceDeliveryTariff:
+		if in.Distance < 0 {
+			return fmt.Errorf("%w: %v", errDeliveryDistanceInvalid, in.Distance)
+		}
+		fee += t.PerKmFee * in.Distance
+	case WeightDeliveryTariff:
+		var grams uint
+		for _, p := range in.Products {
+			grams += p.Weight
+		}
+		fee += t.PerKgFee * float64(grams) / 1000
+	default:
+		return fmt.Errorf("%w: %s", errDeliveryTariffUnknown, t.Type)
+	}
+
+	q.DeliveryFee = roundMoney(fee)
+	q.addLine(DeliveryPriceLine, "Доставка", q.DeliveryFee)
+	return nil
+}
+
+func taxStep(in *pricingInput, q *Quote) error {
+	if in.Tariff == nil || in.Tariff.TaxPercent <= 0 {
+		return nil
+	}
+
+	base := q.Subtotal - q.Discount + q.DeliveryFee
+	rate := in.Tariff.TaxPercent / 100
+	if in.Tariff.TaxIncluded {
+		// Налог выделяется из суммы и не увеличивает итог
+		q.Tax = roundMoney(base * rate / (1 + rate))
+		q.addLine(TaxPriceLine, fmt.Sprintf("В т.ч. налог %v%%", in.Tariff.TaxPercent), q.Tax)
+		return nil
+	}
+
+	q.Tax = roundMoney(base * rate)
+	q.addLine(TaxPriceLine, fmt.Sprintf("Налог %v%%", in.Tariff.TaxPercent), q.Tax)
+	return nil
+}
+
+func totalStep(in *pricingInput, q *Quote) error {
+	q.Total = q.Subtotal - q.Discount + q.DeliveryFee
+	if in.Tariff != nil && !in.Tariff.TaxIncluded {
+		q.Total += q.Tax
+	}
+	q.Total = roundMoney(q.Total)
+	return nil
+}
+
+func (q *Quote) addLine(lineType, name string, amount float64) {
+	q.Lines = append(q.Lines, OrderPriceLine{
+		Type:   lineType,
+		Name:   name,
+		Amount: amount,
+	})
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
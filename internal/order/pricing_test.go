@@ -0,0 +1,138 @@
+package order
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCalculateQuote(t *testing.T) {
+	products := []Products{{Price: 600, Weight: 1500}, {Price: 400, Weight: 500}}
+
+	tests := []struct {
+		name      string
+		in        pricingInput
+		want      Quote
+		wantLines int
+	}{
+		{
+			name:      "no tariff",
+			in:        pricingInput{Products: products},
+			want:      Quote{Subtotal: 1000, Total: 1000},
+			wantLines: 2,
+		},
+		{
+			name:      "flat tariff",
+			in:        pricingInput{Products: products, Tariff: &DeliveryTariff{Type: FlatDeliveryTariff, BaseFee: 150}},
+			want:      Quote{Subtotal: 1000, DeliveryFee: 150, Total: 1150},
+			wantLines: 2,
+		},
+		{
+			name:      "distance tariff",
+			in:        pricingInput{Products: products, Tariff: &DeliveryTariff{Type: DistanceDeliveryTariff, BaseFee: 100, PerKmFee: 12.5}, Distance: 4},
+			want:      Quote{Subtotal: 1000, DeliveryFee: 150, Total: 1150},
+			wantLines: 2,
+		},
+		{
+			name:      "weight tariff",
+			in:        pricingInput{Products: products, Tariff: &DeliveryTariff{Type: WeightDeliveryTariff, BaseFee: 50, PerKgFee: 30}},
+			want:      Quote{Subtotal: 1000, DeliveryFee: 110, Total: 1110},
+			wantLines: 2,
+		},
+		{
+			name: "free delivery threshold counts discount",
+			in: pricingInput{
+				Products:  products,
+				Tariff:    &DeliveryTariff{Type: FlatDeliveryTariff, BaseFee: 150, FreeThreshold: 1000},
+				Discounts: []Discount{{Name: "promo", Amount: 100}},
+			},
+			want:      Quote{Subtotal: 1000, Discount: 100, DeliveryFee: 150, Total: 1050},
+			wantLines: 3,
+		},
+		{
+			name: "free delivery",
+			in: pricingInput{
+				Products: products,
+				Tariff:   &DeliveryTariff{Type: FlatDeliveryTariff, BaseFee: 150, FreeThreshold: 1000},
+			},
+			want:      Quote{Subtotal: 1000, Total: 1000},
+			wantLines: 2,
+		},
+		{
+			name: "discount is capped by subtotal",
+			in: pricingInput{
+				Products:  products,
+				Discounts: []Discount{{Name: "first", Amount: 700}, {Name: "second", Amount: 700}, {Name: "empty", Amount: 0}},
+			},
+			want:      Quote{Subtotal: 1000, Discount: 1000, Total: 0},
+			wantLines: 4,
+		},
+		{
+			name:      "tax on top",
+			in:        pricingInput{Products: products, Tariff: &DeliveryTariff{BaseFee: 100, TaxPercent: 20}},
+			want:      Quote{Subtotal: 1000, DeliveryFee: 100, Tax: 220, Total: 1320},
+			wantLines: 3,
+		},
+		{
+			name:      "tax included",
+			in:        pricingInput{Products: products, Tariff: &DeliveryTariff{BaseFee: 200, TaxPercent: 20, TaxIncluded: true}},
+			want:      Quote{Subtotal: 1000, DeliveryFee: 200, Tax: 200, Total: 1200},
+			wantLines: 3,
+		},
+		{
+			name:      "fee is rounded to cents",
+			in:        pricingInput{Products: products, Tariff: &DeliveryTariff{Type: DistanceDeliveryTariff, PerKmFee: 10}, Distance: 1.2346},
+			want:      Quote{Subtotal: 1000, DeliveryFee: 12.35, Total: 1012.35},
+			wantLines: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := calculateQuote(&tt.in)
+			if err != nil {
+				t.Fatalf("calculateQuote: %v", err)
+			}
+			if q.Subtotal != tt.want.Subtotal || q.Discount != tt.want.Discount || q.DeliveryFee != tt.want.DeliveryFee ||
+				q.Tax != tt.want.Tax || q.Total != tt.want.Total {
+				t.Errorf("quote = %+v, want %+v", *q, tt.want)
+			}
+			if len(q.Lines) != tt.wantLines {
+				t.Errorf("lines = %+v, want %d lines", q.Lines, tt.wantLines)
+			}
+		})
+	}
+}
+
+func TestCalculateQuoteErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   pricingInput
+		err  error
+		code ErrorCode
+	}{
+		{
+			name: "negative distance",
+			in:   pricingInput{Tariff: &DeliveryTariff{Type: DistanceDeliveryTariff}, Distance: -1},
+			err:  errDeliveryDistanceInvalid,
+			code: ErrCodeInvalidRequest,
+		},
+		{
+			name: "unknown tariff type",
+			in:   pricingInput{Tariff: &DeliveryTariff{Type: "zone"}},
+			err:  errDeliveryTariffUnknown,
+			code: ErrCodeInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := calculateQuote(&tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if code := toAPIError(err).Code; code != tt.code {
+				t.Errorf("api error code = %s, want %s", code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package order
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Проверка срока действия, минимальной суммы и лимитов использования промокода
+func validatePromoCode(promo *PromoCode, subtotal float64, userRedemptions int64, now time.Time) error {
+	if promo.ValidFrom != nil && now.Before(*promo.ValidFrom) {
+		return errPromoCodeInactive
+	}
+
+	if promo.ValidTo != nil && now.After(*promo.ValidTo) {
+		return errPromoCodeInactive
+	}
+
+	if subtotal < promo.MinOrderValue {
+		return errPromoCodeMinOrderValue
+	}
+
+	if promo.UsageLimit > 0 && promo.UsedCount >= promo.UsageLimit {
+		return errPromoCodeUsageLimit
+	}
+
+	if promo.PerUserLimit > 0 && userRedemptions >= int64(promo.PerUserLimit) {
+		return errPromoCodeUsageLimit
+	}
+
+	return nil
+}
+
+// Размер скидки по промокоду для товаров из подходящих категорий
+func promoCodeDiscount(promo *PromoCode, products []Products) (Discount, error) {
+	eligible := promoCodeEligibleSubtotal(promo, products)
+	if eligible == 0 {
+		return Discount{}, errPromoCodeNotApplicable
+	}
+
+	var amount float64
+	switch promo.Type {
+	case PercentPromoCode:
+		amount = eligible * promo.Value / 100
+	case FixedPromoCode:
+		amount = promo.Value
+	default:
+		return Discount{}, fmt.Errorf("unknown promo code type - %s", promo.Type)
+	}
+
+	if amount > eligible {
+		amount = eligible
+	}
+
+	return Discount{
+		Name:   fmt.Sprintf("Промокод %s", promo.Code),
+		Amount: roundMoney(amount),
+	}, nil
+}
+
+// Сумма товаров, на которые распространяется промокод
+func promoCodeEligibleSubtotal(promo *PromoCode, products []Products) float64 {
+	categories := make(map[uint]struct{}, len(promo.Categories))
+	for _, category := range promo.Categories {
+		categories[category.ID] = struct{}{}
+	}
+
+	var eligible float64
+	for _, p := range products {
+		if len(categories) > 0 {
+			if p.CategoryID == nil {
+				continue
+			}
+			if _, ok := categories[*p.CategoryID]; !ok {
+				continue
+			}
+		}
+		eligible += float64(p.Price)
+	}
+
+	return eligible
+}
+
+// Распределение скидки между группами пропорционально сумме подходящих товаров.
+// Остаток от округления относится на последнюю группу со скидкой.
+func allocatePromoCodeDiscount(promo *PromoCode, discount Discount, groups []productGroup) []Discount {
+	eligible := make([]float64, len(groups))
+	var total float64
+	last := -1
+	for i, g := range groups {
+		eligible[i] = promoCodeEligibleSubtotal(promo, g.Products)
+		total += eligible[i]
+		if eligible[i] > 0 {
+			last = i
+		}
+	}
+
+	shares := make([]Discount, len(groups))
+	if total == 0 {
+		return shares
+	}
+
+	var allocated float64
+	for i := range groups {
+		if eligible[i] == 0 {
+			continue
+		}
+
+		amount := roundMoney(discount.Amount * eligible[i] / total)
+		if i == last {
+			amount = roundMoney(discount.Amount - allocated)
+		}
+		allocated += amount
+
+		shares[i] = Discount{Name: discount.Name, Amount: amount}
+	}
+
+	return shares
+}
+
+func productsSubtotal(products []Products) float64 {
+	var subtotal float64
+	for _, p := range products {
+		subtotal += float64(p.Price)
+	}
+	return subtotal
+}
@@ -0,0 +1,89 @@
+package order
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestNormalizePromoCode(t *testing.T) {
+	if code := normalizePromoCode("  summer10 "); code != "SUMMER10" {
+		t.Errorf("normalizePromoCode = %q, want %q", code, "SUMMER10")
+	}
+}
+
+func TestValidatePromoCode(t *testing.T) {
+	now := time.Date(2023, 5, 10, 12, 0, 0, 0, time.UTC)
+	before := now.Add(-time.Hour)
+	after := now.Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		promo       PromoCode
+		subtotal    float64
+		redemptions int64
+		err         error
+	}{
+		{name: "no restrictions", promo: PromoCode{}, subtotal: 100},
+		{name: "active period", promo: PromoCode{ValidFrom: &before, ValidTo: &after}, subtotal: 100},
+		{name: "not started", promo: PromoCode{ValidFrom: &after}, subtotal: 100, err: errPromoCodeInactive},
+		{name: "expired", promo: PromoCode{ValidTo: &before}, subtotal: 100, err: errPromoCodeInactive},
+		{name: "below minimum", promo: PromoCode{MinOrderValue: 500}, subtotal: 499.99, err: errPromoCodeMinOrderValue},
+		{name: "exact minimum", promo: PromoCode{MinOrderValue: 500}, subtotal: 500},
+		{name: "usage limit reached", promo: PromoCode{UsageLimit: 10, UsedCount: 10}, subtotal: 100, err: errPromoCodeUsageLimit},
+		{name: "usage limit left", promo: PromoCode{UsageLimit: 10, UsedCount: 9}, subtotal: 100},
+		{name: "per user limit reached", promo: PromoCode{PerUserLimit: 1}, subtotal: 100, redemptions: 1, err: errPromoCodeUsageLimit},
+		{name: "per user limit left", promo: PromoCode{PerUserLimit: 2}, subtotal: 100, redemptions: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePromoCode(&tt.promo, tt.subtotal, tt.redemptions, now)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestPromoCodeDiscount(t *testing.T) {
+	food := uint(1)
+	toys := uint(2)
+	products := []Products{
+		{Price: 300, CategoryID: &food},
+		{Price: 200, CategoryID: &toys},
+		{Price: 100},
+	}
+
+	tests := []struct {
+		name   string
+		promo  PromoCode
+		amount float64
+		err    error
+	}{
+		{name: "percent of whole cart", promo: PromoCode{Type: PercentPromoCode, Value: 10}, amount: 60},
+		{name: "percent of category", promo: PromoCode{Type: PercentPromoCode, Value: 15, Categories: []Category{{Model: gorm.Model{ID: food}}}}, amount: 45},
+		{name: "fixed", promo: PromoCode{Type: FixedPromoCode, Value: 50}, amount: 50},
+		{name: "fixed is capped by eligible subtotal", promo: PromoCode{Type: FixedPromoCode, Value: 500, Categories: []Category{{Model: gorm.Model{ID: toys}}}}, amount: 200},
+		{name: "percent is rounded", promo: PromoCode{Type: PercentPromoCode, Value: 3.333}, amount: 20},
+		{name: "no eligible products", promo: PromoCode{Type: PercentPromoCode, Value: 10, Categories: []Category{{Model: gorm.Model{ID: 3}}}}, err: errPromoCodeNotApplicable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discount, err := promoCodeDiscount(&tt.promo, products)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if discount.Amount != tt.amount {
+				t.Errorf("amount = %v, want %v", discount.Amount, tt.amount)
+			}
+		})
+	}
+
+	if _, err := promoCodeDiscount(&PromoCode{Type: "bonus", Value: 1}, products); err == nil {
+		t.Error("unknown promo code type: expected error")
+	}
+}
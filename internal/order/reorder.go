@@ -0,0 +1,92 @@
+package order
+
+// Режим заполнения корзины при повторном заказе
+const (
+	MergeReorderMode   = "merge"
+	ReplaceReorderMode = "replace"
+)
+
+// Причина недоступности товара при повторном заказе
+const (
+	NotFoundReorderReason   = "not_found"
+	OutOfStockReorderReason = "out_of_stock"
+)
+
+type ReorderUnavailable struct {
+	ProductID uint   `json:"product_id"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+}
+
+type ReorderRepriced struct {
+	ProductID uint   `json:"product_id"`
+	Name      string `json:"name"`
+	OldPrice  uint   `json:"old_price"`
+	NewPrice  uint   `json:"new_price"`
+}
+
+// Результат повторного заказа
+type ReorderReport struct {
+	Mode        string               `json:"mode"`
+	Added       []Products           `json:"added"`
+	Unavailable []ReorderUnavailable `json:"unavailable"`
+	Repriced    []ReorderRepriced    `json:"repriced"`
+	Cart        *Cart                `json:"cart"`
+}
+
+// Позиции прошлого заказа; для заказов без сохранённых цен используются текущие товары
+func reorderItems(order *Order) []OrderItem {
+	if len(order.Items) > 0 {
+		return order.Items
+	}
+
+	return orderItems(order.Products)
+}
+
+func orderItems(products []Products) []OrderItem {
+	items := make([]OrderItem, 0, len(products))
+	for _, p := range products {
+		items = append(items, OrderItem{
+			ProductsID: p.ID,
+			Name:       p.Name,
+			Price:      p.Price,
+		})
+	}
+	return items
+}
+
+// Остатки товаров по складам: товар -> пункт выдачи (0 - общий склад) -> количество
+type stockLevels map[uint]map[uint]uint
+
+func newStockLevels(stocks []Stock) stockLevels {
+	levels := make(stockLevels)
+	for _, stock := range stocks {
+		var addressesID uint
+		if stock.AddressesID != nil {
+			addressesID = *stock.AddressesID
+		}
+
+		if levels[stock.ProductsID] == nil {
+			levels[stock.ProductsID] = make(map[uint]uint)
+		}
+		levels[stock.ProductsID][addressesID] += stock.Quantity
+	}
+	return levels
+}
+
+// Учёт одной единицы товара по тем же правилам, что и резерв: сначала пункт выдачи,
+// затем общий склад. Товары без записи об остатке считаются доступными.
+func (l stockLevels) take(productID uint, addressesID int) bool {
+	product, ok := l[productID]
+	if !ok {
+		return true
+	}
+
+	for _, id := range []uint{uint(addressesID), 0} {
+		if product[id] > 0 {
+			product[id]--
+			return true
+		}
+	}
+	return false
+}
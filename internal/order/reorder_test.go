@@ -0,0 +1,34 @@
+package order
+
+import "testing"
+
+func TestStockLevelsTake(t *testing.T) {
+	point := uint(7)
+	other := uint(8)
+
+	levels := newStockLevels([]Stock{
+		{ProductsID: 1, AddressesID: &point, Quantity: 1},
+		{ProductsID: 1, Quantity: 1},
+		{ProductsID: 1, AddressesID: &other, Quantity: 5},
+		{ProductsID: 2, AddressesID: &other, Quantity: 3},
+	})
+
+	steps := []struct {
+		productID   uint
+		addressesID int
+		ok          bool
+	}{
+		{productID: 1, addressesID: 7, ok: true},  // пункт выдачи
+		{productID: 1, addressesID: 7, ok: true},  // общий склад
+		{productID: 1, addressesID: 7, ok: false}, // остаток другого пункта не учитывается
+		{productID: 2, addressesID: 7, ok: false},
+		{productID: 2, addressesID: 8, ok: true},
+		{productID: 3, addressesID: 7, ok: true}, // без учёта остатков
+	}
+
+	for i, step := range steps {
+		if ok := levels.take(step.productID, step.addressesID); ok != step.ok {
+			t.Errorf("step %d: take(%d, %d) = %v, want %v", i, step.productID, step.addressesID, ok, step.ok)
+		}
+	}
+}
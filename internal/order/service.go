@@ -1,26 +1,52 @@
 package order
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/sirupsen/logrus"
 )
 
 type OrderService interface {
-	CreateOrder(order *Order, schema string) (uint, error)
-	TakeOrderСourier(courierID, orderID uint, schema string) error
-	DeliveredOrderСourier(courierID, orderID uint, schema string) error
-	GetOrdersByUserID(userID uint, schema string) ([]Order, error)
-	GetOrderByID(userId, orderId uint, schema string) (*Order, error)
-	GetOrdersByDeliveryID(deliveryUserID uint, schema string) ([]Order, error)
-	GetUnaxeptedOrderByAddressShopId(addressShopId []uint, schema string) ([]Order, error)
-	UpdateOrderPaymentID(orderID uint, paymentID string, schema string) error
-	GetOrderByPaymentKey(paymentKey string, schema string) (*Order, error)
-	PaymentSuccess(orderID uint, schema string) error
-
-	CreateCart(cart *Cart, schema string) (uint, error)
-	GetCartWithProductsByUserID(userID uint, schema string) (*Cart, error)
-	AddProductToCart(userID uint, product *Products, schema string) error
-	RemoveProductFromCart(userID uint, product *Products, schema string) error
-	ClearCartProducts(userID uint, schema string) error
+	CreateCheckout(ctx context.Context, order *Order, promoCodeID *uint, schema string) (*Checkout, error)
+	TakeOrderСourier(ctx context.Context, courierID, orderID uint, schema string) error
+	DeliveredOrderСourier(ctx context.Context, courierID, orderID uint, collectedAmount *float64, schema string) error
+	GetOrdersByUserID(ctx context.Context, userID uint, schema string) ([]Order, error)
+	GetOrderByID(ctx context.Context, userId, orderId uint, schema string) (*Order, error)
+	GetOrdersByDeliveryID(ctx context.Context, deliveryUserID uint, schema string) ([]Order, error)
+	GetUnaxeptedOrderByAddressShopId(ctx context.Context, addressShopId []uint, schema string) ([]Order, error)
+	UpdateCheckoutPaymentID(ctx context.Context, checkoutID uint, paymentID string, schema string) error
+	RenewCheckoutPayment(ctx context.Context, checkoutID uint, previousPaymentID, reason, paymentKey string, schema string) error
+	GetCheckoutByPaymentKey(ctx context.Context, paymentKey string, schema string) (*Checkout, error)
+	GetCheckoutByPaymentID(ctx context.Context, paymentID string, schema string) (*Checkout, error)
+	PaymentSuccess(ctx context.Context, checkoutID uint, schema string) error
+	PaymentAuthorized(ctx context.Context, checkoutID uint, authorizedUntil *time.Time, schema string) error
+	PaymentCanceled(ctx context.Context, checkoutID uint, schema string) error
+	AcceptOrder(ctx context.Context, orderID uint, schema string) error
+	GetCheckoutByOrderID(ctx context.Context, orderID uint, schema string) (*Checkout, error)
+	GetAuthorizedCheckouts(ctx context.Context, schema string) ([]Checkout, error)
+	GetCourierCashReport(ctx context.Context, courierID *uint, from, to time.Time, schema string) ([]CourierCashReport, error)
+	GetExpiredUnpaidCheckouts(ctx context.Context, before time.Time, schema string) ([]Checkout, error)
+
+	CreateCart(ctx context.Context, cart *Cart, schema string) (uint, error)
+	GetCartWithProductsByUserID(ctx context.Context, userID uint, schema string) (*Cart, error)
+	AddProductToCart(ctx context.Context, userID uint, product *Products, schema string) error
+	RemoveProductFromCart(ctx context.Context, userID uint, product *Products, schema string) error
+	ClearCartProducts(ctx context.Context, userID uint, schema string) error
+	QuoteCart(ctx context.Context, userID uint, request QuoteRequest, schema string) (*CheckoutQuote, error)
+	ApplyPromoCode(ctx context.Context, userID uint, code string, request QuoteRequest, schema string) (*CheckoutQuote, error)
+	RemovePromoCode(ctx context.Context, userID uint, schema string) error
+	Reorder(ctx context.Context, userID, orderID uint, mode string, schema string) (*ReorderReport, error)
+
+	GetTenantSettings(ctx context.Context, schema string) (*TenantSettings, error)
+	UpdateTenantSettings(ctx context.Context, settings *TenantSettings, schema string) error
+}
+
+// Параметры расчёта стоимости корзины (AddressesID - адрес для товаров без пункта выдачи)
+type QuoteRequest struct {
+	AddressesID      int     `json:"addresses_id"`
+	DeliveryDistance float64 `json:"delivery_distance"`
 }
 
 type orderService struct {
@@ -35,73 +61,433 @@ func NewService(storage Storage, log *logrus.Entry) OrderService {
 	}
 }
 
-func (s *orderService) CreateOrder(order *Order, schema string) (uint, error) {
-	newOrder := Order{
-		UserID:           order.UserID,
-		Products:         order.Products,
-		DeliveryAddress:  order.DeliveryAddress,
-		TotalPrice:       order.TotalPrice,
+// Оформление корзины: по заказу на каждый адрес выдачи и общий платёж
+func (s *orderService) CreateCheckout(ctx context.Context, order *Order, promoCodeID *uint, schema string) (*Checkout, error) {
+	if len(order.Products) == 0 {
+		return nil, errCartEmpty
+	}
+
+	var promo *PromoCode
+	if promoCodeID != nil {
+		var err error
+		promo, err = s.storage.GetPromoCodeByID(ctx, *promoCodeID, schema)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cq, err := s.quoteCheckout(ctx, order.UserID, order.Products, promo, QuoteRequest{
 		AddressesID:      order.AddressesID,
-		PaymentKey:       order.PaymentKey,
-		DeliveryStatusID: &WaitingProcessingDelivery,
-		PaymentStatusID:  &WaitingProcessingPayment,
+		DeliveryDistance: order.DeliveryDistance,
+	}, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	checkout := Checkout{
+		UserID:          order.UserID,
+		TotalPrice:      cq.Total,
+		PaymentKey:      order.PaymentKey,
+		PaymentProvider: order.PaymentProvider,
+		CaptureMode:     order.CaptureMode,
+		PaymentMethod:   order.PaymentMethod,
+		PromoCodeID:     promoCodeID,
+	}
+
+	for _, oq := range cq.Orders {
+		checkout.Orders = append(checkout.Orders, Order{
+			UserID:           order.UserID,
+			Products:         oq.Products,
+			DeliveryAddress:  order.DeliveryAddress,
+			TotalPrice:       oq.Total,
+			AddressesID:      oq.AddressesID,
+			PaymentKey:       order.PaymentKey,
+			PaymentProvider:  order.PaymentProvider,
+			CaptureMode:      order.CaptureMode,
+			PaymentMethod:    order.PaymentMethod,
+			DeliveryDistance: order.DeliveryDistance,
+			PriceLines:       oq.Lines,
+			Items:            orderItems(oq.Products),
+		})
+	}
+
+	var redemption *PromoRedemption
+	if promo != nil {
+		redemption = &PromoRedemption{
+			PromoCodeID: promo.ID,
+			UserID:      order.UserID,
+			Amount:      cq.Discount,
+		}
+	}
+
+	_, err = s.storage.CreateCheckout(ctx, &checkout, redemption, schema)
+	if err != nil {
+		if errors.Is(err, errStatusNotFound) {
+			s.logger.Errorf("CreateCheckout: status seed missing in schema %s - %v", schema, err)
+		}
+		return nil, err
+	}
+
+	return &checkout, nil
+}
+
+func (s *orderService) QuoteCart(ctx context.Context, userID uint, request QuoteRequest, schema string) (*CheckoutQuote, error) {
+	cart, err := s.storage.GetCartWithProductsByUserID(ctx, userID, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	if cart == nil || len(cart.Products) == 0 {
+		return nil, errCartEmpty
+	}
+
+	var promo *PromoCode
+	if cart.PromoCodeID != nil {
+		promo, err = s.storage.GetPromoCodeByID(ctx, *cart.PromoCodeID, schema)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.quoteCheckout(ctx, userID, cart.Products, promo, request, schema)
+}
+
+func (s *orderService) ApplyPromoCode(ctx context.Context, userID uint, code string, request QuoteRequest, schema string) (*CheckoutQuote, error) {
+	cart, err := s.storage.GetCartWithProductsByUserID(ctx, userID, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	if cart == nil || len(cart.Products) == 0 {
+		return nil, errCartEmpty
 	}
 
-	return s.storage.CreateOrder(&newOrder, schema)
+	promo, err := s.storage.GetPromoCode(ctx, normalizePromoCode(code), schema)
+	if err != nil {
+		return nil, err
+	}
+
+	cq, err := s.quoteCheckout(ctx, userID, cart.Products, promo, request, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.storage.SetCartPromoCode(ctx, userID, &promo.ID, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return cq, nil
 }
 
-func (s *orderService) TakeOrderСourier(courierID, orderID uint, schema string) error {
-	return s.storage.TakeOrderСourier(courierID, orderID, schema)
+func (s *orderService) RemovePromoCode(ctx context.Context, userID uint, schema string) error {
+	return s.storage.SetCartPromoCode(ctx, userID, nil, schema)
 }
 
-func (s *orderService) DeliveredOrderСourier(courierID, orderID uint, schema string) error {
-	return s.storage.DeliveredOrderСourier(courierID, orderID, schema)
+// Проверка промокода для пользователя и расчёт скидки
+func (s *orderService) promoCodeDiscount(ctx context.Context, userID uint, promo *PromoCode, products []Products, schema string) (Discount, error) {
+	used, err := s.storage.CountPromoRedemptions(ctx, promo.ID, userID, schema)
+	if err != nil {
+		return Discount{}, err
+	}
+
+	err = validatePromoCode(promo, productsSubtotal(products), used, time.Now())
+	if err != nil {
+		return Discount{}, err
+	}
+
+	return promoCodeDiscount(promo, products)
 }
 
-func (s *orderService) GetOrdersByUserID(userID uint, schema string) ([]Order, error) {
-	return s.storage.GetOrdersByUserID(userID, schema)
+// Расчёт стоимости корзины по заказам адресов выдачи; скидка по промокоду
+// считается от всей корзины и распределяется между заказами
+func (s *orderService) quoteCheckout(ctx context.Context, userID uint, products []Products, promo *PromoCode, request QuoteRequest, schema string) (*CheckoutQuote, error) {
+	groups := splitByAddress(products, request.AddressesID)
+
+	discounts := make([]Discount, len(groups))
+	if promo != nil {
+		discount, err := s.promoCodeDiscount(ctx, userID, promo, products, schema)
+		if err != nil {
+			return nil, err
+		}
+		discounts = allocatePromoCodeDiscount(promo, discount, groups)
+	}
+
+	cq := &CheckoutQuote{}
+	for i, group := range groups {
+		q, err := s.quote(ctx, group.Products, QuoteRequest{
+			AddressesID:      group.AddressesID,
+			DeliveryDistance: request.DeliveryDistance,
+		}, []Discount{discounts[i]}, schema)
+		if err != nil {
+			return nil, err
+		}
+		cq.add(group, q)
+	}
+
+	return cq, nil
+}
+
+// Расчёт стоимости набора товаров по тарифу магазина адреса выдачи
+func (s *orderService) quote(ctx context.Context, products []Products, request QuoteRequest, discounts []Discount, schema string) (*Quote, error) {
+	tariff, err := s.storage.GetDeliveryTariff(ctx, request.AddressesID, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := calculateQuote(&pricingInput{
+		Products:  products,
+		Tariff:    tariff,
+		Distance:  request.DeliveryDistance,
+		Discounts: discounts,
+	})
+	if errors.Is(err, errDeliveryTariffUnknown) {
+		s.logger.Errorf("quote: misconfigured delivery tariff for address %d in schema %s - %v", request.AddressesID, schema, err)
+	}
+	return q, err
+}
+
+func (s *orderService) TakeOrderСourier(ctx context.Context, courierID, orderID uint, schema string) error {
+	return s.storage.TakeOrderСourier(ctx, courierID, orderID, schema)
+}
+
+func (s *orderService) DeliveredOrderСourier(ctx context.Context, courierID, orderID uint, collectedAmount *float64, schema string) error {
+	if collectedAmount != nil {
+		amount := roundMoney(*collectedAmount)
+		collectedAmount = &amount
+	}
+	return s.storage.DeliveredOrderСourier(ctx, courierID, orderID, collectedAmount, schema)
+}
+
+// Сверка курьеров (courierID == nil - все курьеры) за период [from, to)
+func (s *orderService) GetCourierCashReport(ctx context.Context, courierID *uint, from, to time.Time, schema string) ([]CourierCashReport, error) {
+	reports, err := s.storage.GetCourierCashReport(ctx, courierID, from, to, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range reports {
+		r := &reports[i]
+		r.Expected = roundMoney(r.Expected)
+		r.Collected = roundMoney(r.Collected)
+		r.Cash = roundMoney(r.Cash)
+		r.Card = roundMoney(r.Card)
+		r.Difference = roundMoney(r.Collected - r.Expected)
+	}
+
+	return reports, nil
 }
 
-func (s *orderService) GetOrderByID(userId, orderId uint, schema string) (*Order, error) {
-	return s.storage.GetOrderByID(userId, orderId, schema)
+func (s *orderService) GetOrdersByUserID(ctx context.Context, userID uint, schema string) ([]Order, error) {
+	return s.storage.GetOrdersByUserID(ctx, userID, schema)
 }
 
-func (s *orderService) GetOrdersByDeliveryID(deliveryUserID uint, schema string) ([]Order, error) {
-	return s.storage.GetOrdersByDeliveryID(deliveryUserID, schema)
+func (s *orderService) GetOrderByID(ctx context.Context, userId, orderId uint, schema string) (*Order, error) {
+	return s.storage.GetOrderByID(ctx, userId, orderId, schema)
 }
 
-func (s *orderService) GetUnaxeptedOrderByAddressShopId(addressShopId []uint, schema string) ([]Order, error) {
-	return s.storage.GetUnaxeptedOrderByAddressShopId(addressShopId, schema)
+func (s *orderService) GetOrdersByDeliveryID(ctx context.Context, deliveryUserID uint, schema string) ([]Order, error) {
+	return s.storage.GetOrdersByDeliveryID(ctx, deliveryUserID, schema)
 }
 
-func (s *orderService) UpdateOrderPaymentID(orderID uint, paymentID string, schema string) error {
-	return s.storage.UpdateOrderPaymentID(orderID, paymentID, schema)
+func (s *orderService) GetUnaxeptedOrderByAddressShopId(ctx context.Context, addressShopId []uint, schema string) ([]Order, error) {
+	return s.storage.GetUnaxeptedOrderByAddressShopId(ctx, addressShopId, schema)
 }
 
-func (s *orderService) GetOrderByPaymentKey(paymentKey string, schema string) (*Order, error) {
-	return s.storage.GetOrderByPaymentKey(paymentKey, schema)
+func (s *orderService) UpdateCheckoutPaymentID(ctx context.Context, checkoutID uint, paymentID string, schema string) error {
+	return s.storage.UpdateCheckoutPaymentID(ctx, checkoutID, paymentID, schema)
 }
 
-func (s *orderService) PaymentSuccess(orderID uint, schema string) error {
-	return s.storage.PaymentSuccess(orderID, schema)
+func (s *orderService) RenewCheckoutPayment(ctx context.Context, checkoutID uint, previousPaymentID, reason, paymentKey string, schema string) error {
+	return s.storage.RenewCheckoutPayment(ctx, checkoutID, previousPaymentID, reason, paymentKey, schema)
 }
 
-func (s *orderService) CreateCart(cart *Cart, schema string) (uint, error) {
-	return s.storage.CreateCart(cart, schema)
+func (s *orderService) GetCheckoutByPaymentKey(ctx context.Context, paymentKey string, schema string) (*Checkout, error) {
+	return s.storage.GetCheckoutByPaymentKey(ctx, paymentKey, schema)
 }
 
-func (s *orderService) GetCartWithProductsByUserID(userID uint, schema string) (*Cart, error) {
-	return s.storage.GetCartWithProductsByUserID(userID, schema)
+func (s *orderService) GetCheckoutByPaymentID(ctx context.Context, paymentID string, schema string) (*Checkout, error) {
+	return s.storage.GetCheckoutByPaymentID(ctx, paymentID, schema)
 }
 
-func (s *orderService) AddProductToCart(userID uint, product *Products, schema string) error {
-	return s.storage.AddProductToCart(userID, product, schema)
+// Оплата отменённого оформления пишется в лог: средства возвращаются вручную
+func (s *orderService) PaymentSuccess(ctx context.Context, checkoutID uint, schema string) error {
+	err := s.storage.PaymentSuccess(ctx, checkoutID, schema)
+	if errors.Is(err, errPaymentStatusConflict) {
+		s.logger.Errorf("PaymentSuccess: payment succeeded for checkout %d in schema %s - %v", checkoutID, schema, err)
+	}
+	return err
+}
+
+func (s *orderService) PaymentAuthorized(ctx context.Context, checkoutID uint, authorizedUntil *time.Time, schema string) error {
+	return s.storage.PaymentAuthorized(ctx, checkoutID, authorizedUntil, schema)
+}
+
+func (s *orderService) PaymentCanceled(ctx context.Context, checkoutID uint, schema string) error {
+	return s.storage.PaymentCanceled(ctx, checkoutID, schema)
+}
+
+func (s *orderService) AcceptOrder(ctx context.Context, orderID uint, schema string) error {
+	return s.storage.AcceptOrder(ctx, orderID, schema)
+}
+
+func (s *orderService) GetCheckoutByOrderID(ctx context.Context, orderID uint, schema string) (*Checkout, error) {
+	return s.storage.GetCheckoutByOrderID(ctx, orderID, schema)
+}
+
+func (s *orderService) GetAuthorizedCheckouts(ctx context.Context, schema string) ([]Checkout, error) {
+	return s.storage.GetAuthorizedCheckouts(ctx, schema)
+}
+
+func (s *orderService) GetExpiredUnpaidCheckouts(ctx context.Context, before time.Time, schema string) ([]Checkout, error) {
+	return s.storage.GetExpiredUnpaidCheckouts(ctx, before, schema)
+}
+
+func (s *orderService) CreateCart(ctx context.Context, cart *Cart, schema string) (uint, error) {
+	return s.storage.CreateCart(ctx, cart, schema)
+}
+
+func (s *orderService) GetCartWithProductsByUserID(ctx context.Context, userID uint, schema string) (*Cart, error) {
+	return s.storage.GetCartWithProductsByUserID(ctx, userID, schema)
+}
+
+func (s *orderService) AddProductToCart(ctx context.Context, userID uint, product *Products, schema string) error {
+	cart, err := s.storage.GetCartWithProductsByUserID(ctx, userID, schema)
+	if err != nil {
+		return err
+	}
+
+	products := []Products{*product}
+	if cart != nil {
+		for _, p := range cart.Products {
+			if p.ID != product.ID {
+				products = append(products, p)
+			}
+		}
+	}
+
+	err = s.storage.CheckStock(ctx, products, schema)
+	if err != nil {
+		return err
+	}
+
+	return s.storage.AddProductToCart(ctx, userID, product, schema)
+}
+
+func (s *orderService) RemoveProductFromCart(ctx context.Context, userID uint, product *Products, schema string) error {
+	return s.storage.RemoveProductFromCart(ctx, userID, product, schema)
+}
+
+func (s *orderService) ClearCartProducts(ctx context.Context, userID uint, schema string) error {
+	return s.storage.ClearCartProducts(ctx, userID, schema)
+}
+
+// Заполнение корзины товарами прошлого заказа с отчётом о недоступных и подорожавших товарах
+func (s *orderService) Reorder(ctx context.Context, userID, orderID uint, mode string, schema string) (*ReorderReport, error) {
+	if mode == "" {
+		mode = MergeReorderMode
+	}
+
+	if mode != MergeReorderMode && mode != ReplaceReorderMode {
+		return nil, errUnknownReorderMode
+	}
+
+	order, err := s.storage.GetOrderByID(ctx, userID, orderID, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	items := reorderItems(order)
+
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ProductsID)
+	}
+
+	products, err := s.storage.GetProductsByIDs(ctx, ids, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[uint]Products, len(products))
+	for _, p := range products {
+		current[p.ID] = p
+	}
+
+	stocks, err := s.storage.GetStocksByProductIDs(ctx, ids, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := newStockLevels(stocks)
+
+	report := &ReorderReport{Mode: mode}
+	for _, item := range items {
+		product, ok := current[item.ProductsID]
+		if !ok {
+			report.Unavailable = append(report.Unavailable, ReorderUnavailable{
+				ProductID: item.ProductsID,
+				Name:      item.Name,
+				Reason:    NotFoundReorderReason,
+			})
+			continue
+		}
+
+		addressesID := order.AddressesID
+		if product.AddressesID != nil {
+			addressesID = int(*product.AddressesID)
+		}
+
+		if !levels.take(product.ID, addressesID) {
+			report.Unavailable = append(report.Unavailable, ReorderUnavailable{
+				ProductID: product.ID,
+				Name:      product.Name,
+				Reason:    OutOfStockReorderReason,
+			})
+			continue
+		}
+
+		if product.Price != item.Price {
+			report.Repriced = append(report.Repriced, ReorderRepriced{
+				ProductID: product.ID,
+				Name:      product.Name,
+				OldPrice:  item.Price,
+				NewPrice:  product.Price,
+			})
+		}
+
+		report.Added = append(report.Added, product)
+	}
+
+	cart, err := s.storage.GetCartWithProductsByUserID(ctx, userID, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	if cart == nil {
+		cart = &Cart{UserID: userID}
+		if _, err := s.storage.CreateCart(ctx, cart, schema); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.storage.FillCart(ctx, userID, report.Added, mode == ReplaceReorderMode, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Cart, err = s.storage.GetCartWithProductsByUserID(ctx, userID, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
 }
 
-func (s *orderService) RemoveProductFromCart(userID uint, product *Products, schema string) error {
-	return s.storage.RemoveProductFromCart(userID, product, schema)
+func (s *orderService) GetTenantSettings(ctx context.Context, schema string) (*TenantSettings, error) {
+	return s.storage.GetTenantSettings(ctx, schema)
 }
 
-func (s *orderService) ClearCartProducts(userID uint, schema string) error {
-	return s.storage.ClearCartProducts(userID, schema)
+func (s *orderService) UpdateTenantSettings(ctx context.Context, settings *TenantSettings, schema string) error {
+	return s.storage.UpdateTenantSettings(ctx, settings, schema)
 }
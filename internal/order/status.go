@@ -0,0 +1,141 @@
+package order
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Обязательные записи справочников статусов
+var deliveryStatusSeed = []DeliveryStatus{
+	{Code: WaitingProcessing, Name: "Ожидание обработки"},
+	{Code: ProcessOfDelivery, Name: "В процессе доставки"},
+	{Code: DeliveredDelivery, Name: "Доставлено"},
+	{Code: WaitingProcessingDelivery, Name: "Ожидание оплаты"},
+}
+
+var paymentStatusSeed = []PaymentStatus{
+	{Code: WaitingProcessingPayment, Name: "Ожидание оплаты"},
+	{Code: PaidPayment, Name: "Оплачено"},
+	{Code: CanceledPayment, Name: "Отменено"},
+	{Code: AuthorizedPayment, Name: "Средства удержаны"},
+	{Code: PayOnDeliveryPayment, Name: "Оплата при получении"},
+}
+
+const defaultLocale = "ru"
+
+// Названия статусов по локали; при отсутствии перевода используется Name из справочника
+var deliveryStatusNames = map[string]map[string]string{
+	"ru": {
+		WaitingProcessing:         "Ожидание обработки",
+		ProcessOfDelivery:         "В процессе доставки",
+		DeliveredDelivery:         "Доставлено",
+		WaitingProcessingDelivery: "Ожидание оплаты",
+	},
+	"en": {
+		WaitingProcessing:         "Waiting for processing",
+		ProcessOfDelivery:         "Out for delivery",
+		DeliveredDelivery:         "Delivered",
+		WaitingProcessingDelivery: "Waiting for payment",
+	},
+}
+
+var paymentStatusNames = map[string]map[string]string{
+	"ru": {
+		WaitingProcessingPayment: "Ожидание оплаты",
+		PaidPayment:              "Оплачено",
+		CanceledPayment:          "Отменено",
+		AuthorizedPayment:        "Средства удержаны",
+		PayOnDeliveryPayment:     "Оплата при получении",
+	},
+	"en": {
+		WaitingProcessingPayment: "Waiting for payment",
+		PaidPayment:              "Paid",
+		CanceledPayment:          "Canceled",
+		AuthorizedPayment:        "Authorized",
+		PayOnDeliveryPayment:     "Pay on delivery",
+	},
+}
+
+// Подзапрос id статуса доставки по коду
+func deliveryStatusID(db *gorm.DB, code string) interface{} {
+	return gorm.Expr("(?)", db.Model(&DeliveryStatus{}).Select("id").Where("code = ?", code).Limit(1))
+}
+
+// Подзапрос id статуса оплаты по коду
+func paymentStatusID(db *gorm.DB, code string) interface{} {
+	return gorm.Expr("(?)", db.Model(&PaymentStatus{}).Select("id").Where("code = ?", code).Limit(1))
+}
+
+// Поиск id статуса по коду для создания записей
+func findStatusID(db *gorm.DB, model interface{}, code string) (*uint, error) {
+	var ids []uint
+	err := db.Model(model).Where("code = ?", code).Limit(1).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: %s", errStatusNotFound, code)
+	}
+
+	return &ids[0], nil
+}
+
+// Локаль из заголовка Accept-Language (первый язык)
+func parseLocale(acceptLanguage string) string {
+	tag := strings.TrimSpace(strings.Split(acceptLanguage, ",")[0])
+	tag = strings.ToLower(strings.Split(strings.Split(tag, ";")[0], "-")[0])
+	if _, ok := deliveryStatusNames[tag]; ok {
+		return tag
+	}
+	return defaultLocale
+}
+
+func localizedStatusName(names map[string]map[string]string, locale, code, fallback string) string {
+	if name, ok := names[locale][code]; ok {
+		return name
+	}
+	return fallback
+}
+
+type StatusView struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// Заказ с локализованными статусами для ответа
+type OrderResponse struct {
+	Order
+	DeliveryStatus *StatusView `json:"delivery_status"`
+	PaymentStatus  *StatusView `json:"payment_status"`
+}
+
+func newOrderResponse(order *Order, locale string) OrderResponse {
+	response := OrderResponse{Order: *order}
+
+	if order.DeliveryStatus.ID != 0 {
+		response.DeliveryStatus = &StatusView{
+			Code: order.DeliveryStatus.Code,
+			Name: localizedStatusName(deliveryStatusNames, locale, order.DeliveryStatus.Code, order.DeliveryStatus.Name),
+		}
+	}
+
+	if order.PaymentStatus.ID != 0 {
+		response.PaymentStatus = &StatusView{
+			Code: order.PaymentStatus.Code,
+			Name: localizedStatusName(paymentStatusNames, locale, order.PaymentStatus.Code, order.PaymentStatus.Name),
+		}
+	}
+
+	return response
+}
+
+func newOrderResponses(orders []Order, locale string) []OrderResponse {
+	responses := make([]OrderResponse, 0, len(orders))
+	for i := range orders {
+		responses = append(responses, newOrderResponse(&orders[i], locale))
+	}
+	return responses
+}
@@ -1,89 +1,711 @@
 package order
 
 import (
+	"context"
 	"errors"
+	"sort"
+	"time"
 
 	"github.com/mserebryaakov/aggregator-order-service/pkg/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Storage interface {
-	CreateOrder(order *Order, schema string) (uint, error)
-	TakeOrderСourier(courierID uint, orderID uint, schema string) error
-	DeliveredOrderСourier(courierID uint, orderID uint, schema string) error
-	GetOrdersByUserID(userID uint, schema string) ([]Order, error)
-	GetOrderByID(userId, orderID uint, schema string) (*Order, error)
-	GetOrdersByDeliveryID(deliveryUserID uint, schema string) ([]Order, error)
-	GetUnaxeptedOrderByAddressShopId(addressShopId []uint, schema string) ([]Order, error)
-	UpdateOrderPaymentID(orderID uint, paymentID string, schema string) error
-	GetOrderByPaymentKey(paymentKey string, schema string) (*Order, error)
+	CreateCheckout(ctx context.Context, checkout *Checkout, redemption *PromoRedemption, schema string) (uint, error)
+	TakeOrderСourier(ctx context.Context, courierID uint, orderID uint, schema string) error
+	DeliveredOrderСourier(ctx context.Context, courierID uint, orderID uint, collectedAmount *float64, schema string) error
+	GetOrdersByUserID(ctx context.Context, userID uint, schema string) ([]Order, error)
+	GetOrderByID(ctx context.Context, userId, orderID uint, schema string) (*Order, error)
+	GetOrdersByDeliveryID(ctx context.Context, deliveryUserID uint, schema string) ([]Order, error)
+	GetUnaxeptedOrderByAddressShopId(ctx context.Context, addressShopId []uint, schema string) ([]Order, error)
+	UpdateCheckoutPaymentID(ctx context.Context, checkoutID uint, paymentID string, schema string) error
+	RenewCheckoutPayment(ctx context.Context, checkoutID uint, previousPaymentID, reason, paymentKey string, schema string) error
+	GetCheckoutByPaymentKey(ctx context.Context, paymentKey string, schema string) (*Checkout, error)
+	GetCheckoutByPaymentID(ctx context.Context, paymentID string, schema string) (*Checkout, error)
+	GetExpiredUnpaidCheckouts(ctx context.Context, before time.Time, schema string) ([]Checkout, error)
 
-	CreateCart(cart *Cart, schema string) (uint, error)
-	GetCartWithProductsByUserID(userID uint, schema string) (*Cart, error)
-	AddProductToCart(userID uint, product *Products, schema string) error
-	RemoveProductFromCart(userID uint, product *Products, schema string) error
-	ClearCartProducts(userID uint, schema string) error
+	CreateCart(ctx context.Context, cart *Cart, schema string) (uint, error)
+	GetCartWithProductsByUserID(ctx context.Context, userID uint, schema string) (*Cart, error)
+	AddProductToCart(ctx context.Context, userID uint, product *Products, schema string) error
+	RemoveProductFromCart(ctx context.Context, userID uint, product *Products, schema string) error
+	ClearCartProducts(ctx context.Context, userID uint, schema string) error
+	FillCart(ctx context.Context, userID uint, products []Products, replace bool, schema string) error
+	GetProductsByIDs(ctx context.Context, ids []uint, schema string) ([]Products, error)
+	GetStocksByProductIDs(ctx context.Context, productIDs []uint, schema string) ([]Stock, error)
 
-	PaymentSuccess(orderID uint, schema string) error
+	EnsureStatuses(ctx context.Context, schema string) error
+
+	GetTenantSettings(ctx context.Context, schema string) (*TenantSettings, error)
+	UpdateTenantSettings(ctx context.Context, settings *TenantSettings, schema string) error
+
+	PaymentSuccess(ctx context.Context, checkoutID uint, schema string) error
+	PaymentAuthorized(ctx context.Context, checkoutID uint, authorizedUntil *time.Time, schema string) error
+	PaymentCanceled(ctx context.Context, checkoutID uint, schema string) error
+	AcceptOrder(ctx context.Context, orderID uint, schema string) error
+	GetCheckoutByOrderID(ctx context.Context, orderID uint, schema string) (*Checkout, error)
+	GetAuthorizedCheckouts(ctx context.Context, schema string) ([]Checkout, error)
+	GetCourierCashReport(ctx context.Context, courierID *uint, from, to time.Time, schema string) ([]CourierCashReport, error)
+	CheckStock(ctx context.Context, products []Products, schema string) error
+
+	GetDeliveryTariff(ctx context.Context, addressesID int, schema string) (*DeliveryTariff, error)
+
+	GetPromoCode(ctx context.Context, code string, schema string) (*PromoCode, error)
+	GetPromoCodeByID(ctx context.Context, promoCodeID uint, schema string) (*PromoCode, error)
+	CountPromoRedemptions(ctx context.Context, promoCodeID, userID uint, schema string) (int64, error)
+	SetCartPromoCode(ctx context.Context, userID uint, promoCodeID *uint, schema string) error
 }
 
 type OrderStorage struct {
-	scp *postgres.SchemaConnectionPool
+	scp     *postgres.SchemaConnectionPool
+	timeout time.Duration
 }
 
-func NewStorage(scp *postgres.SchemaConnectionPool) Storage {
+func NewStorage(scp *postgres.SchemaConnectionPool, timeout time.Duration) Storage {
 	return &OrderStorage{
-		scp: scp,
+		scp:     scp,
+		timeout: timeout,
 	}
 }
 
-func (s *OrderStorage) withConnectionPool(fn func(db *gorm.DB) error, schema string) error {
+// Выполнение запросов в схеме тенанта с контекстом запроса и ограничением времени
+func (s *OrderStorage) withConnectionPool(ctx context.Context, fn func(db *gorm.DB) error, schema string) error {
 	db, err := s.scp.GetConnectionPool(schema)
 	if err != nil {
 		return err
 	}
-	return fn(db)
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	return fn(db.WithContext(ctx))
 }
 
-func (s *OrderStorage) CreateOrder(order *Order, schema string) (uint, error) {
-	err := s.withConnectionPool(func(db *gorm.DB) error {
-		return db.Create(&order).Error
+// Создание оформления с заказами в статусе ожидания оплаты (при оплате при получении -
+// сразу в обработке); резерв товаров и использование промокода фиксируются в той же транзакции
+func (s *OrderStorage) CreateCheckout(ctx context.Context, checkout *Checkout, redemption *PromoRedemption, schema string) (uint, error) {
+	paymentCode, deliveryCode := WaitingProcessingPayment, WaitingProcessingDelivery
+	if payOnDelivery(checkout.PaymentMethod) {
+		paymentCode, deliveryCode = PayOnDeliveryPayment, WaitingProcessing
+	}
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			waitingPayment, err := findStatusID(tx, &PaymentStatus{}, paymentCode)
+			if err != nil {
+				return err
+			}
+
+			waitingDelivery, err := findStatusID(tx, &DeliveryStatus{}, deliveryCode)
+			if err != nil {
+				return err
+			}
+
+			var promo PromoCode
+			if redemption != nil {
+				if err := lockPromoCode(tx, redemption, &promo); err != nil {
+					return err
+				}
+			}
+
+			checkout.PaymentStatusID = waitingPayment
+			if err := tx.Omit("Orders").Create(checkout).Error; err != nil {
+				return err
+			}
+
+			for i := range checkout.Orders {
+				order := &checkout.Orders[i]
+				order.CheckoutID = &checkout.ID
+				order.DeliveryStatusID = waitingDelivery
+				order.PaymentStatusID = waitingPayment
+
+				if err := tx.Create(order).Error; err != nil {
+					return err
+				}
+
+				if err := reserveStock(tx, order); err != nil {
+					return err
+				}
+			}
+
+			if redemption == nil {
+				return nil
+			}
+
+			redemption.CheckoutID = checkout.ID
+			if err := tx.Create(redemption).Error; err != nil {
+				return err
+			}
+
+			err = tx.Model(&promo).Update("used_count", gorm.Expr("used_count + 1")).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Model(&Cart{}).Where("user_id = ?", redemption.UserID).Update("promo_code_id", nil).Error
+		})
 	}, schema)
 
 	if err != nil {
 		return 0, err
 	}
 
-	return order.ID, nil
+	return checkout.ID, nil
+}
+
+// Блокировка промокода и повторная проверка лимитов внутри транзакции
+func lockPromoCode(tx *gorm.DB, redemption *PromoRedemption, promo *PromoCode) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(promo, redemption.PromoCodeID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errPromoCodeNotFound
+		}
+		return err
+	}
+
+	if promo.UsageLimit > 0 && promo.UsedCount >= promo.UsageLimit {
+		return errPromoCodeUsageLimit
+	}
+
+	if promo.PerUserLimit > 0 {
+		var used int64
+		err = tx.Model(&PromoRedemption{}).Where("promo_code_id = ? AND user_id = ?", promo.ID, redemption.UserID).Count(&used).Error
+		if err != nil {
+			return err
+		}
+		if used >= int64(promo.PerUserLimit) {
+			return errPromoCodeUsageLimit
+		}
+	}
+
+	return nil
+}
+
+// Резерв остатков под товары заказа. Товары без записи об остатке не учитываются.
+// Доступное количество - сумма остатка пункта выдачи заказа и общего склада;
+// списывается сначала остаток пункта выдачи, недостающее - с общего склада.
+func reserveStock(tx *gorm.DB, order *Order) error {
+	names := productNames(order.Products)
+
+	ids := make([]uint, 0, len(names))
+	for id := range names {
+		ids = append(ids, id)
+	}
+
+	// Строки блокируются в одном порядке, чтобы параллельные оформления не взаимоблокировались
+	var stocks []Stock
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("products_id IN ? AND (addresses_id = ? OR addresses_id IS NULL)", ids, order.AddressesID).
+		Order("products_id, id").
+		Find(&stocks).Error
+	if err != nil {
+		return err
+	}
+
+	byProduct := make(map[uint][]Stock, len(ids))
+	for _, stock := range stocks {
+		byProduct[stock.ProductsID] = append(byProduct[stock.ProductsID], stock)
+	}
+
+	var outOfStock []OutOfStockProduct
+	for _, productID := range ids {
+		productStocks, ok := byProduct[productID]
+		if !ok {
+			continue
+		}
+
+		allocations, available := allocateStock(productStocks, productUnitQuantity)
+		if allocations == nil {
+			outOfStock = append(outOfStock, OutOfStockProduct{
+				ProductID: productID,
+				Name:      names[productID],
+				Requested: productUnitQuantity,
+				Available: available,
+			})
+			continue
+		}
+
+		for _, a := range allocations {
+			err = tx.Model(&Stock{}).Where("id = ?", a.StockID).Updates(map[string]interface{}{
+				"quantity": gorm.Expr("quantity - ?", a.Quantity),
+				"reserved": gorm.Expr("reserved + ?", a.Quantity),
+			}).Error
+			if err != nil {
+				return err
+			}
+
+			err = tx.Create(&StockReservation{
+				OrderID:  order.ID,
+				StockID:  a.StockID,
+				Quantity: a.Quantity,
+				Status:   ReservedStockReservation,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(outOfStock) > 0 {
+		return &OutOfStockError{Products: outOfStock}
+	}
+
+	return nil
+}
+
+type stockAllocation struct {
+	StockID  uint
+	Quantity uint
+}
+
+// Распределение количества по остаткам товара: сначала пункт выдачи, затем общий склад.
+// При нехватке возвращает nil и суммарно доступное количество.
+func allocateStock(stocks []Stock, quantity uint) ([]stockAllocation, uint) {
+	ordered := make([]Stock, len(stocks))
+	copy(ordered, stocks)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].AddressesID != nil && ordered[j].AddressesID == nil
+	})
+
+	var available uint
+	for _, stock := range ordered {
+		available += stock.Quantity
+	}
+
+	if available < quantity {
+		return nil, available
+	}
+
+	allocations := make([]stockAllocation, 0, len(ordered))
+	left := quantity
+	for _, stock := range ordered {
+		if left == 0 {
+			break
+		}
+		if stock.Quantity == 0 {
+			continue
+		}
+
+		take := stock.Quantity
+		if take > left {
+			take = left
+		}
+
+		allocations = append(allocations, stockAllocation{StockID: stock.ID, Quantity: take})
+		left -= take
+	}
+
+	return allocations, available
+}
+
+func finishStockReservations(tx *gorm.DB, checkoutID uint, status string) error {
+	return finishOrderStockReservations(tx, tx.Model(&Order{}).Select("id").Where("checkout_id = ?", checkoutID), status)
+}
+
+// Снятие резервов заказов (orderIDs - список или подзапрос id): released - возврат в остаток,
+// committed - списание проданного
+func finishOrderStockReservations(tx *gorm.DB, orderIDs interface{}, status string) error {
+	var reservations []StockReservation
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id IN (?) AND status = ?", orderIDs, ReservedStockReservation).
+		Find(&reservations).Error
+	if err != nil {
+		return err
+	}
+
+	for _, r := range reservations {
+		updates := map[string]interface{}{
+			"reserved": gorm.Expr("reserved - ?", r.Quantity),
+		}
+		if status == ReleasedStockReservation {
+			updates["quantity"] = gorm.Expr("quantity + ?", r.Quantity)
+		}
+
+		err = tx.Model(&Stock{}).Where("id = ?", r.StockID).Updates(updates).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&r).Update("status", status).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (s *OrderStorage) DeliveredOrderСourier(courierID uint, orderID uint, schema string) error {
-	err := s.withConnectionPool(func(db *gorm.DB) error {
-		result := db.Model(&Order{}).Where("id = ? AND courier_id = ?", orderID, courierID).Update("delivery_status_id", DeliveredDelivery)
+// Товар входит в корзину и заказ не более одного раза (many2many), под него резервируется одна единица
+const productUnitQuantity uint = 1
+
+// Названия товаров по идентификаторам
+func productNames(products []Products) map[uint]string {
+	names := make(map[uint]string, len(products))
+	for _, p := range products {
+		names[p.ID] = p.Name
+	}
+	return names
+}
+
+// Доставка заказа курьером. При оплате при получении фиксируется полученная сумма,
+// заказ считается оплаченным и проданные товары списываются из резерва.
+func (s *OrderStorage) DeliveredOrderСourier(ctx context.Context, courierID uint, orderID uint, collectedAmount *float64, schema string) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var order Order
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("id = ? AND courier_id = ?", orderID, courierID).Limit(1).Find(&order).Error
+			if err != nil {
+				return err
+			}
+
+			if order.ID == 0 {
+				return errOrderWithCourierNotFound
+			}
+
+			if !payOnDelivery(order.PaymentMethod) {
+				return tx.Model(&order).Update("delivery_status_id", deliveryStatusID(tx, DeliveredDelivery)).Error
+			}
+
+			if collectedAmount == nil {
+				return errCollectedAmountRequired
+			}
+
+			err = tx.Model(&order).Updates(map[string]interface{}{
+				"delivery_status_id": deliveryStatusID(tx, DeliveredDelivery),
+				"payment_status_id":  paymentStatusID(tx, PaidPayment),
+				"collected_amount":   *collectedAmount,
+				"collected_at":       time.Now(),
+			}).Error
+			if err != nil {
+				return err
+			}
+
+			if err := finishOrderStockReservations(tx, []uint{order.ID}, CommittedStockReservation); err != nil {
+				return err
+			}
+
+			if order.CheckoutID == nil {
+				return nil
+			}
+
+			// Оформление оплачено, когда оплачены все его заказы
+			unpaid := tx.Model(&Order{}).Select("1").
+				Where("checkout_id = ? AND payment_status_id = ?", *order.CheckoutID, paymentStatusID(tx, PayOnDeliveryPayment))
+			return tx.Model(&Checkout{}).Where("id = ? AND NOT EXISTS (?)", *order.CheckoutID, unpaid).
+				Update("payment_status_id", paymentStatusID(tx, PaidPayment)).Error
+		})
+	}, schema)
+
+	return err
+}
+
+// Оплата оформления: переводятся только оформления, ожидающие оплаты или с удержанными средствами.
+// Повторное уведомление об оплате ничего не меняет; оплата отменённого оформления - errPaymentStatusConflict.
+func (s *OrderStorage) PaymentSuccess(ctx context.Context, checkoutID uint, schema string) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&Checkout{}).
+				Where("id = ? AND payment_status_id IN (?, ?)", checkoutID,
+					paymentStatusID(tx, WaitingProcessingPayment), paymentStatusID(tx, AuthorizedPayment)).
+				Update("payment_status_id", paymentStatusID(tx, PaidPayment))
+			if result.Error != nil {
+				return result.Error
+			}
+
+			if result.RowsAffected == 0 {
+				var paid int64
+				err := tx.Model(&Checkout{}).
+					Where("id = ? AND payment_status_id = ?", checkoutID, paymentStatusID(tx, PaidPayment)).
+					Count(&paid).Error
+				if err != nil {
+					return err
+				}
+				if paid > 0 {
+					return nil
+				}
+				return errPaymentStatusConflict
+			}
+
+			err := tx.Model(&Order{}).
+				Where("checkout_id = ? AND payment_status_id IN (?, ?)", checkoutID,
+					paymentStatusID(tx, WaitingProcessingPayment), paymentStatusID(tx, AuthorizedPayment)).
+				Update("payment_status_id", paymentStatusID(tx, PaidPayment)).Error
+			if err != nil {
+				return err
+			}
+
+			// После удержания средств заказы уже переданы в обработку
+			err = tx.Model(&Order{}).
+				Where("checkout_id = ? AND delivery_status_id = ?", checkoutID, deliveryStatusID(tx, WaitingProcessingDelivery)).
+				Update("delivery_status_id", deliveryStatusID(tx, WaitingProcessing)).Error
+			if err != nil {
+				return err
+			}
+
+			return finishStockReservations(tx, checkoutID, CommittedStockReservation)
+		})
+	}, schema)
+
+	return err
+}
+
+// Удержание средств: заказы передаются в обработку, резерв товаров сохраняется до списания
+func (s *OrderStorage) PaymentAuthorized(ctx context.Context, checkoutID uint, authorizedUntil *time.Time, schema string) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&Checkout{}).
+				Where("id = ? AND payment_status_id = ?", checkoutID, paymentStatusID(tx, WaitingProcessingPayment)).
+				Updates(map[string]interface{}{
+					"payment_status_id": paymentStatusID(tx, AuthorizedPayment),
+					"authorized_at":     time.Now(),
+					"authorized_until":  authorizedUntil,
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+
+			if result.RowsAffected == 0 {
+				return nil
+			}
+
+			return tx.Model(&Order{}).Where("checkout_id = ?", checkoutID).Updates(map[string]interface{}{
+				"payment_status_id":  paymentStatusID(tx, AuthorizedPayment),
+				"delivery_status_id": deliveryStatusID(tx, WaitingProcessing),
+			}).Error
+		})
+	}, schema)
+
+	return err
+}
+
+// Отмена оплаты (или удержания средств) оформления с возвратом зарезервированных товаров
+func (s *OrderStorage) PaymentCanceled(ctx context.Context, checkoutID uint, schema string) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&Checkout{}).
+				Where("id = ? AND payment_status_id IN (?, ?)", checkoutID,
+					paymentStatusID(tx, WaitingProcessingPayment), paymentStatusID(tx, AuthorizedPayment)).
+				Update("payment_status_id", paymentStatusID(tx, CanceledPayment))
+			if result.Error != nil {
+				return result.Error
+			}
+
+			if result.RowsAffected == 0 {
+				return nil
+			}
+
+			err := tx.Model(&Order{}).Where("checkout_id = ?", checkoutID).Update("payment_status_id", paymentStatusID(tx, CanceledPayment)).Error
+			if err != nil {
+				return err
+			}
+
+			// Заказы с удержанной оплатой, ещё не взятые курьером, снимаются с обработки
+			err = tx.Model(&Order{}).
+				Where("checkout_id = ? AND courier_id IS NULL AND delivery_status_id = ?", checkoutID, deliveryStatusID(tx, WaitingProcessing)).
+				Update("delivery_status_id", deliveryStatusID(tx, WaitingProcessingDelivery)).Error
+			if err != nil {
+				return err
+			}
+
+			return finishStockReservations(tx, checkoutID, ReleasedStockReservation)
+		})
+	}, schema)
+
+	return err
+}
+
+// Проверка наличия товаров по всем складам (без резерва)
+func (s *OrderStorage) CheckStock(ctx context.Context, products []Products, schema string) error {
+	names := productNames(products)
+
+	ids := make([]uint, 0, len(names))
+	for id := range names {
+		ids = append(ids, id)
+	}
+
+	var available []struct {
+		ProductsID uint
+		Quantity   uint
+	}
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Model(&Stock{}).
+			Select("products_id, SUM(quantity) AS quantity").
+			Where("products_id IN ?", ids).
+			Group("products_id").
+			Scan(&available).Error
+	}, schema)
+
+	if err != nil {
+		return err
+	}
+
+	var outOfStock []OutOfStockProduct
+	var unnamed []uint
+	for _, a := range available {
+		if a.Quantity < productUnitQuantity {
+			outOfStock = append(outOfStock, OutOfStockProduct{
+				ProductID: a.ProductsID,
+				Name:      names[a.ProductsID],
+				Requested: productUnitQuantity,
+				Available: a.Quantity,
+			})
+			if names[a.ProductsID] == "" {
+				unnamed = append(unnamed, a.ProductsID)
+			}
+		}
+	}
+
+	if len(outOfStock) == 0 {
+		return nil
+	}
+
+	// В теле запроса корзины может не быть названия товара
+	if len(unnamed) > 0 {
+		var named []Products
+		err = s.withConnectionPool(ctx, func(db *gorm.DB) error {
+			return db.Select("id, name").Where("id IN ?", unnamed).Find(&named).Error
+		}, schema)
+		if err == nil {
+			for _, p := range named {
+				names[p.ID] = p.Name
+			}
+			for i := range outOfStock {
+				outOfStock[i].Name = names[outOfStock[i].ProductID]
+			}
+		}
+	}
+
+	return &OutOfStockError{Products: outOfStock}
+}
+
+func (s *OrderStorage) GetCheckoutByPaymentID(ctx context.Context, paymentID string, schema string) (*Checkout, error) {
+	var checkout Checkout
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Where("payment_id = ?", paymentID).Preload("Orders").First(&checkout).Error
+	}, schema)
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errCheckoutWithPaymentIdNotFound
+		}
+		return nil, err
+	}
+
+	return &checkout, nil
+}
+
+// Неоплаченные оформления с активным резервом, созданные (или с платежом, пересозданным) раньше before
+func (s *OrderStorage) GetExpiredUnpaidCheckouts(ctx context.Context, before time.Time, schema string) ([]Checkout, error) {
+	var checkouts []Checkout
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		reservedOrders := db.Model(&StockReservation{}).Select("order_id").Where("status = ?", ReservedStockReservation)
+		return db.Where("payment_status_id = ? AND COALESCE(payment_renewed_at, created_at) < ?", paymentStatusID(db, WaitingProcessingPayment), before).
+			Where("id IN (?)", db.Model(&Order{}).Select("checkout_id").Where("id IN (?)", reservedOrders)).
+			Find(&checkouts).Error
+	}, schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return checkouts, nil
+}
+
+// Принятие заказа магазином (заказ в обработке, ещё не принятый)
+func (s *OrderStorage) AcceptOrder(ctx context.Context, orderID uint, schema string) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		result := db.Model(&Order{}).
+			Where("id = ? AND accepted_at IS NULL AND delivery_status_id IN (?, ?)", orderID,
+				deliveryStatusID(db, WaitingProcessing), deliveryStatusID(db, ProcessOfDelivery)).
+			Update("accepted_at", time.Now())
+
 		if result.Error == nil && result.RowsAffected == 0 {
-			return errOrderWithCourierNotFound
+			return errAcceptOrderNotFound
 		}
+
 		return result.Error
 	}, schema)
 
 	return err
 }
 
-func (s *OrderStorage) PaymentSuccess(orderID uint, schema string) error {
-	err := s.withConnectionPool(func(db *gorm.DB) error {
-		return db.Model(&Order{}).Where("id = ?", orderID).Updates(map[string]interface{}{
-			"payment_status_id":  PaidPayment,
-			"delivery_status_id": WaitingProcessing,
-		}).Error
+// Оформление заказа со статусами заказов; nil - заказ создан без оформления
+func (s *OrderStorage) GetCheckoutByOrderID(ctx context.Context, orderID uint, schema string) (*Checkout, error) {
+	var checkouts []Checkout
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Where("id IN (?)", db.Model(&Order{}).Select("checkout_id").Where("id = ?", orderID)).
+			Preload("PaymentStatus").Preload("Orders.DeliveryStatus").
+			Limit(1).Find(&checkouts).Error
 	}, schema)
 
-	return err
+	if err != nil {
+		return nil, err
+	}
+
+	if len(checkouts) == 0 {
+		return nil, nil
+	}
+
+	return &checkouts[0], nil
 }
 
-func (s *OrderStorage) TakeOrderСourier(courierID uint, orderID uint, schema string) error {
-	err := s.withConnectionPool(func(db *gorm.DB) error {
-		result := db.Model(&Order{}).Where("id = ?", orderID).Updates(
-			map[string]interface{}{"courier_id": courierID, "delivery_status_id": ProcessOfDelivery})
+// Оформления с удержанными, но не списанными средствами
+func (s *OrderStorage) GetAuthorizedCheckouts(ctx context.Context, schema string) ([]Checkout, error) {
+	var checkouts []Checkout
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Where("payment_status_id = ?", paymentStatusID(db, AuthorizedPayment)).
+			Preload("PaymentStatus").Preload("Orders.DeliveryStatus").
+			Find(&checkouts).Error
+	}, schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return checkouts, nil
+}
+
+// Суммы заказов с оплатой при получении, доставленных курьерами в период [from, to)
+func (s *OrderStorage) GetCourierCashReport(ctx context.Context, courierID *uint, from, to time.Time, schema string) ([]CourierCashReport, error) {
+	var reports []CourierCashReport
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		query := db.Model(&Order{}).
+			Select("courier_id, COUNT(*) AS orders, SUM(total_price) AS expected, SUM(collected_amount) AS collected, "+
+				"SUM(CASE WHEN payment_method = ? THEN collected_amount ELSE 0 END) AS cash, "+
+				"SUM(CASE WHEN payment_method = ? THEN collected_amount ELSE 0 END) AS card",
+				CashOnDeliveryPaymentMethod, CardOnDeliveryPaymentMethod).
+			Where("payment_method IN ? AND courier_id IS NOT NULL AND collected_at >= ? AND collected_at < ?",
+				[]string{CashOnDeliveryPaymentMethod, CardOnDeliveryPaymentMethod}, from, to)
+
+		if courierID != nil {
+			query = query.Where("courier_id = ?", *courierID)
+		}
+
+		return query.Group("courier_id").Order("courier_id").Scan(&reports).Error
+	}, schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+func (s *OrderStorage) TakeOrderСourier(ctx context.Context, courierID uint, orderID uint, schema string) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		result := db.Model(&Order{}).
+			Where("id = ? AND courier_id IS NULL AND delivery_status_id = ?", orderID, deliveryStatusID(db, WaitingProcessing)).
+			Updates(map[string]interface{}{"courier_id": courierID, "delivery_status_id": deliveryStatusID(db, ProcessOfDelivery)})
 
 		if result.Error == nil && result.RowsAffected == 0 {
 			return errTakeOrderNotFound
@@ -95,11 +717,11 @@ func (s *OrderStorage) TakeOrderСourier(courierID uint, orderID uint, schema st
 	return err
 }
 
-func (s *OrderStorage) GetOrdersByUserID(userID uint, schema string) ([]Order, error) {
+func (s *OrderStorage) GetOrdersByUserID(ctx context.Context, userID uint, schema string) ([]Order, error) {
 	var orders []Order
 
-	err := s.withConnectionPool(func(db *gorm.DB) error {
-		return db.Where("user_id = ?", userID).Preload("Products").Find(&orders).Error
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Where("user_id = ?", userID).Preload("Products").Preload("DeliveryStatus").Preload("PaymentStatus").Find(&orders).Error
 	}, schema)
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -113,11 +735,11 @@ func (s *OrderStorage) GetOrdersByUserID(userID uint, schema string) ([]Order, e
 	return orders, nil
 }
 
-func (s *OrderStorage) GetOrdersByDeliveryID(deliveryUserID uint, schema string) ([]Order, error) {
+func (s *OrderStorage) GetOrdersByDeliveryID(ctx context.Context, deliveryUserID uint, schema string) ([]Order, error) {
 	var orders []Order
 
-	err := s.withConnectionPool(func(db *gorm.DB) error {
-		return db.Where("courier_id = ? AND delivery_status_id = ?", deliveryUserID, ProcessOfDelivery).Preload("Products").Find(&orders).Error
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Where("courier_id = ? AND delivery_status_id = ?", deliveryUserID, deliveryStatusID(db, ProcessOfDelivery)).Preload("Products").Preload("DeliveryStatus").Preload("PaymentStatus").Find(&orders).Error
 	}, schema)
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -131,11 +753,11 @@ func (s *OrderStorage) GetOrdersByDeliveryID(deliveryUserID uint, schema string)
 	return orders, nil
 }
 
-func (s *OrderStorage) GetOrderByID(userId, orderID uint, schema string) (*Order, error) {
+func (s *OrderStorage) GetOrderByID(ctx context.Context, userId, orderID uint, schema string) (*Order, error) {
 	var order Order
 
-	err := s.withConnectionPool(func(db *gorm.DB) error {
-		return db.Where("user_id = ?", userId).Preload("Products").First(&order, orderID).Error
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Where("user_id = ?", userId).Preload("Products").Preload("Items").Preload("DeliveryStatus").Preload("PaymentStatus").First(&order, orderID).Error
 	}, schema)
 
 	if err != nil {
@@ -147,11 +769,11 @@ func (s *OrderStorage) GetOrderByID(userId, orderID uint, schema string) (*Order
 	return &order, nil
 }
 
-func (s *OrderStorage) GetUnaxeptedOrderByAddressShopId(addressShopId []uint, schema string) ([]Order, error) {
+func (s *OrderStorage) GetUnaxeptedOrderByAddressShopId(ctx context.Context, addressShopId []uint, schema string) ([]Order, error) {
 	var order []Order
 
-	err := s.withConnectionPool(func(db *gorm.DB) error {
-		return db.Where("addresses_shop_id IN (?) AND delivery_status_id = ?", addressShopId, WaitingProcessing).Preload("Products").Find(&order).Error
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Where("addresses_id IN (?) AND delivery_status_id = ? AND courier_id IS NULL", addressShopId, deliveryStatusID(db, WaitingProcessing)).Preload("Products").Preload("DeliveryStatus").Preload("PaymentStatus").Find(&order).Error
 	}, schema)
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -165,38 +787,125 @@ func (s *OrderStorage) GetUnaxeptedOrderByAddressShopId(addressShopId []uint, sc
 	return order, nil
 }
 
-func (s *OrderStorage) UpdateOrderPaymentID(orderID uint, paymentID string, schema string) error {
-	err := s.withConnectionPool(func(db *gorm.DB) error {
-		result := db.Model(&Order{}).Where("id = ?", orderID).Update("payment_id", paymentID)
-		if result.Error == nil && result.RowsAffected == 0 {
-			return errChangePaymentIdNotFound
-		}
-		return result.Error
+func (s *OrderStorage) UpdateCheckoutPaymentID(ctx context.Context, checkoutID uint, paymentID string, schema string) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var checkout Checkout
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", checkoutID).Limit(1).Find(&checkout).Error
+			if err != nil {
+				return err
+			}
+
+			if checkout.ID == 0 {
+				return errChangePaymentIdNotFound
+			}
+
+			err = tx.Model(&checkout).Update("payment_id", paymentID).Error
+			if err != nil {
+				return err
+			}
+
+			err = tx.Model(&Order{}).Where("checkout_id = ?", checkoutID).Update("payment_id", paymentID).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Create(&PaymentAttempt{
+				CheckoutID:      checkout.ID,
+				PaymentID:       paymentID,
+				PaymentKey:      checkout.PaymentKey,
+				PaymentProvider: checkout.PaymentProvider,
+				Status:          ActivePaymentAttempt,
+			}).Error
+		})
 	}, schema)
 
 	return err
 }
 
-func (s *OrderStorage) GetOrderByPaymentKey(paymentKey string, schema string) (*Order, error) {
-	var order Order
+// Подготовка оформления к новому платежу: прежний платёж отмечается причиной замены,
+// снятый резерв товаров восстанавливается, оформление возвращается в ожидание оплаты
+// с новым ключом идемпотентности. previousPaymentID защищает от параллельной замены.
+func (s *OrderStorage) RenewCheckoutPayment(ctx context.Context, checkoutID uint, previousPaymentID, reason, paymentKey string, schema string) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var checkout Checkout
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("id = ? AND payment_id = ?", checkoutID, previousPaymentID).
+				Preload("PaymentStatus").Limit(1).Find(&checkout).Error
+			if err != nil {
+				return err
+			}
 
-	err := s.withConnectionPool(func(db *gorm.DB) error {
-		result := db.Where("payment_key = ?", paymentKey).Preload("Products").First(&order)
-		if result.Error == nil && result.RowsAffected == 0 {
-			return errOrderWithPaymentKeyNotfound
-		}
-		return result.Error
+			if checkout.ID == 0 {
+				return errPaymentRenewConflict
+			}
+
+			if checkout.PaymentStatus.Code != WaitingProcessingPayment && checkout.PaymentStatus.Code != CanceledPayment {
+				return errOrderAlreadyPaid
+			}
+
+			err = tx.Model(&PaymentAttempt{}).
+				Where("checkout_id = ? AND payment_id = ? AND status = ?", checkout.ID, previousPaymentID, ActivePaymentAttempt).
+				Update("status", reason).Error
+			if err != nil {
+				return err
+			}
+
+			if checkout.PaymentStatus.Code == CanceledPayment {
+				var orders []Order
+				err = tx.Where("checkout_id = ?", checkout.ID).Preload("Products").Find(&orders).Error
+				if err != nil {
+					return err
+				}
+
+				for i := range orders {
+					if err := reserveStock(tx, &orders[i]); err != nil {
+						return err
+					}
+				}
+			}
+
+			err = tx.Model(&checkout).Updates(map[string]interface{}{
+				"payment_status_id":  paymentStatusID(tx, WaitingProcessingPayment),
+				"payment_key":        paymentKey,
+				"payment_id":         "",
+				"payment_renewed_at": time.Now(),
+			}).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Model(&Order{}).Where("checkout_id = ?", checkout.ID).Updates(map[string]interface{}{
+				"payment_status_id": paymentStatusID(tx, WaitingProcessingPayment),
+				"payment_key":       paymentKey,
+				"payment_id":        "",
+			}).Error
+		})
+	}, schema)
+
+	return err
+}
+
+func (s *OrderStorage) GetCheckoutByPaymentKey(ctx context.Context, paymentKey string, schema string) (*Checkout, error) {
+	var checkout Checkout
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Where("payment_key = ?", paymentKey).Preload("Orders").First(&checkout).Error
 	}, schema)
 
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errCheckoutWithPaymentKeyNotfound
+		}
 		return nil, err
 	}
 
-	return &order, nil
+	return &checkout, nil
 }
 
-func (s *OrderStorage) CreateCart(cart *Cart, schema string) (uint, error) {
-	err := s.withConnectionPool(func(db *gorm.DB) error {
+func (s *OrderStorage) CreateCart(ctx context.Context, cart *Cart, schema string) (uint, error) {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
 		return db.Create(&cart).Error
 	}, schema)
 
@@ -207,10 +916,10 @@ func (s *OrderStorage) CreateCart(cart *Cart, schema string) (uint, error) {
 	return cart.ID, nil
 }
 
-func (s *OrderStorage) GetCartWithProductsByUserID(userID uint, schema string) (*Cart, error) {
+func (s *OrderStorage) GetCartWithProductsByUserID(ctx context.Context, userID uint, schema string) (*Cart, error) {
 	var cart Cart
 
-	err := s.withConnectionPool(func(db *gorm.DB) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
 		return db.Where("user_id = ?", userID).Preload("Products").First(&cart).Error
 	}, schema)
 
@@ -225,10 +934,10 @@ func (s *OrderStorage) GetCartWithProductsByUserID(userID uint, schema string) (
 	return &cart, nil
 }
 
-func (s *OrderStorage) AddProductToCart(userID uint, product *Products, schema string) error {
+func (s *OrderStorage) AddProductToCart(ctx context.Context, userID uint, product *Products, schema string) error {
 	var cart Cart
 
-	err := s.withConnectionPool(func(db *gorm.DB) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
 		getCartErr := db.Where("user_id = ?", userID).Preload("Products").First(&cart).Error
 		if getCartErr != nil {
 			return getCartErr
@@ -239,10 +948,10 @@ func (s *OrderStorage) AddProductToCart(userID uint, product *Products, schema s
 	return err
 }
 
-func (s *OrderStorage) RemoveProductFromCart(userID uint, product *Products, schema string) error {
+func (s *OrderStorage) RemoveProductFromCart(ctx context.Context, userID uint, product *Products, schema string) error {
 	var cart Cart
 
-	err := s.withConnectionPool(func(db *gorm.DB) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
 		getCartErr := db.Where("user_id = ?", userID).Preload("Products").First(&cart).Error
 		if getCartErr != nil {
 			return getCartErr
@@ -253,10 +962,10 @@ func (s *OrderStorage) RemoveProductFromCart(userID uint, product *Products, sch
 	return err
 }
 
-func (s *OrderStorage) ClearCartProducts(userID uint, schema string) error {
+func (s *OrderStorage) ClearCartProducts(ctx context.Context, userID uint, schema string) error {
 	var cart Cart
 
-	err := s.withConnectionPool(func(db *gorm.DB) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
 		getCartErr := db.Where("user_id = ?", userID).Preload("Products").First(&cart).Error
 		if getCartErr != nil {
 			return getCartErr
@@ -266,3 +975,221 @@ func (s *OrderStorage) ClearCartProducts(userID uint, schema string) error {
 
 	return err
 }
+
+// Тариф магазина, к которому относится адрес; при отсутствии - тариф по умолчанию
+func (s *OrderStorage) GetDeliveryTariff(ctx context.Context, addressesID int, schema string) (*DeliveryTariff, error) {
+	var tariff DeliveryTariff
+	var found bool
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		var address Addresses
+		err := db.First(&address, addressesID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if address.ShopID != nil {
+			result := db.Where("shop_id = ?", *address.ShopID).Limit(1).Find(&tariff)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				found = true
+				return nil
+			}
+		}
+
+		result := db.Where("shop_id IS NULL").Limit(1).Find(&tariff)
+		found = result.RowsAffected > 0
+		return result.Error
+	}, schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return &tariff, nil
+}
+
+func (s *OrderStorage) GetPromoCode(ctx context.Context, code string, schema string) (*PromoCode, error) {
+	var promo PromoCode
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Where("UPPER(code) = ?", code).Preload("Categories").First(&promo).Error
+	}, schema)
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errPromoCodeNotFound
+		}
+		return nil, err
+	}
+
+	return &promo, nil
+}
+
+func (s *OrderStorage) GetPromoCodeByID(ctx context.Context, promoCodeID uint, schema string) (*PromoCode, error) {
+	var promo PromoCode
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Preload("Categories").First(&promo, promoCodeID).Error
+	}, schema)
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errPromoCodeNotFound
+		}
+		return nil, err
+	}
+
+	return &promo, nil
+}
+
+func (s *OrderStorage) CountPromoRedemptions(ctx context.Context, promoCodeID, userID uint, schema string) (int64, error) {
+	var count int64
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Model(&PromoRedemption{}).Where("promo_code_id = ? AND user_id = ?", promoCodeID, userID).Count(&count).Error
+	}, schema)
+
+	return count, err
+}
+
+func (s *OrderStorage) SetCartPromoCode(ctx context.Context, userID uint, promoCodeID *uint, schema string) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Model(&Cart{}).Where("user_id = ?", userID).Update("promo_code_id", promoCodeID).Error
+	}, schema)
+
+	return err
+}
+
+// Добавление товаров в корзину; при replace содержимое корзины заменяется
+func (s *OrderStorage) FillCart(ctx context.Context, userID uint, products []Products, replace bool, schema string) error {
+	var cart Cart
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			getCartErr := tx.Where("user_id = ?", userID).First(&cart).Error
+			if getCartErr != nil {
+				return getCartErr
+			}
+
+			if replace {
+				if err := tx.Model(&cart).Association("Products").Clear(); err != nil {
+					return err
+				}
+			}
+
+			if len(products) == 0 {
+				return nil
+			}
+
+			return tx.Model(&cart).Association("Products").Append(products)
+		})
+	}, schema)
+
+	return err
+}
+
+func (s *OrderStorage) GetProductsByIDs(ctx context.Context, ids []uint, schema string) ([]Products, error) {
+	var products []Products
+
+	if len(ids) == 0 {
+		return products, nil
+	}
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Where("id IN ?", ids).Find(&products).Error
+	}, schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// Остатки товаров на всех складах одним запросом
+func (s *OrderStorage) GetStocksByProductIDs(ctx context.Context, productIDs []uint, schema string) ([]Stock, error) {
+	var stocks []Stock
+
+	if len(productIDs) == 0 {
+		return stocks, nil
+	}
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Where("products_id IN ?", productIDs).Find(&stocks).Error
+	}, schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stocks, nil
+}
+
+// Добавление недостающих статусов по коду (существующие записи не изменяются)
+func (s *OrderStorage) EnsureStatuses(ctx context.Context, schema string) error {
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			for _, seed := range deliveryStatusSeed {
+				var status DeliveryStatus
+				err := tx.Where(DeliveryStatus{Code: seed.Code}).Attrs(DeliveryStatus{Name: seed.Name}).FirstOrCreate(&status).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			for _, seed := range paymentStatusSeed {
+				var status PaymentStatus
+				err := tx.Where(PaymentStatus{Code: seed.Code}).Attrs(PaymentStatus{Name: seed.Name}).FirstOrCreate(&status).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}, schema)
+
+	return err
+}
+
+// Настройки магазина; без сохранённой записи - настройки по умолчанию
+func (s *OrderStorage) GetTenantSettings(ctx context.Context, schema string) (*TenantSettings, error) {
+	var settings TenantSettings
+
+	err := s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Order("id").Limit(1).Find(&settings).Error
+	}, schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+func (s *OrderStorage) UpdateTenantSettings(ctx context.Context, settings *TenantSettings, schema string) error {
+	return s.withConnectionPool(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			var current TenantSettings
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Order("id").Limit(1).Find(&current).Error
+			if err != nil {
+				return err
+			}
+
+			if current.ID == 0 {
+				return tx.Create(settings).Error
+			}
+
+			settings.ID = current.ID
+			settings.CreatedAt = current.CreatedAt
+			return tx.Save(settings).Error
+		})
+	}, schema)
+}
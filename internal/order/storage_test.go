@@ -0,0 +1,64 @@
+package order
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func testStock(id uint, addressesID *uint, quantity uint) Stock {
+	return Stock{Model: gorm.Model{ID: id}, AddressesID: addressesID, Quantity: quantity}
+}
+
+func TestAllocateStock(t *testing.T) {
+	point := uint(7)
+
+	tests := []struct {
+		name        string
+		stocks      []Stock
+		quantity    uint
+		allocations []stockAllocation
+		available   uint
+	}{
+		{
+			name:        "pickup point covers request",
+			stocks:      []Stock{testStock(1, nil, 10), testStock(2, &point, 5)},
+			quantity:    3,
+			allocations: []stockAllocation{{StockID: 2, Quantity: 3}},
+			available:   15,
+		},
+		{
+			name:        "short pickup point falls back to global stock",
+			stocks:      []Stock{testStock(2, &point, 2), testStock(1, nil, 10)},
+			quantity:    5,
+			allocations: []stockAllocation{{StockID: 2, Quantity: 2}, {StockID: 1, Quantity: 3}},
+			available:   12,
+		},
+		{
+			name:        "empty pickup point",
+			stocks:      []Stock{testStock(2, &point, 0), testStock(1, nil, 4)},
+			quantity:    4,
+			allocations: []stockAllocation{{StockID: 1, Quantity: 4}},
+			available:   4,
+		},
+		{
+			name:      "combined stock is not enough",
+			stocks:    []Stock{testStock(2, &point, 2), testStock(1, nil, 1)},
+			quantity:  4,
+			available: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocations, available := allocateStock(tt.stocks, tt.quantity)
+			if available != tt.available {
+				t.Errorf("available = %d, want %d", available, tt.available)
+			}
+			if !reflect.DeepEqual(allocations, tt.allocations) {
+				t.Errorf("allocations = %+v, want %+v", allocations, tt.allocations)
+			}
+		})
+	}
+}
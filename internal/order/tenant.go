@@ -0,0 +1,128 @@
+package order
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mserebryaakov/aggregator-order-service/pkg/postgres"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/tenant"
+	"github.com/sirupsen/logrus"
+)
+
+// Параметры подключения нового магазина
+type CreateTenantRequest struct {
+	Domain   string `json:"domain"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type TenantService interface {
+	CreateTenant(ctx context.Context, request CreateTenantRequest) (tenant.ID, error)
+}
+
+// Операции со схемами тенантов (postgres.Migrator)
+type schemaMigrator interface {
+	CreateSchema(schema string) error
+	Migrate(schema string) (int, error)
+	DropSchema(schema string) error
+}
+
+type tenantService struct {
+	migrator    schemaMigrator
+	tenants     *tenant.Registry
+	storage     Storage
+	authAdapter *authAdapter
+	log         *logrus.Entry
+}
+
+func NewTenantService(migrator *postgres.Migrator, tenants *tenant.Registry, storage Storage, authAdapter *authAdapter, log *logrus.Entry) TenantService {
+	return &tenantService{
+		migrator:    migrator,
+		tenants:     tenants,
+		storage:     storage,
+		authAdapter: authAdapter,
+		log:         log,
+	}
+}
+
+// Подключение магазина: схема, миграции, справочники статусов и первый администратор
+// в сервисе авторизации. При ошибке откатываются только шаги, выполненные этим запросом.
+func (s *tenantService) CreateTenant(ctx context.Context, request CreateTenantRequest) (tenant.ID, error) {
+	if request.Domain == "" || request.Email == "" || request.Password == "" {
+		return "", errTenantInvalidRequest
+	}
+
+	id, err := tenant.Parse(request.Domain)
+	if err != nil {
+		return "", errTenantInvalidDomain
+	}
+	request.Domain = id.String()
+
+	var steps provisionSteps
+	err = s.provision(ctx, request, &steps)
+	if err == nil {
+		s.tenants.Add(id)
+		s.log.Infof("CreateTenant: tenant %s created", request.Domain)
+		return id, nil
+	}
+
+	if !steps.schema {
+		return "", err
+	}
+
+	s.log.Errorf("CreateTenant: provision tenant %s err - %v, rollback", request.Domain, err)
+	s.rollback(request.Domain, steps)
+
+	return "", err
+}
+
+// Шаги подключения, выполненные запросом
+type provisionSteps struct {
+	schema bool
+	auth   bool
+}
+
+// Схема создаётся без IF NOT EXISTS, поэтому при параллельном подключении одного домена
+// дальше первого шага проходит только один запрос
+func (s *tenantService) provision(ctx context.Context, request CreateTenantRequest, steps *provisionSteps) error {
+	err := s.migrator.CreateSchema(request.Domain)
+	if errors.Is(err, postgres.ErrSchemaExists) {
+		return errTenantAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+	steps.schema = true
+
+	if _, err := s.migrator.Migrate(request.Domain); err != nil {
+		return err
+	}
+
+	if err := s.storage.EnsureStatuses(ctx, request.Domain); err != nil {
+		return err
+	}
+
+	err = s.authAdapter.Init(ctx, request.Domain, request.Password, request.Email)
+	// Отклонённый сервисом авторизации запрос ничего не создал
+	var appErr *AppError
+	steps.auth = !(errors.As(err, &appErr) && appErr.HTTPCode >= 400 && appErr.HTTPCode < 500)
+
+	return err
+}
+
+// Откат выполняется и при отмене запроса клиентом, поэтому не зависит от его контекста
+func (s *tenantService) rollback(domain string, steps provisionSteps) {
+	if steps.auth {
+		err := s.authAdapter.Rollback(context.Background(), domain)
+		var appErr *AppError
+		if err != nil && !(errors.As(err, &appErr) && appErr.HTTPCode == 404) {
+			s.log.Errorf("rollback: authAdapter Rollback (domain - %s) err - %v", domain, err)
+		}
+
+		s.authAdapter.InvalidateDomain(domain)
+	}
+
+	if err := s.migrator.DropSchema(domain); err != nil {
+		s.log.Errorf("rollback: DropSchema (domain - %s) err - %v", domain, err)
+	}
+}
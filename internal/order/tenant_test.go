@@ -0,0 +1,160 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/mserebryaakov/aggregator-order-service/pkg/postgres"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/tenant"
+	"github.com/sirupsen/logrus"
+)
+
+type fakeSchemaMigrator struct {
+	createErr  error
+	migrateErr error
+	calls      []string
+}
+
+func (m *fakeSchemaMigrator) CreateSchema(schema string) error {
+	m.calls = append(m.calls, "create "+schema)
+	return m.createErr
+}
+
+func (m *fakeSchemaMigrator) Migrate(schema string) (int, error) {
+	m.calls = append(m.calls, "migrate "+schema)
+	return 1, m.migrateErr
+}
+
+func (m *fakeSchemaMigrator) DropSchema(schema string) error {
+	m.calls = append(m.calls, "drop "+schema)
+	return nil
+}
+
+type fakeStatusStorage struct {
+	Storage
+	err error
+}
+
+func (s *fakeStatusStorage) EnsureStatuses(context.Context, string) error {
+	return s.err
+}
+
+func TestCreateTenantRollback(t *testing.T) {
+	failure := errors.New("failure")
+
+	tests := []struct {
+		name       string
+		request    CreateTenantRequest
+		migrator   fakeSchemaMigrator
+		statusErr  error
+		initStatus int
+		err        error
+		calls      []string
+		inits      int
+		rollbacks  int
+		registered bool
+	}{
+		{
+			name:       "created",
+			request:    CreateTenantRequest{Domain: "Shop1", Email: "admin@example.com", Password: "secret"},
+			calls:      []string{"create shop1", "migrate shop1"},
+			inits:      1,
+			registered: true,
+		},
+		{
+			name:    "missing password",
+			request: CreateTenantRequest{Domain: "shop1", Email: "admin@example.com"},
+			err:     errTenantInvalidRequest,
+		},
+		{
+			name:    "invalid domain",
+			request: CreateTenantRequest{Domain: "public", Email: "admin@example.com", Password: "secret"},
+			err:     errTenantInvalidDomain,
+		},
+		{
+			name:     "schema exists is not dropped",
+			request:  CreateTenantRequest{Domain: "shop1", Email: "admin@example.com", Password: "secret"},
+			migrator: fakeSchemaMigrator{createErr: postgres.ErrSchemaExists},
+			err:      errTenantAlreadyExists,
+			calls:    []string{"create shop1"},
+		},
+		{
+			name:     "schema creation failed",
+			request:  CreateTenantRequest{Domain: "shop1", Email: "admin@example.com", Password: "secret"},
+			migrator: fakeSchemaMigrator{createErr: failure},
+			err:      failure,
+			calls:    []string{"create shop1"},
+		},
+		{
+			name:     "migration failed",
+			request:  CreateTenantRequest{Domain: "shop1", Email: "admin@example.com", Password: "secret"},
+			migrator: fakeSchemaMigrator{migrateErr: failure},
+			err:      failure,
+			calls:    []string{"create shop1", "migrate shop1", "drop shop1"},
+		},
+		{
+			name:      "statuses failed",
+			request:   CreateTenantRequest{Domain: "shop1", Email: "admin@example.com", Password: "secret"},
+			statusErr: failure,
+			err:       failure,
+			calls:     []string{"create shop1", "migrate shop1", "drop shop1"},
+		},
+		{
+			name:       "auth rejected the request",
+			request:    CreateTenantRequest{Domain: "shop1", Email: "admin@example.com", Password: "secret"},
+			initStatus: http.StatusBadRequest,
+			calls:      []string{"create shop1", "migrate shop1", "drop shop1"},
+			inits:      1,
+		},
+		{
+			name:       "auth failed",
+			request:    CreateTenantRequest{Domain: "shop1", Email: "admin@example.com", Password: "secret"},
+			initStatus: http.StatusInternalServerError,
+			calls:      []string{"create shop1", "migrate shop1", "drop shop1"},
+			inits:      1,
+			rollbacks:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &fakeAuthService{systemToken: "system-1", initStatus: tt.initStatus}
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+			log := logrus.NewEntry(logger)
+
+			tenants := tenant.NewRegistry(func() ([]string, error) { return nil, nil }, 0, log)
+			migrator := tt.migrator
+			s := &tenantService{
+				migrator:    &migrator,
+				tenants:     tenants,
+				storage:     &fakeStatusStorage{err: tt.statusErr},
+				authAdapter: newTestAuthAdapter(t, auth, nil),
+				log:         log,
+			}
+
+			id, err := s.CreateTenant(context.Background(), tt.request)
+			if failed := tt.err != nil || tt.initStatus != 0; (err != nil) != failed {
+				t.Errorf("err = %v, want failure - %v", err, failed)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+
+			if !reflect.DeepEqual(migrator.calls, tt.calls) {
+				t.Errorf("migrator calls = %v, want %v", migrator.calls, tt.calls)
+			}
+			if inits, rollbacks := auth.initCounts(); inits != tt.inits || rollbacks != tt.rollbacks {
+				t.Errorf("auth inits = %d, rollbacks = %d, want %d and %d", inits, rollbacks, tt.inits, tt.rollbacks)
+			}
+			if registered := tenants.Has(tenant.ID("shop1")); registered != tt.registered || (tt.registered && id != "shop1") {
+				t.Errorf("registered = %v (id - %q), want %v", registered, id, tt.registered)
+			}
+		})
+	}
+}
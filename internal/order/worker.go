@@ -0,0 +1,134 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Фоновое снятие резервов с заказов, не оплаченных за отведённое время,
+// и обработка удержанных платежей: списание готовых оформлений и отмена истекающих удержаний
+type ReservationWorker struct {
+	orderService OrderService
+	payments     *PaymentProviders
+	capture      *paymentCapture
+	schemas      func() []string
+	ttl          time.Duration
+	interval     time.Duration
+	log          *logrus.Entry
+
+	// Срок удержания, если шлюз его не сообщил, и запас до истечения, когда удержание отменяется
+	authorizationTTL    time.Duration
+	authorizationCancel time.Duration
+}
+
+func NewReservationWorker(orderService OrderService, payments *PaymentProviders, schemas func() []string,
+	ttl, interval, authorizationTTL, authorizationCancel time.Duration, log *logrus.Entry) *ReservationWorker {
+	if authorizationTTL <= 0 {
+		authorizationTTL = defaultAuthorizationTTL
+	}
+
+	return &ReservationWorker{
+		orderService:        orderService,
+		payments:            payments,
+		capture:             newPaymentCapture(orderService, payments, log),
+		schemas:             schemas,
+		ttl:                 ttl,
+		interval:            interval,
+		log:                 log,
+		authorizationTTL:    authorizationTTL,
+		authorizationCancel: authorizationCancel,
+	}
+}
+
+const defaultAuthorizationTTL = 7 * 24 * time.Hour
+
+// Запуск до отмены контекста
+func (w *ReservationWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, schema := range w.schemas() {
+				w.releaseExpired(ctx, schema)
+				w.processAuthorized(ctx, schema)
+			}
+		}
+	}
+}
+
+func (w *ReservationWorker) releaseExpired(ctx context.Context, schema string) {
+	checkouts, err := w.orderService.GetExpiredUnpaidCheckouts(ctx, time.Now().Add(-w.ttl), schema)
+	if err != nil {
+		w.log.Errorf("releaseExpired: GetExpiredUnpaidCheckouts (schema - %s) err - %v", schema, err)
+		return
+	}
+
+	for _, checkout := range checkouts {
+		if checkout.PaymentID != "" {
+			provider, err := w.payments.Get(checkout.PaymentProvider)
+			if err != nil {
+				w.log.Errorf("releaseExpired: payment provider %s (checkoutId - %d) err - %v", checkout.PaymentProvider, checkout.ID, err)
+				continue
+			}
+
+			payment, err := provider.GetPayment(ctx, checkout.PaymentID)
+			var gwErr *GatewayError
+			switch {
+			case errors.As(err, &gwErr) && gwErr.HTTPCode == http.StatusNotFound:
+				// Платёж не найден в платёжном сервисе - резерв снимается
+			case err != nil:
+				w.log.Errorf("releaseExpired: GetPayment (checkoutId - %d) err - %v", checkout.ID, err)
+				continue
+			}
+
+			if payment != nil && (payment.Status == "succeeded" || payment.Status == "waiting_for_capture") {
+				if err := w.capture.apply(ctx, checkout.ID, payment, schema); err != nil {
+					w.log.Errorf("releaseExpired: apply payment status %s (checkoutId - %d) err - %v", payment.Status, checkout.ID, err)
+				}
+				continue
+			}
+		}
+
+		if err := w.orderService.PaymentCanceled(ctx, checkout.ID, schema); err != nil {
+			w.log.Errorf("releaseExpired: PaymentCanceled (checkoutId - %d) err - %v", checkout.ID, err)
+			continue
+		}
+
+		w.log.Infof("releaseExpired: reservation released (checkoutId - %d, schema - %s)", checkout.ID, schema)
+	}
+}
+
+func (w *ReservationWorker) processAuthorized(ctx context.Context, schema string) {
+	checkouts, err := w.orderService.GetAuthorizedCheckouts(ctx, schema)
+	if err != nil {
+		w.log.Errorf("processAuthorized: GetAuthorizedCheckouts (schema - %s) err - %v", schema, err)
+		return
+	}
+
+	now := time.Now()
+	for i := range checkouts {
+		checkout := &checkouts[i]
+
+		switch {
+		case readyForCapture(checkout):
+			// Списание не прошло при доставке или принятии заказа
+			if err := w.capture.capture(ctx, checkout, schema); err != nil {
+				w.log.Errorf("processAuthorized: capture (checkoutId - %d) err - %v", checkout.ID, err)
+			}
+		case authorizationExpiring(checkout, w.authorizationTTL, w.authorizationCancel, now):
+			if err := w.capture.cancel(ctx, checkout, schema); err != nil {
+				w.log.Errorf("processAuthorized: cancel (checkoutId - %d) err - %v", checkout.ID, err)
+				continue
+			}
+			w.log.Infof("processAuthorized: authorization canceled before expiry (checkoutId - %d, schema - %s)", checkout.ID, schema)
+		}
+	}
+}
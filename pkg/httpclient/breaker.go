@@ -0,0 +1,120 @@
+package httpclient
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Состояния автомата: closed - запросы проходят, open - запросы отклоняются сразу,
+// half-open - пропускается один пробный запрос
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+var breakerStates = expvar.NewMap("circuit_breakers")
+
+// Автомат отключения зависимости после FailureThreshold ошибок подряд.
+// Через OpenTimeout пропускается пробный запрос; успех закрывает автомат, ошибка - снова открывает.
+type breaker struct {
+	sync.Mutex
+	name      string
+	threshold int
+	timeout   time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	log       *logrus.Entry
+}
+
+func newBreaker(name string, threshold int, timeout time.Duration, log *logrus.Entry) *breaker {
+	b := &breaker{
+		name:      name,
+		threshold: threshold,
+		timeout:   timeout,
+		state:     StateClosed,
+		log:       log,
+	}
+	b.publish()
+	return b
+}
+
+// Разрешение на запрос
+func (b *breaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.timeout {
+			return false
+		}
+		b.setState(StateHalfOpen)
+		b.probing = true
+		return true
+	case StateHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *breaker) success() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	if b.state != StateClosed {
+		b.setState(StateClosed)
+	}
+}
+
+func (b *breaker) failure() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.failures++
+	b.probing = false
+
+	if b.state == StateHalfOpen || b.state == StateClosed && b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(StateOpen)
+	}
+}
+
+// Завершение запроса без результата (отменён вызывающей стороной)
+func (b *breaker) release() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.probing = false
+}
+
+func (b *breaker) State() string {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.state
+}
+
+func (b *breaker) setState(state string) {
+	b.log.Warnf("httpclient: circuit breaker %s %s -> %s", b.name, b.state, state)
+	b.state = state
+	b.publish()
+}
+
+func (b *breaker) publish() {
+	v := new(expvar.String)
+	v.Set(b.state)
+	breakerStates.Set(b.name, v)
+}
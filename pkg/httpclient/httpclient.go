@@ -0,0 +1,179 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type Config struct {
+	// Имя зависимости (для логов и метрик)
+	Name string
+
+	// Число повторов после первой попытки
+	MaxRetries  int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// Число ошибок подряд, после которого запросы к зависимости отклоняются,
+	// и время до пробного запроса
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+const (
+	defaultBaseBackoff      = 100 * time.Millisecond
+	defaultMaxBackoff       = 2 * time.Second
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
+)
+
+// HTTP-клиент зависимости с повторами и автоматом отключения.
+// Повторяются только запросы, которые безопасно выполнить повторно:
+// идемпотентные методы, запросы с заголовком Idempotency-Key и запросы,
+// помеченные через Idempotent.
+type Client struct {
+	cfg     Config
+	client  http.Client
+	breaker *breaker
+	log     *logrus.Entry
+}
+
+func New(cfg Config, log *logrus.Entry) *Client {
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = defaultBaseBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = defaultOpenTimeout
+	}
+
+	return &Client{
+		cfg:     cfg,
+		client:  http.Client{},
+		breaker: newBreaker(cfg.Name, cfg.FailureThreshold, cfg.OpenTimeout, log),
+		log:     log,
+	}
+}
+
+type idempotentKey struct{}
+
+// Пометка запросов контекста как безопасных для повтора (например, ключ идемпотентности передан в параметрах)
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func (c *Client) State() string {
+	return c.breaker.State()
+}
+
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	retries := 0
+	if retryable(req) {
+		retries = c.cfg.MaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		if !c.breaker.allow() {
+			return nil, fmt.Errorf("%s: %w", c.cfg.Name, ErrCircuitOpen)
+		}
+
+		r := req
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		resp, err := c.client.Do(r)
+
+		switch {
+		case err != nil && errors.Is(err, context.Canceled):
+			// Отмена вызывающей стороной не говорит о состоянии зависимости
+			c.breaker.release()
+		case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+			c.breaker.failure()
+		default:
+			c.breaker.success()
+		}
+
+		if attempt >= retries || !temporary(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		delay := c.backoff(attempt)
+		c.log.Debugf("httpclient: %s %s %s attempt %d failed, retry in %v", c.cfg.Name, req.Method, req.URL.Path, attempt+1, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Задержка перед повтором: экспоненциальная, со случайной составляющей в половину интервала
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.cfg.BaseBackoff << attempt
+	if d <= 0 || d > c.cfg.MaxBackoff {
+		d = c.cfg.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func retryable(req *http.Request) bool {
+	if req.Body != nil && req.GetBody == nil {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	if req.Header.Get("Idempotency-Key") != "" {
+		return true
+	}
+
+	marked, _ := req.Context().Value(idempotentKey{}).(bool)
+	return marked
+}
+
+// Ошибки, после которых повтор имеет смысл
+func temporary(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
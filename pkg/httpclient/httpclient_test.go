@@ -0,0 +1,235 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testClient(t *testing.T, cfg Config) *Client {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cfg.Name = t.Name()
+	if cfg.BaseBackoff == 0 {
+		cfg.BaseBackoff = time.Millisecond
+		cfg.MaxBackoff = 2 * time.Millisecond
+	}
+	return New(cfg, logrus.NewEntry(logger))
+}
+
+// Сервер, отвечающий заданным кодом и считающий запросы
+func statusServer(t *testing.T, code, calls *int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		w.WriteHeader(int(atomic.LoadInt32(code)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRetryOnlyIdempotentRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		key     string
+		marked  bool
+		status  int
+		retries int
+		calls   int32
+	}{
+		{name: "get", method: http.MethodGet, status: http.StatusServiceUnavailable, retries: 2, calls: 3},
+		{name: "delete", method: http.MethodDelete, status: http.StatusBadGateway, retries: 1, calls: 2},
+		{name: "post", method: http.MethodPost, status: http.StatusServiceUnavailable, retries: 2, calls: 1},
+		{name: "post with idempotency key", method: http.MethodPost, key: "order-1", status: http.StatusServiceUnavailable, retries: 2, calls: 3},
+		{name: "post marked idempotent", method: http.MethodPost, marked: true, status: http.StatusTooManyRequests, retries: 2, calls: 3},
+		{name: "not temporary error", method: http.MethodGet, status: http.StatusInternalServerError, retries: 2, calls: 1},
+		{name: "client error", method: http.MethodGet, status: http.StatusBadRequest, retries: 2, calls: 1},
+		{name: "no retries", method: http.MethodGet, status: http.StatusServiceUnavailable, calls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, calls := int32(tt.status), int32(0)
+			srv := statusServer(t, &code, &calls)
+			c := testClient(t, Config{MaxRetries: tt.retries, FailureThreshold: 10})
+
+			ctx := context.Background()
+			if tt.marked {
+				ctx = Idempotent(ctx)
+			}
+			req, err := http.NewRequestWithContext(ctx, tt.method, srv.URL, strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("code = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.calls {
+				t.Errorf("calls = %d, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+// Тело запроса передаётся заново при каждом повторе
+func TestRetryResendsBody(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"amount":100}` {
+			t.Errorf("attempt %d body = %q", atomic.LoadInt32(&calls)+1, body)
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := testClient(t, Config{MaxRetries: 2, FailureThreshold: 10})
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"amount":100}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Idempotency-Key", "payment-1")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || calls != 3 {
+		t.Errorf("code = %d, calls = %d, want %d after 3 calls", resp.StatusCode, calls, http.StatusOK)
+	}
+}
+
+// Запрос с телом без GetBody повторить нельзя
+func TestRetryableWithoutGetBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPut, "/payment", io.NopCloser(strings.NewReader("{}")))
+	if retryable(req) {
+		t.Error("request without GetBody is retryable")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	code, calls := int32(http.StatusInternalServerError), int32(0)
+	srv := statusServer(t, &code, &calls)
+	c := testClient(t, Config{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond})
+
+	get := func() error {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	expect := func(step, state string, want int32) {
+		t.Helper()
+		if c.State() != state {
+			t.Errorf("%s: state = %s, want %s", step, c.State(), state)
+		}
+		if got := atomic.LoadInt32(&calls); got != want {
+			t.Errorf("%s: calls = %d, want %d", step, got, want)
+		}
+	}
+
+	get()
+	expect("one failure", StateClosed, 1)
+	get()
+	expect("threshold reached", StateOpen, 2)
+
+	// Открытый автомат отклоняет запросы, не обращаясь к зависимости
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("err = %v, want %v", err, ErrCircuitOpen)
+	}
+	expect("open", StateOpen, 2)
+
+	// Неудачный пробный запрос снова открывает автомат
+	time.Sleep(30 * time.Millisecond)
+	get()
+	expect("failed probe", StateOpen, 3)
+
+	// Успешный пробный запрос закрывает автомат
+	time.Sleep(30 * time.Millisecond)
+	atomic.StoreInt32(&code, http.StatusOK)
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+	expect("successful probe", StateClosed, 4)
+}
+
+func TestBreakerHalfOpenAllowsSingleProbe(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	b := newBreaker(t.Name(), 1, time.Millisecond, logrus.NewEntry(logger))
+
+	b.failure()
+	time.Sleep(5 * time.Millisecond)
+
+	if !b.allow() {
+		t.Fatal("probe is not allowed after open timeout")
+	}
+	if b.State() != StateHalfOpen {
+		t.Errorf("state = %s, want %s", b.State(), StateHalfOpen)
+	}
+	if b.allow() {
+		t.Error("second request allowed while probe is in flight")
+	}
+
+	// Отменённый пробный запрос освобождает место для следующего
+	b.release()
+	if !b.allow() {
+		t.Error("probe is not allowed after release")
+	}
+}
+
+// Отмена запроса вызывающей стороной не считается ошибкой зависимости
+func TestCanceledRequestIsNotFailure(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	c := testClient(t, Config{FailureThreshold: 1, MaxRetries: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if _, err := c.Do(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	if c.State() != StateClosed {
+		t.Errorf("state = %s, want %s", c.State(), StateClosed)
+	}
+}
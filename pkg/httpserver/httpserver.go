@@ -3,17 +3,19 @@ package httpserver
 import (
 	"context"
 	"net/http"
+	"sync"
 	"time"
 )
 
 // Создание абстракции над структурой сервер
 type Server struct {
+	mu         sync.Mutex
 	httpServer *http.Server
 }
 
 // Запуск сервера
 func (s *Server) Run(port string, handler http.Handler) error {
-	s.httpServer = &http.Server{
+	httpServer := &http.Server{
 		Addr:           port,
 		Handler:        handler,
 		MaxHeaderBytes: 1 << 20,
@@ -21,10 +23,23 @@ func (s *Server) Run(port string, handler http.Handler) error {
 		WriteTimeout:   5 * time.Minute,
 	}
 
-	return s.httpServer.ListenAndServe()
+	s.mu.Lock()
+	s.httpServer = httpServer
+	s.mu.Unlock()
+
+	return httpServer.ListenAndServe()
 }
 
-// Остановка сервера
+// Остановка сервера: новые соединения не принимаются, текущие запросы
+// обрабатываются до истечения ctx
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	s.mu.Lock()
+	httpServer := s.httpServer
+	s.mu.Unlock()
+
+	if httpServer == nil {
+		return nil
+	}
+
+	return httpServer.Shutdown(ctx)
 }
@@ -0,0 +1,136 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type component struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// Управление жизненным циклом приложения: компоненты регистрируются по мере
+// запуска и останавливаются в обратном порядке (сервер -> фоновые задачи -> пулы)
+type Manager struct {
+	sync.Mutex
+	components []component
+	timeout    time.Duration
+	failed     chan error
+	log        *logrus.Entry
+}
+
+// timeout - общий срок остановки, включая ожидание обработки текущих запросов
+func New(timeout time.Duration, log *logrus.Entry) *Manager {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &Manager{
+		timeout: timeout,
+		failed:  make(chan error, 1),
+		log:     log,
+	}
+}
+
+// Регистрация действия при остановке (например, закрытие пула соединений)
+func (m *Manager) OnStop(name string, stop func(ctx context.Context) error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.components = append(m.components, component{name: name, stop: stop})
+}
+
+// Запуск фоновой задачи; при остановке контекст задачи отменяется
+// и ожидается её завершение
+func (m *Manager) Go(name string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+
+	m.OnStop(name, func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	})
+}
+
+// Запуск сервера; ошибка запуска приводит к остановке приложения
+func (m *Manager) Serve(name string, run func() error, shutdown func(ctx context.Context) error) {
+	go func() {
+		err := run()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.log.Errorf("lifecycle: %s failed - %v", name, err)
+			select {
+			case m.failed <- err:
+			default:
+			}
+		}
+	}()
+
+	m.OnStop(name, shutdown)
+}
+
+// Ожидание сигнала завершения или сбоя компонента и остановка всех компонентов.
+// Возвращает код завершения процесса.
+func (m *Manager) Wait() int {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	code := 0
+	select {
+	case sig := <-interrupt:
+		m.log.Infof("lifecycle: shutdown, %s", sig)
+	case <-m.failed:
+		code = 1
+	}
+
+	if err := m.Stop(); err != nil {
+		code = 1
+	}
+
+	return code
+}
+
+// Остановка компонентов в обратном порядке регистрации в пределах общего срока
+func (m *Manager) Stop() error {
+	m.Lock()
+	components := m.components
+	m.components = nil
+	m.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	var stopErr error
+	for i := len(components) - 1; i >= 0; i-- {
+		c := components[i]
+		if err := c.stop(ctx); err != nil {
+			m.log.Errorf("lifecycle: stop %s err - %v", c.name, err)
+			stopErr = err
+			continue
+		}
+		m.log.Infof("lifecycle: %s stopped", c.name)
+	}
+
+	return stopErr
+}
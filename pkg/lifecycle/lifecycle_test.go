@@ -0,0 +1,151 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testManager(timeout time.Duration) *Manager {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return New(timeout, logrus.NewEntry(logger))
+}
+
+// Порядок вызова обработчиков остановки
+type stopRecorder struct {
+	sync.Mutex
+	order []string
+}
+
+func (r *stopRecorder) stop(name string, err error) func(ctx context.Context) error {
+	return func(context.Context) error {
+		r.Lock()
+		defer r.Unlock()
+		r.order = append(r.order, name)
+		return err
+	}
+}
+
+func TestStopInReverseOrder(t *testing.T) {
+	failure := errors.New("close failed")
+
+	tests := []struct {
+		name  string
+		fails string
+		err   error
+	}{
+		{name: "all stopped"},
+		{name: "failed component does not block the rest", fails: "workers", err: failure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testManager(time.Second)
+			r := &stopRecorder{}
+
+			for _, name := range []string{"postgres", "workers", "server"} {
+				var err error
+				if name == tt.fails {
+					err = failure
+				}
+				m.OnStop(name, r.stop(name, err))
+			}
+
+			if err := m.Stop(); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+			if want := []string{"server", "workers", "postgres"}; !reflect.DeepEqual(r.order, want) {
+				t.Errorf("order = %v, want %v", r.order, want)
+			}
+
+			// Повторная остановка ничего не вызывает
+			if err := m.Stop(); err != nil {
+				t.Errorf("second Stop err = %v", err)
+			}
+			if len(r.order) != 3 {
+				t.Errorf("second Stop called components again - %v", r.order)
+			}
+		})
+	}
+}
+
+func TestGoCancelsAndWaits(t *testing.T) {
+	m := testManager(time.Second)
+	r := &stopRecorder{}
+
+	m.OnStop("postgres", r.stop("postgres", nil))
+	m.Go("worker", func(ctx context.Context) {
+		<-ctx.Done()
+		// Задача завершается до остановки зарегистрированных раньше компонентов
+		time.Sleep(10 * time.Millisecond)
+		r.stop("worker", nil)(ctx)
+	})
+
+	if err := m.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"worker", "postgres"}; !reflect.DeepEqual(r.order, want) {
+		t.Errorf("order = %v, want %v", r.order, want)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	m := testManager(20 * time.Millisecond)
+	r := &stopRecorder{}
+
+	release := make(chan struct{})
+	defer close(release)
+
+	m.OnStop("postgres", r.stop("postgres", nil))
+	m.Go("stuck", func(ctx context.Context) {
+		<-release
+	})
+
+	if err := m.Stop(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if want := []string{"postgres"}; !reflect.DeepEqual(r.order, want) {
+		t.Errorf("order = %v, want %v", r.order, want)
+	}
+}
+
+func TestServeFailureStopsApplication(t *testing.T) {
+	m := testManager(time.Second)
+	r := &stopRecorder{}
+
+	m.OnStop("postgres", r.stop("postgres", nil))
+	m.Serve("server", func() error { return errors.New("address already in use") }, r.stop("server", nil))
+
+	if code := m.Wait(); code != 1 {
+		t.Errorf("code = %d, want 1", code)
+	}
+	if want := []string{"server", "postgres"}; !reflect.DeepEqual(r.order, want) {
+		t.Errorf("order = %v, want %v", r.order, want)
+	}
+}
+
+// Штатное закрытие сервера не считается сбоем
+func TestServeClosedIsNotFailure(t *testing.T) {
+	m := testManager(time.Second)
+
+	done := make(chan struct{})
+	m.Serve("server", func() error {
+		defer close(done)
+		return http.ErrServerClosed
+	}, func(context.Context) error { return nil })
+	<-done
+
+	select {
+	case err := <-m.failed:
+		t.Errorf("failure reported - %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
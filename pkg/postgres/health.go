@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"context"
+	"time"
+)
+
+const defaultHealthCheckInterval = 10 * time.Second
+
+// Время, в течение которого старое соединение остаётся открытым после переподключения
+const reconnectDrainTimeout = 30 * time.Second
+
+func (scp *SchemaConnectionPool) Ping(ctx context.Context) error {
+	scp.Lock()
+	sqlDB, closed := scp.sqlDB, scp.closed
+	scp.Unlock()
+
+	if closed {
+		return ErrPoolClosed
+	}
+
+	if sqlDB == nil {
+		_, err := scp.GetConnectionPool("public")
+		return err
+	}
+
+	return sqlDB.PingContext(ctx)
+}
+
+// Периодическая проверка соединения с БД до отмены контекста. При ошибке соединение
+// переоткрывается с экспоненциальной задержкой до maxBackoff; новое соединение
+// подменяет старое только после успешной проверки.
+func (scp *SchemaConnectionPool) RunHealthCheck(ctx context.Context, interval, maxBackoff time.Duration) {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	if maxBackoff < interval {
+		maxBackoff = interval
+	}
+
+	delay := interval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		err := scp.check(ctx, interval)
+		if err == nil {
+			delay = interval
+			continue
+		}
+
+		scp.log.Errorf("postgres: health check err - %v, retry in %s", err, delay)
+		delay = backoff(delay, maxBackoff)
+	}
+}
+
+// Следующая задержка повторной проверки: удвоение до maxBackoff
+func backoff(delay, maxBackoff time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+func (scp *SchemaConnectionPool) check(ctx context.Context, timeout time.Duration) error {
+	pingCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := scp.Ping(pingCtx)
+	if err == nil || err == ErrPoolClosed {
+		return err
+	}
+
+	return scp.reconnect(pingCtx)
+}
+
+// Открытие нового соединения и атомарная замена текущего
+func (scp *SchemaConnectionPool) reconnect(ctx context.Context) error {
+	db, sqlDB, err := scp.connect()
+	if err != nil {
+		return err
+	}
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return err
+	}
+
+	scp.Lock()
+	if scp.closed {
+		scp.Unlock()
+		sqlDB.Close()
+		return ErrPoolClosed
+	}
+
+	old := scp.sqlDB
+	scp.public = db
+	scp.sqlDB = sqlDB
+	// Хэндлы схем ссылаются на старое соединение и пересоздаются при следующем обращении
+	scp.pools = make(map[string]*tenantPool)
+	scp.Unlock()
+
+	// Запросы, получившие хэндл до замены, должны завершиться на старом соединении,
+	// поэтому оно закрывается с задержкой; Close дожидается уже начатых запросов
+	if old != nil {
+		time.AfterFunc(reconnectDrainTimeout, func() {
+			if err := old.Close(); err != nil {
+				scp.log.Errorf("postgres: close previous connection pool err - %v", err)
+			}
+		})
+	}
+
+	scp.log.Infof("postgres: connection pool reopened, previous one closes in %s", reconnectDrainTimeout)
+
+	return nil
+}
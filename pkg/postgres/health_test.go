@@ -0,0 +1,183 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Драйвер-заглушка: каждое переподключение получает новый DSN, недоступные DSN не отвечают
+type fakeDriver struct {
+	sync.Mutex
+	down map[string]bool
+}
+
+var errDatabaseDown = errors.New("database is down")
+
+func (d *fakeDriver) Open(dsn string) (driver.Conn, error) {
+	if d.isDown(dsn) {
+		return nil, errDatabaseDown
+	}
+	return &fakeConn{driver: d, dsn: dsn}, nil
+}
+
+func (d *fakeDriver) isDown(dsn string) bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.down[dsn]
+}
+
+func (d *fakeDriver) setDown(dsn string, down bool) {
+	d.Lock()
+	defer d.Unlock()
+	d.down[dsn] = down
+}
+
+type fakeConn struct {
+	driver *fakeDriver
+	dsn    string
+}
+
+func (c *fakeConn) Ping(context.Context) error {
+	if c.driver.isDown(c.dsn) {
+		return errDatabaseDown
+	}
+	return nil
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+var (
+	fakeDriverOnce sync.Once
+	testDriver     = &fakeDriver{down: make(map[string]bool)}
+)
+
+// Пул поверх драйвера-заглушки; возвращает функцию с DSN i-го подключения
+func fakeConnectionPool(t *testing.T) (*SchemaConnectionPool, func(i int) string) {
+	fakeDriverOnce.Do(func() { sql.Register("postgres-health-test", testDriver) })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	prefix := t.Name()
+	dsn := func(i int) string { return fmt.Sprintf("%s-%d", prefix, i) }
+
+	var mu sync.Mutex
+	connects := 0
+	scp := NewSchemaConnectionPool(Config{}, logrus.NewEntry(logger))
+	scp.dialector = func(string) gorm.Dialector {
+		mu.Lock()
+		defer mu.Unlock()
+		connects++
+		return postgres.New(postgres.Config{DriverName: "postgres-health-test", DSN: dsn(connects)})
+	}
+	t.Cleanup(func() { scp.Close() })
+
+	return scp, dsn
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		delay, max, want time.Duration
+	}{
+		{delay: time.Second, max: time.Minute, want: 2 * time.Second},
+		{delay: 20 * time.Second, max: 30 * time.Second, want: 30 * time.Second},
+		{delay: 30 * time.Second, max: 30 * time.Second, want: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.delay, tt.max); got != tt.want {
+			t.Errorf("backoff(%s, %s) = %s, want %s", tt.delay, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestCheckReconnectsOnlyWhenNewConnectionWorks(t *testing.T) {
+	scp, dsn := fakeConnectionPool(t)
+	ctx := context.Background()
+
+	if err := scp.check(ctx, time.Second); err != nil {
+		t.Fatalf("first check: %v", err)
+	}
+	scp.Lock()
+	first := scp.sqlDB
+	scp.pools["shop1"] = &tenantPool{db: scp.public, lastUsed: time.Now()}
+	scp.Unlock()
+
+	// Соединение потеряно, новое открыть нельзя: текущее соединение и хэндлы сохраняются
+	testDriver.setDown(dsn(1), true)
+	testDriver.setDown(dsn(2), true)
+	if err := scp.check(ctx, time.Second); err == nil {
+		t.Fatal("check with unavailable database: expected error")
+	}
+	scp.Lock()
+	if scp.sqlDB != first || len(scp.pools) != 1 {
+		t.Error("connection was replaced by a failed reconnect")
+	}
+	scp.Unlock()
+
+	// Новое соединение доступно: оно подменяет старое, хэндлы схем сбрасываются
+	if err := scp.check(ctx, time.Second); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	scp.Lock()
+	if scp.sqlDB == first || scp.sqlDB == nil {
+		t.Error("connection was not replaced")
+	}
+	if len(scp.pools) != 0 {
+		t.Errorf("schema handles = %d, want 0", len(scp.pools))
+	}
+	scp.Unlock()
+}
+
+func TestCheckClosedPool(t *testing.T) {
+	scp, _ := fakeConnectionPool(t)
+
+	if err := scp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := scp.check(context.Background(), time.Second); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("err = %v, want %v", err, ErrPoolClosed)
+	}
+	if _, err := scp.GetConnectionPool("public"); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("GetConnectionPool err = %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+func TestRunHealthCheckStopsOnCancel(t *testing.T) {
+	scp, _ := fakeConnectionPool(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scp.RunHealthCheck(ctx, time.Millisecond, time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunHealthCheck did not stop after cancel")
+	}
+
+	scp.Lock()
+	defer scp.Unlock()
+	if scp.sqlDB == nil {
+		t.Error("health check did not open the connection")
+	}
+}
@@ -0,0 +1,245 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/tenant"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var ErrSchemaExists = errors.New("schema already exists")
+
+// SQLSTATE duplicate_schema
+const duplicateSchemaCode = "42P06"
+
+// Версионированная миграция схемы тенанта
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+}
+
+// Запись о применённой миграции (хранится в схеме тенанта)
+type SchemaMigration struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	scp        *SchemaConnectionPool
+	migrations []Migration
+	log        *logrus.Entry
+}
+
+func NewMigrator(scp *SchemaConnectionPool, migrations []Migration, log *logrus.Entry) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return &Migrator{
+		scp:        scp,
+		migrations: sorted,
+		log:        log,
+	}
+}
+
+// Создание схемы нового тенанта и применение всех миграций
+func (m *Migrator) Provision(schema string) error {
+	if _, err := tenant.Parse(schema); err != nil {
+		return fmt.Errorf("schema %q: %w", schema, err)
+	}
+
+	db, err := m.scp.GetConnectionPool("public")
+	if err != nil {
+		return err
+	}
+
+	err = db.Exec("CREATE SCHEMA IF NOT EXISTS " + QuoteIdentifier(schema)).Error
+	if err != nil {
+		return err
+	}
+
+	_, err = m.Migrate(schema)
+	return err
+}
+
+// Создание схемы нового тенанта без миграций. Если схема уже есть, возвращает ErrSchemaExists:
+// при параллельном подключении одного тенанта схему создаёт только один из запросов.
+func (m *Migrator) CreateSchema(schema string) error {
+	if _, err := tenant.Parse(schema); err != nil {
+		return fmt.Errorf("schema %q: %w", schema, err)
+	}
+
+	db, err := m.scp.GetConnectionPool("public")
+	if err != nil {
+		return err
+	}
+
+	err = db.Exec("CREATE SCHEMA " + QuoteIdentifier(schema)).Error
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == duplicateSchemaCode {
+		return ErrSchemaExists
+	}
+
+	return err
+}
+
+// Удаление схемы тенанта со всеми данными
+func (m *Migrator) DropSchema(schema string) error {
+	if _, err := tenant.Parse(schema); err != nil {
+		return fmt.Errorf("schema %q: %w", schema, err)
+	}
+
+	db, err := m.scp.GetConnectionPool("public")
+	if err != nil {
+		return err
+	}
+
+	m.scp.Evict(schema)
+
+	return db.Exec("DROP SCHEMA IF EXISTS " + QuoteIdentifier(schema) + " CASCADE").Error
+}
+
+func (m *Migrator) SchemaExists(schema string) (bool, error) {
+	db, err := m.scp.GetConnectionPool("public")
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	err = db.Raw("SELECT COUNT(*) FROM pg_namespace WHERE nspname = ?", schema).Scan(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// Применение недостающих миграций к схеме; возвращает количество применённых
+func (m *Migrator) Migrate(schema string) (int, error) {
+	if _, err := tenant.Parse(schema); err != nil {
+		return 0, fmt.Errorf("schema %q: %w", schema, err)
+	}
+
+	db, err := m.scp.GetConnectionPool("public")
+	if err != nil {
+		return 0, err
+	}
+
+	exists, err := m.SchemaExists(schema)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, fmt.Errorf("schema not found: %s", schema)
+	}
+
+	applied := 0
+	for _, migration := range m.migrations {
+		ok, err := m.apply(db, schema, migration)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d (%s) in schema %s: %w", migration.Version, migration.Name, schema, err)
+		}
+		if ok {
+			applied++
+			m.log.Infof("migrate: applied %d (%s) in schema %s", migration.Version, migration.Name, schema)
+		}
+	}
+
+	return applied, nil
+}
+
+// Миграция выполняется в отдельной транзакции с search_path схемы тенанта
+// и advisory-блокировкой, чтобы параллельные запуски не применили её дважды
+func (m *Migrator) apply(db *gorm.DB, schema string, migration Migration) (bool, error) {
+	applied := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("SET LOCAL search_path TO " + QuoteIdentifier(schema)).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "migrate:"+schema).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&SchemaMigration{})
+		if err != nil {
+			return err
+		}
+
+		var count int64
+		err = tx.Model(&SchemaMigration{}).Where("version = ?", migration.Version).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		if err := migration.Up(tx); err != nil {
+			return err
+		}
+
+		applied = true
+		return tx.Create(&SchemaMigration{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now(),
+		}).Error
+	})
+
+	return applied, err
+}
+
+// Все пользовательские схемы базы (кроме public и системных)
+func (m *Migrator) TenantSchemas() ([]string, error) {
+	db, err := m.scp.GetConnectionPool("public")
+	if err != nil {
+		return nil, err
+	}
+
+	var schemas []string
+	err = db.Raw(`SELECT nspname FROM pg_namespace
+		WHERE nspname NOT LIKE 'pg\_%' AND nspname NOT IN ('public', 'information_schema')
+		ORDER BY nspname`).Scan(&schemas).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return schemas, nil
+}
+
+// Схемы, в которых применялись миграции (подключённые тенанты)
+func (m *Migrator) MigratedSchemas() ([]string, error) {
+	db, err := m.scp.GetConnectionPool("public")
+	if err != nil {
+		return nil, err
+	}
+
+	var schemas []string
+	err = db.Raw(`SELECT table_schema FROM information_schema.tables
+		WHERE table_name = 'schema_migrations' AND table_schema <> 'public'
+		ORDER BY table_schema`).Scan(&schemas).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return schemas, nil
+}
+
+// Экранирование идентификатора PostgreSQL
+func QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
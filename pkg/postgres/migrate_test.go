@@ -0,0 +1,233 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mserebryaakov/aggregator-order-service/config"
+	"github.com/mserebryaakov/aggregator-order-service/pkg/tenant"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func TestNewMigratorSortsByVersion(t *testing.T) {
+	m := NewMigrator(nil, []Migration{{Version: 3}, {Version: 1}, {Version: 2}}, logrus.NewEntry(logrus.New()))
+
+	for i, migration := range m.migrations {
+		if migration.Version != uint(i+1) {
+			t.Fatalf("migrations[%d].Version = %d, want %d", i, migration.Version, i+1)
+		}
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "shop1", want: `"shop1"`},
+		{name: "shop-1", want: `"shop-1"`},
+		{name: `a"b`, want: `"a""b"`},
+	}
+
+	for _, tt := range tests {
+		if got := QuoteIdentifier(tt.name); got != tt.want {
+			t.Errorf("QuoteIdentifier(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Имя схемы проверяется до обращения к БД
+func TestMigratorRejectsInvalidSchema(t *testing.T) {
+	m := NewMigrator(nil, nil, logrus.NewEntry(logrus.New()))
+
+	tests := []struct {
+		name   string
+		schema string
+		err    error
+	}{
+		{name: "empty", schema: "", err: tenant.ErrInvalid},
+		{name: "injection", schema: `shop"; DROP SCHEMA public; --`, err: tenant.ErrInvalid},
+		{name: "public", schema: "public", err: tenant.ErrReserved},
+		{name: "system", schema: "pg_catalog", err: tenant.ErrReserved},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operations := map[string]func() error{
+				"Provision":    func() error { return m.Provision(tt.schema) },
+				"CreateSchema": func() error { return m.CreateSchema(tt.schema) },
+				"DropSchema":   func() error { return m.DropSchema(tt.schema) },
+				"Migrate": func() error {
+					_, err := m.Migrate(tt.schema)
+					return err
+				},
+			}
+
+			for name, operation := range operations {
+				if err := operation(); !errors.Is(err, tt.err) {
+					t.Errorf("%s: err = %v, want %v", name, err, tt.err)
+				}
+			}
+		})
+	}
+}
+
+// Пул подключений к реальной БД (параметры подключения - POSTGRES_*).
+// Тест пропускается, если не задана ORDER_INTEGRATION_TESTS.
+func integrationConnectionPool(t *testing.T) *SchemaConnectionPool {
+	if os.Getenv("ORDER_INTEGRATION_TESTS") == "" {
+		t.Skip("ORDER_INTEGRATION_TESTS is not set")
+	}
+
+	env, err := config.GetPostgresEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scp := NewSchemaConnectionPool(Config{
+		Host:     env.PgHost,
+		Port:     env.PgPort,
+		Username: env.PgUser,
+		Password: env.PgPassword,
+		DBName:   env.PgDbName,
+		SSLMode:  env.SSLMode,
+		TimeZone: env.TimeZone,
+	}, logrus.NewEntry(logrus.New()))
+	t.Cleanup(func() { scp.Close() })
+
+	return scp
+}
+
+// Новая схема, удаляемая после теста
+func testSchema(t *testing.T, m *Migrator) string {
+	schema := fmt.Sprintf("migrator_%d", time.Now().UnixNano())
+	if err := m.CreateSchema(schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := m.DropSchema(schema); err != nil {
+			t.Errorf("DropSchema %s: %v", schema, err)
+		}
+	})
+	return schema
+}
+
+type migratorItem struct {
+	ID   uint
+	Name string
+}
+
+func TestMigratorAppliesOnce(t *testing.T) {
+	scp := integrationConnectionPool(t)
+	log := logrus.NewEntry(logrus.New())
+
+	var mu sync.Mutex
+	calls := make(map[uint]int)
+	migration := func(version uint, up func(tx *gorm.DB) error) Migration {
+		return Migration{Version: version, Name: fmt.Sprintf("v%d", version), Up: func(tx *gorm.DB) error {
+			mu.Lock()
+			calls[version]++
+			mu.Unlock()
+			return up(tx)
+		}}
+	}
+
+	migrations := []Migration{
+		migration(1, func(tx *gorm.DB) error { return tx.AutoMigrate(&migratorItem{}) }),
+		migration(2, func(tx *gorm.DB) error { return tx.Create(&migratorItem{Name: "seed"}).Error }),
+	}
+
+	m := NewMigrator(scp, migrations[:1], log)
+	schema := testSchema(t, m)
+
+	applied, err := m.Migrate(schema)
+	if err != nil || applied != 1 {
+		t.Fatalf("first run: applied = %d, err = %v", applied, err)
+	}
+
+	// Параллельные запуски с новой версией применяют её один раз
+	m = NewMigrator(scp, migrations, log)
+	var wg sync.WaitGroup
+	results := make([]int, 4)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = m.Migrate(schema)
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("concurrent run %d: %v", i, errs[i])
+		}
+		total += results[i]
+	}
+	if total != 1 {
+		t.Errorf("concurrent runs applied %d migrations, want 1", total)
+	}
+	if calls[1] != 1 || calls[2] != 1 {
+		t.Errorf("calls = %v, want each migration once", calls)
+	}
+
+	db, err := scp.GetConnectionPool(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	if err := db.Table(schema + ".migrator_items").Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("items = %d, err = %v, want 1", count, err)
+	}
+}
+
+func TestMigratorRollsBackFailedMigration(t *testing.T) {
+	scp := integrationConnectionPool(t)
+	log := logrus.NewEntry(logrus.New())
+
+	failure := errors.New("broken migration")
+	migrations := []Migration{
+		{Version: 1, Name: "items", Up: func(tx *gorm.DB) error { return tx.AutoMigrate(&migratorItem{}) }},
+		{Version: 2, Name: "broken", Up: func(tx *gorm.DB) error {
+			if err := tx.Exec("ALTER TABLE migrator_items ADD COLUMN price integer").Error; err != nil {
+				return err
+			}
+			return failure
+		}},
+	}
+
+	m := NewMigrator(scp, migrations, log)
+	schema := testSchema(t, m)
+
+	applied, err := m.Migrate(schema)
+	if !errors.Is(err, failure) {
+		t.Fatalf("err = %v, want %v", err, failure)
+	}
+	if applied != 1 {
+		t.Errorf("applied = %d, want 1", applied)
+	}
+
+	db, err := scp.GetConnectionPool(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []uint
+	if err := db.Table(schema+".schema_migrations").Order("version").Pluck("version", &versions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0] != 1 {
+		t.Errorf("recorded versions = %v, want [1]", versions)
+	}
+
+	if db.Migrator().HasColumn(&migratorItem{}, "price") {
+		t.Error("column of failed migration was not rolled back")
+	}
+}
@@ -1,13 +1,18 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/mserebryaakov/aggregator-order-service/pkg/tenant"
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
 type Config struct {
@@ -18,107 +23,234 @@ type Config struct {
 	DBName   string
 	SSLMode  string
 	TimeZone string
+
+	// Лимиты общего пула соединений (на все схемы)
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// Время простоя, после которого хэндл схемы тенанта освобождается
+	TenantIdleTTL time.Duration
 }
 
-// func ConnectionToDb(cfg Config) (*gorm.DB, error) {
-// 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=%s",
-// 		cfg.Host, cfg.Username, cfg.Password, cfg.DBName, cfg.Port, cfg.SSLMode, cfg.TimeZone)
-// 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+const (
+	defaultMaxOpenConns    = 50
+	defaultMaxIdleConns    = 10
+	defaultConnMaxLifetime = time.Hour
+	defaultTenantIdleTTL   = 30 * time.Minute
+)
 
-// 	if err != nil {
-// 		return nil, err
-// 	}
+var ErrPoolClosed = errors.New("connection pool is closed")
 
-// 	return db, nil
-// }
+type tenantPool struct {
+	db       *gorm.DB
+	lastUsed time.Time
+}
 
+// Пул соединений с разделением по схемам. Все схемы используют одно
+// соединение с БД (sql.DB); запросы тенанта адресуются в его схему через
+// префикс таблиц, поэтому не зависят от search_path конкретного соединения.
 type SchemaConnectionPool struct {
 	sync.Mutex
-	pools              map[string]*gorm.DB
+	cfg                Config
+	sqlDB              *sql.DB
+	public             *gorm.DB
+	pools              map[string]*tenantPool
 	dbConnectionString string
+	dialector          func(dsn string) gorm.Dialector // открытие соединения (в тестах - драйвер-заглушка)
+	closed             bool
 	log                *logrus.Entry
 }
 
 func NewSchemaConnectionPool(cfg Config, log *logrus.Entry) *SchemaConnectionPool {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=%s",
 		cfg.Host, cfg.Username, cfg.Password, cfg.DBName, cfg.Port, cfg.SSLMode, cfg.TimeZone)
+
+	if cfg.MaxOpenConns <= 0 {
+		cfg.MaxOpenConns = defaultMaxOpenConns
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = defaultMaxIdleConns
+	}
+	if cfg.ConnMaxLifetime <= 0 {
+		cfg.ConnMaxLifetime = defaultConnMaxLifetime
+	}
+	if cfg.TenantIdleTTL <= 0 {
+		cfg.TenantIdleTTL = defaultTenantIdleTTL
+	}
+
 	return &SchemaConnectionPool{
+		cfg:                cfg,
 		dbConnectionString: dsn,
+		dialector:          postgres.Open,
 		log:                log,
-		pools:              make(map[string]*gorm.DB),
+		pools:              make(map[string]*tenantPool),
 	}
 }
 
-func (scp *SchemaConnectionPool) GetConnectionPool(schemaName string) (*gorm.DB, error) {
+// Удаление хэндла схемы (например, после удаления схемы).
+// Общие соединения при этом не закрываются.
+func (scp *SchemaConnectionPool) Evict(schemaName string) {
 	scp.Lock()
 	defer scp.Unlock()
 
-	if pool, ok := scp.pools[schemaName]; ok {
-		return pool, nil
+	delete(scp.pools, schemaName)
+}
+
+func (scp *SchemaConnectionPool) GetConnectionPool(schemaName string) (*gorm.DB, error) {
+	public, sqlDB, db, err := scp.cached(schemaName)
+	if err != nil || db != nil {
+		return db, err
+	}
+
+	// Имя схемы попадает в префикс таблиц, поэтому допускаются только корректные идентификаторы
+	if _, err := tenant.Parse(schemaName); err != nil {
+		return nil, fmt.Errorf("schema %q: %w", schemaName, err)
+	}
+
+	// Проверка схемы выполняется без блокировки пула, чтобы не задерживать запросы других тенантов
+	var count int64
+	err = public.Raw("SELECT COUNT(*) FROM pg_namespace WHERE nspname = ?", schemaName).Scan(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("schema not found: %s", schemaName)
 	}
 
-	db, err := gorm.Open(postgres.Open(scp.dbConnectionString), &gorm.Config{
-		PrepareStmt: true,
+	db, err = gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{TablePrefix: schemaName + "."},
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println("УСПЕШНО ОТКРЫЛ СОЕДИНЕНИЕ")
+	scp.Lock()
+	if !scp.closed && scp.sqlDB != sqlDB {
+		// Соединение переоткрыто, пока шла проверка: хэндл создаётся на новом соединении
+		scp.Unlock()
+		return scp.GetConnectionPool(schemaName)
+	}
+	defer scp.Unlock()
 
-	sqlDB, err := db.DB()
+	if scp.closed {
+		return nil, ErrPoolClosed
+	}
+
+	// Хэндл мог быть создан параллельным запросом, пока шла проверка
+	if pool, ok := scp.pools[schemaName]; ok {
+		pool.lastUsed = time.Now()
+		return pool.db, nil
+	}
+
+	scp.pools[schemaName] = &tenantPool{db: db, lastUsed: time.Now()}
+
+	return db, nil
+}
+
+// Хэндл из кэша (public или уже открытой схемы); для новой схемы возвращает общее соединение
+func (scp *SchemaConnectionPool) cached(schemaName string) (*gorm.DB, *sql.DB, *gorm.DB, error) {
+	scp.Lock()
+	defer scp.Unlock()
+
+	if scp.closed {
+		return nil, nil, nil, ErrPoolClosed
+	}
+
+	if err := scp.open(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if schemaName == "public" {
+		return scp.public, scp.sqlDB, scp.public, nil
+	}
+
+	if pool, ok := scp.pools[schemaName]; ok {
+		pool.lastUsed = time.Now()
+		return scp.public, scp.sqlDB, pool.db, nil
+	}
+
+	return scp.public, scp.sqlDB, nil, nil
+}
+
+// Открытие общего соединения с БД при первом обращении
+func (scp *SchemaConnectionPool) open() error {
+	if scp.public != nil {
+		return nil
+	}
+
+	db, sqlDB, err := scp.connect()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	sqlDB.SetMaxIdleConns(1)
-	sqlDB.SetMaxOpenConns(100)
-	sqlDB.SetConnMaxLifetime(time.Hour)
+	scp.public = db
+	scp.sqlDB = sqlDB
 
-	var count int64
-	db.Raw("SELECT COUNT(*) FROM pg_namespace WHERE nspname = ?", schemaName).Scan(&count)
-	if count == 0 {
-		return nil, fmt.Errorf("schema not found: %s", schemaName)
+	scp.log.Infof("postgres: connection pool opened (max open conns - %d)", scp.cfg.MaxOpenConns)
+
+	return nil
+}
+
+func (scp *SchemaConnectionPool) connect() (*gorm.DB, *sql.DB, error) {
+	db, err := gorm.Open(scp.dialector(scp.dbConnectionString), &gorm.Config{})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	err = db.Exec("SET search_path TO " + schemaName).Error
+	sqlDB, err := db.DB()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	scp.pools[schemaName] = db
+	sqlDB.SetMaxOpenConns(scp.cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(scp.cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(scp.cfg.ConnMaxLifetime)
 
-	go func() {
-		ticker := time.NewTicker(time.Second * 10)
-		defer ticker.Stop()
+	return db, sqlDB, nil
+}
 
-		for range ticker.C {
-			if err := sqlDB.Ping(); err != nil {
-				db, err := gorm.Open(postgres.Open(scp.dbConnectionString), &gorm.Config{
-					PrepareStmt: true,
-				})
-				if err != nil {
-					continue
-				}
+// Закрытие общего соединения; после закрытия пул не выдаёт хэндлы
+func (scp *SchemaConnectionPool) Close() error {
+	scp.Lock()
+	defer scp.Unlock()
 
-				sqlDB, err = db.DB()
-				if err != nil {
-					continue
-				}
+	if scp.closed {
+		return nil
+	}
+	scp.closed = true
+	scp.pools = make(map[string]*tenantPool)
 
-				sqlDB.SetMaxIdleConns(1)
-				sqlDB.SetMaxOpenConns(100)
-				sqlDB.SetConnMaxLifetime(time.Hour)
+	if scp.sqlDB == nil {
+		return nil
+	}
 
-				err = db.Exec("SET search_path TO " + schemaName).Error
-				if err != nil {
-					continue
-				}
+	return scp.sqlDB.Close()
+}
 
-				scp.log.Infof("succes ping %s pool", schemaName)
-			}
+// Периодическое освобождение хэндлов схем, не использовавшихся дольше TenantIdleTTL
+func (scp *SchemaConnectionPool) RunEviction(ctx context.Context) {
+	ticker := time.NewTicker(scp.cfg.TenantIdleTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scp.evictIdle(time.Now().Add(-scp.cfg.TenantIdleTTL))
 		}
-	}()
+	}
+}
 
-	return db, nil
+func (scp *SchemaConnectionPool) evictIdle(before time.Time) {
+	scp.Lock()
+	defer scp.Unlock()
+
+	for schema, pool := range scp.pools {
+		if pool.lastUsed.Before(before) {
+			delete(scp.pools, schema)
+			scp.log.Debugf("postgres: evicted idle schema %s", schema)
+		}
+	}
 }
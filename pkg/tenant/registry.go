@@ -0,0 +1,124 @@
+package tenant
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Реестр известных тенантов. Позволяет отклонять запросы к неизвестным
+// магазинам без обращения к БД; периодически обновляется из источника load.
+type Registry struct {
+	sync.RWMutex
+	tenants  map[ID]struct{}
+	added    map[ID]struct{} // добавленные во время загрузки списка
+	load     func() ([]string, error)
+	interval time.Duration
+	log      *logrus.Entry
+}
+
+const defaultRefreshInterval = time.Minute
+
+func NewRegistry(load func() ([]string, error), interval time.Duration, log *logrus.Entry) *Registry {
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+
+	return &Registry{
+		tenants:  make(map[ID]struct{}),
+		load:     load,
+		interval: interval,
+		log:      log,
+	}
+}
+
+// Загрузка списка тенантов; некорректные имена схем пропускаются
+func (r *Registry) Refresh() error {
+	r.Lock()
+	r.added = make(map[ID]struct{})
+	r.Unlock()
+
+	schemas, err := r.load()
+	if err != nil {
+		r.Lock()
+		r.added = nil
+		r.Unlock()
+		return err
+	}
+
+	tenants := make(map[ID]struct{}, len(schemas))
+	for _, schema := range schemas {
+		id, err := Parse(schema)
+		if err != nil || id.String() != schema {
+			r.log.Warnf("tenant registry: skip schema %q - %v", schema, err)
+			continue
+		}
+		tenants[id] = struct{}{}
+	}
+
+	r.Lock()
+	for id := range r.added {
+		tenants[id] = struct{}{}
+	}
+	r.tenants = tenants
+	r.added = nil
+	r.Unlock()
+
+	return nil
+}
+
+// Периодическое обновление до отмены контекста
+func (r *Registry) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Refresh(); err != nil {
+				r.log.Errorf("tenant registry: refresh err - %v", err)
+			}
+		}
+	}
+}
+
+func (r *Registry) Has(id ID) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	_, ok := r.tenants[id]
+	return ok
+}
+
+func (r *Registry) Add(id ID) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.tenants[id] = struct{}{}
+	if r.added != nil {
+		r.added[id] = struct{}{}
+	}
+}
+
+func (r *Registry) Remove(id ID) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.tenants, id)
+	delete(r.added, id)
+}
+
+func (r *Registry) List() []ID {
+	r.RLock()
+	defer r.RUnlock()
+
+	ids := make([]ID, 0, len(r.tenants))
+	for id := range r.tenants {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,87 @@
+package tenant
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testRegistry(load func() ([]string, error)) *Registry {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewRegistry(load, 0, logrus.NewEntry(logger))
+}
+
+func TestRegistryRefresh(t *testing.T) {
+	schemas := []string{"shop1", "Shop2", "pg_temp", "bad.name", "shop3"}
+	r := testRegistry(func() ([]string, error) { return schemas, nil })
+	r.Add("stale")
+
+	if err := r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id  ID
+		has bool
+	}{
+		{id: "shop1", has: true},
+		{id: "shop3", has: true},
+		{id: "shop2", has: false}, // имя схемы в другом регистре не является идентификатором тенанта
+		{id: "stale", has: false}, // список заменяется целиком
+	}
+	for _, tt := range tests {
+		if has := r.Has(tt.id); has != tt.has {
+			t.Errorf("Has(%q) = %v, want %v", tt.id, has, tt.has)
+		}
+	}
+	if n := len(r.List()); n != 2 {
+		t.Errorf("List() = %d tenants, want 2", n)
+	}
+}
+
+// Тенант, подключённый во время загрузки списка, не теряется при замене списка
+func TestRegistryKeepsTenantAddedDuringRefresh(t *testing.T) {
+	var r *Registry
+	r = testRegistry(func() ([]string, error) {
+		r.Add("new-shop")
+		return []string{"shop1"}, nil
+	})
+
+	if err := r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Has("new-shop") || !r.Has("shop1") {
+		t.Errorf("tenants = %v, want shop1 and new-shop", r.List())
+	}
+
+	r.Remove("new-shop")
+	if r.Has("new-shop") {
+		t.Error("removed tenant is still registered")
+	}
+}
+
+func TestRegistryRefreshErrorKeepsTenants(t *testing.T) {
+	failure := errors.New("db is down")
+	fail := false
+	r := testRegistry(func() ([]string, error) {
+		if fail {
+			return nil, failure
+		}
+		return []string{"shop1"}, nil
+	})
+
+	if err := r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	fail = true
+	if err := r.Refresh(); !errors.Is(err, failure) {
+		t.Fatalf("err = %v, want %v", err, failure)
+	}
+	if !r.Has("shop1") {
+		t.Error("tenants were dropped after failed refresh")
+	}
+}
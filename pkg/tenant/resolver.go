@@ -0,0 +1,219 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var ErrNotResolved = errors.New("tenant is not defined")
+
+// Способы определения тенанта по запросу
+const (
+	SubdomainStrategy = "subdomain"
+	HeaderStrategy    = "header"
+	PathStrategy      = "path"
+	QueryStrategy     = "query"
+)
+
+// Определение тенанта по входящему запросу
+type Resolver interface {
+	Resolve(r *http.Request) (ID, error)
+}
+
+type ResolverConfig struct {
+	Strategy       string
+	BaseDomain     string
+	Header         string
+	TrustedProxies []string
+	PathPrefix     string
+	QueryParam     string
+}
+
+func NewResolver(cfg ResolverConfig) (Resolver, error) {
+	switch cfg.Strategy {
+	case SubdomainStrategy, "":
+		return &SubdomainResolver{BaseDomain: strings.ToLower(strings.Trim(cfg.BaseDomain, "."))}, nil
+	case HeaderStrategy:
+		header := cfg.Header
+		if header == "" {
+			header = "X-Tenant"
+		}
+
+		proxies := make([]*net.IPNet, 0, len(cfg.TrustedProxies))
+		for _, cidr := range cfg.TrustedProxies {
+			if !strings.Contains(cidr, "/") {
+				if strings.Contains(cidr, ":") {
+					cidr += "/128"
+				} else {
+					cidr += "/32"
+				}
+			}
+
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", cidr, err)
+			}
+			proxies = append(proxies, network)
+		}
+
+		return &HeaderResolver{Header: header, TrustedProxies: proxies}, nil
+	case PathStrategy:
+		prefix := strings.TrimRight(cfg.PathPrefix, "/")
+		if prefix == "" {
+			prefix = "/t"
+		}
+		return &PathResolver{Prefix: prefix}, nil
+	case QueryStrategy:
+		param := cfg.QueryParam
+		if param == "" {
+			param = "tenant"
+		}
+		return &QueryResolver{Param: param}, nil
+	default:
+		return nil, fmt.Errorf("unknown tenant resolver strategy - %s", cfg.Strategy)
+	}
+}
+
+// Тенант - поддомен базового домена: shop.example.com -> shop.
+// Без базового домена используется первая метка хоста из двух (shop.localhost).
+type SubdomainResolver struct {
+	BaseDomain string
+}
+
+func (s *SubdomainResolver) Resolve(r *http.Request) (ID, error) {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	var label string
+	if s.BaseDomain != "" {
+		if !strings.HasSuffix(host, "."+s.BaseDomain) {
+			return "", ErrNotResolved
+		}
+		label = strings.TrimSuffix(host, "."+s.BaseDomain)
+	} else {
+		labels := strings.Split(host, ".")
+		if len(labels) != 2 {
+			return "", ErrNotResolved
+		}
+		label = labels[0]
+	}
+
+	if label == "" || strings.Contains(label, ".") {
+		return "", ErrNotResolved
+	}
+
+	return Parse(label)
+}
+
+// Тенант из заголовка, выставляемого шлюзом; учитывается только от доверенных прокси
+type HeaderResolver struct {
+	Header         string
+	TrustedProxies []*net.IPNet
+}
+
+func (h *HeaderResolver) Resolve(r *http.Request) (ID, error) {
+	if !h.trusted(r.RemoteAddr) {
+		return "", ErrNotResolved
+	}
+
+	value := r.Header.Get(h.Header)
+	if value == "" {
+		return "", ErrNotResolved
+	}
+
+	return Parse(value)
+}
+
+func (h *HeaderResolver) trusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range h.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Тенант из первого сегмента пути после префикса: /t/shop/order -> shop.
+// Маршруты регистрируются без префикса, его снимает StripPrefix.
+type PathResolver struct {
+	Prefix string
+}
+
+type contextKey struct{}
+
+func (p *PathResolver) Resolve(r *http.Request) (ID, error) {
+	if id, ok := r.Context().Value(contextKey{}).(ID); ok {
+		return id, nil
+	}
+
+	id, _, err := p.split(r.URL.Path)
+	return id, err
+}
+
+// Снятие префикса и тенанта из пути перед маршрутизацией; тенант сохраняется в контексте запроса.
+// Запросы без префикса передаются как есть.
+func (p *PathResolver) StripPrefix(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, rest, err := p.split(r.URL.Path)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u := *r.URL
+		u.Path = rest
+		u.RawPath = ""
+
+		r = r.WithContext(context.WithValue(r.Context(), contextKey{}, id))
+		r.URL = &u
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *PathResolver) split(path string) (ID, string, error) {
+	if !strings.HasPrefix(path, p.Prefix+"/") {
+		return "", "", ErrNotResolved
+	}
+
+	segment, rest, _ := strings.Cut(strings.TrimPrefix(path, p.Prefix+"/"), "/")
+	if segment == "" {
+		return "", "", ErrNotResolved
+	}
+
+	id, err := Parse(segment)
+	if err != nil {
+		return "", "", err
+	}
+
+	return id, "/" + rest, nil
+}
+
+// Тенант из параметра запроса
+type QueryResolver struct {
+	Param string
+}
+
+func (q *QueryResolver) Resolve(r *http.Request) (ID, error) {
+	value := r.URL.Query().Get(q.Param)
+	if value == "" {
+		return "", ErrNotResolved
+	}
+
+	return Parse(value)
+}
@@ -0,0 +1,206 @@
+package tenant
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewResolver(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ResolverConfig
+		wantErr bool
+	}{
+		{name: "default", cfg: ResolverConfig{}},
+		{name: "header with cidr and single addresses", cfg: ResolverConfig{Strategy: HeaderStrategy, TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1", "::1"}}},
+		{name: "invalid proxy", cfg: ResolverConfig{Strategy: HeaderStrategy, TrustedProxies: []string{"proxy.local"}}, wantErr: true},
+		{name: "unknown strategy", cfg: ResolverConfig{Strategy: "cookie"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewResolver(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error - %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type resolveCase struct {
+	name    string
+	request func() *http.Request
+	id      ID
+	err     error
+}
+
+func runResolveCases(t *testing.T, resolver Resolver, tests []resolveCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := resolver.Resolve(tt.request())
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+			if id != tt.id {
+				t.Errorf("id = %q, want %q", id, tt.id)
+			}
+		})
+	}
+}
+
+func hostRequest(host string) func() *http.Request {
+	return func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/order", nil)
+		r.Host = host
+		return r
+	}
+}
+
+func TestSubdomainResolver(t *testing.T) {
+	withBase, err := NewResolver(ResolverConfig{Strategy: SubdomainStrategy, BaseDomain: ".Example.com."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runResolveCases(t, withBase, []resolveCase{
+		{name: "subdomain", request: hostRequest("shop1.example.com"), id: "shop1"},
+		{name: "with port and upper case", request: hostRequest("Shop1.Example.com:8080"), id: "shop1"},
+		{name: "base domain itself", request: hostRequest("example.com"), err: ErrNotResolved},
+		{name: "nested subdomain", request: hostRequest("a.shop1.example.com"), err: ErrNotResolved},
+		{name: "other domain", request: hostRequest("shop1.example.org"), err: ErrNotResolved},
+		{name: "suffix without dot", request: hostRequest("shopexample.com"), err: ErrNotResolved},
+		{name: "reserved", request: hostRequest("public.example.com"), err: ErrReserved},
+	})
+
+	withoutBase, err := NewResolver(ResolverConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runResolveCases(t, withoutBase, []resolveCase{
+		{name: "two labels", request: hostRequest("shop1.localhost:8080"), id: "shop1"},
+		{name: "three labels", request: hostRequest("shop1.example.com"), err: ErrNotResolved},
+		{name: "bare host", request: hostRequest("localhost"), err: ErrNotResolved},
+	})
+}
+
+func TestHeaderResolverTrustsOnlyProxies(t *testing.T) {
+	resolver, err := NewResolver(ResolverConfig{
+		Strategy:       HeaderStrategy,
+		TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1", "::1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(remoteAddr, tenant string) func() *http.Request {
+		return func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/order", nil)
+			r.RemoteAddr = remoteAddr
+			if tenant != "" {
+				r.Header.Set("X-Tenant", tenant)
+			}
+			return r
+		}
+	}
+
+	runResolveCases(t, resolver, []resolveCase{
+		{name: "trusted network", request: request("10.1.2.3:5000", "shop1"), id: "shop1"},
+		{name: "trusted address", request: request("192.168.1.1:5000", "Shop1"), id: "shop1"},
+		{name: "trusted ipv6", request: request("[::1]:5000", "shop1"), id: "shop1"},
+		{name: "neighbour of trusted address", request: request("192.168.1.2:5000", "shop1"), err: ErrNotResolved},
+		{name: "untrusted client", request: request("203.0.113.5:5000", "shop1"), err: ErrNotResolved},
+		{name: "remote address without port", request: request("10.0.0.1", "shop1"), id: "shop1"},
+		{name: "invalid remote address", request: request("proxy:5000", "shop1"), err: ErrNotResolved},
+		{name: "no header", request: request("10.1.2.3:5000", ""), err: ErrNotResolved},
+		{name: "invalid tenant", request: request("10.1.2.3:5000", "shop 1"), err: ErrInvalid},
+	})
+
+	noProxies, err := NewResolver(ResolverConfig{Strategy: HeaderStrategy})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runResolveCases(t, noProxies, []resolveCase{
+		{name: "header is ignored without trusted proxies", request: request("127.0.0.1:5000", "shop1"), err: ErrNotResolved},
+	})
+}
+
+func TestPathResolver(t *testing.T) {
+	resolver, err := NewResolver(ResolverConfig{Strategy: PathStrategy, PathPrefix: "/t/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pathRequest := func(path string) func() *http.Request {
+		return func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, path, nil)
+		}
+	}
+
+	runResolveCases(t, resolver, []resolveCase{
+		{name: "tenant segment", request: pathRequest("/t/shop1/order/5"), id: "shop1"},
+		{name: "tenant only", request: pathRequest("/t/shop1"), id: "shop1"},
+		{name: "no prefix", request: pathRequest("/order"), err: ErrNotResolved},
+		{name: "empty segment", request: pathRequest("/t//order"), err: ErrNotResolved},
+		{name: "prefix without slash", request: pathRequest("/tshop1/order"), err: ErrNotResolved},
+		{name: "reserved", request: pathRequest("/t/public/order"), err: ErrReserved},
+	})
+}
+
+func TestPathResolverStripPrefix(t *testing.T) {
+	resolver := &PathResolver{Prefix: "/t"}
+
+	tests := []struct {
+		path string
+		want string
+		id   ID
+		err  error
+	}{
+		{path: "/t/shop1/order/5", want: "/order/5", id: "shop1"},
+		{path: "/t/shop1", want: "/", id: "shop1"},
+		{path: "/healthz", want: "/healthz", err: ErrNotResolved},
+	}
+
+	for _, tt := range tests {
+		var gotPath string
+		var gotID ID
+		var gotErr error
+		handler := resolver.StripPrefix(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			gotID, gotErr = resolver.Resolve(r)
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if gotPath != tt.want {
+			t.Errorf("%s: path = %q, want %q", tt.path, gotPath, tt.want)
+		}
+		if gotID != tt.id || !errors.Is(gotErr, tt.err) {
+			t.Errorf("%s: resolved %q (err - %v), want %q (err - %v)", tt.path, gotID, gotErr, tt.id, tt.err)
+		}
+	}
+}
+
+func TestQueryResolver(t *testing.T) {
+	resolver, err := NewResolver(ResolverConfig{Strategy: QueryStrategy})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queryRequest := func(target string) func() *http.Request {
+		return func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, target, nil)
+		}
+	}
+
+	runResolveCases(t, resolver, []resolveCase{
+		{name: "param", request: queryRequest("/order?tenant=shop1"), id: "shop1"},
+		{name: "no param", request: queryRequest("/order?shop=shop1"), err: ErrNotResolved},
+		{name: "invalid", request: queryRequest("/order?tenant=shop%3B1"), err: ErrInvalid},
+	})
+}
@@ -0,0 +1,45 @@
+package tenant
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Максимальная длина идентификатора PostgreSQL
+const maxLength = 63
+
+var (
+	ErrInvalid  = errors.New("invalid tenant identifier")
+	ErrReserved = errors.New("reserved tenant identifier")
+)
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+var reserved = map[string]struct{}{
+	"public":             {},
+	"information_schema": {},
+}
+
+// Идентификатор тенанта (домен магазина), он же имя схемы в БД
+type ID string
+
+// Разбор и проверка идентификатора: латиница в нижнем регистре, цифры, `_` и `-`,
+// не длиннее 63 символов и не совпадает с системными схемами
+func Parse(s string) (ID, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	if s == "" || len(s) > maxLength || !validID.MatchString(s) {
+		return "", ErrInvalid
+	}
+
+	if _, ok := reserved[s]; ok || strings.HasPrefix(s, "pg_") {
+		return "", ErrReserved
+	}
+
+	return ID(s), nil
+}
+
+func (id ID) String() string {
+	return string(id)
+}
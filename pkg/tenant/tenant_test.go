@@ -0,0 +1,41 @@
+package tenant
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in  string
+		id  ID
+		err error
+	}{
+		{in: "shop1", id: "shop1"},
+		{in: "  Shop-1_a ", id: "shop-1_a"},
+		{in: "1shop", id: "1shop"},
+		{in: strings.Repeat("a", 63), id: ID(strings.Repeat("a", 63))},
+		{in: strings.Repeat("a", 64), err: ErrInvalid},
+		{in: "", err: ErrInvalid},
+		{in: "_shop", err: ErrInvalid},
+		{in: "-shop", err: ErrInvalid},
+		{in: "shop.example", err: ErrInvalid},
+		{in: `shop"; drop schema public`, err: ErrInvalid},
+		{in: "магазин", err: ErrInvalid},
+		{in: "public", err: ErrReserved},
+		{in: "INFORMATION_SCHEMA", err: ErrReserved},
+		{in: "pg_catalog", err: ErrReserved},
+		{in: "pg_shop", err: ErrReserved},
+	}
+
+	for _, tt := range tests {
+		id, err := Parse(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q) err = %v, want %v", tt.in, err, tt.err)
+		}
+		if id != tt.id {
+			t.Errorf("Parse(%q) = %q, want %q", tt.in, id, tt.id)
+		}
+	}
+}